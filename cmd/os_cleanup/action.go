@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
+	"time"
 
+	"github.com/jjo/openstack-ops/pkg/audit"
+	"github.com/jjo/openstack-ops/pkg/journal"
+	"github.com/jjo/openstack-ops/pkg/notify"
 	"github.com/jjo/openstack-ops/pkg/openstack"
 
 	"github.com/jedib0t/go-pretty/v6/table"
@@ -17,6 +23,9 @@ const (
 	DELETE
 	TAG
 	UNTAG
+	LIFECYCLE
+	NOTIFY
+	PURGE
 )
 
 const (
@@ -29,12 +38,15 @@ const (
 
 var (
 	actionsMap = map[string]int{
-		"list":   LIST,
-		"stop":   STOP,
-		"start":  START,
-		"delete": DELETE,
-		"tag":    TAG,
-		"untag":  UNTAG,
+		"list":      LIST,
+		"stop":      STOP,
+		"start":     START,
+		"delete":    DELETE,
+		"tag":       TAG,
+		"untag":     UNTAG,
+		"lifecycle": LIFECYCLE,
+		"notify":    NOTIFY,
+		"purge":     PURGE,
 	}
 	outputMap = map[string]int{
 		"table": TABLE,
@@ -53,15 +65,30 @@ func codeNum(str string, strMap map[string]int) int {
 	return ret
 }
 
+func codeStr(code int, strMap map[string]int) string {
+	for str, c := range strMap {
+		if c == code {
+			return str
+		}
+	}
+	return fmt.Sprintf("%d", code)
+}
+
 func actionRun(
-	instances []openstack.OSResourceInterface, actionCode, outputCode int,
+	ctx context.Context, instances []openstack.OSResourceInterface, actionCode, outputCode int,
 	outFile *os.File, opts *cliOptions,
 ) error {
 	switch actionCode {
 	case LIST:
 		return actionList(instances, outputCode, outFile)
 	case STOP, START, DELETE, TAG, UNTAG:
-		return actionPerResource(instances, actionCode, opts)
+		return actionPerResource(ctx, instances, actionCode, opts)
+	case LIFECYCLE:
+		return actionLifecycle(ctx, instances, opts, time.Now())
+	case NOTIFY:
+		return actionNotify(ctx, instances, opts, time.Now())
+	case PURGE:
+		return actionPurge(ctx, instances, opts, time.Now())
 	}
 	return fmt.Errorf("Invalid action code: %d", actionCode)
 }
@@ -108,57 +135,420 @@ func actionList(instances []openstack.OSResourceInterface, outputCode int, outFi
 	return nil
 }
 
+// auditRecord logs a mutating action on resource, if --audit-log was given
+func auditRecord(opts *cliOptions, action string, resource openstack.OSResourceInterface, err error) {
+	if opts.audit == nil {
+		return
+	}
+
+	id, name, project := resource.GetData()
+	record := audit.Record{
+		Action:    action,
+		Kind:      resource.GetKind(),
+		ID:        id,
+		Name:      name,
+		Project:   project,
+		ProjectID: resource.GetProjectID(),
+		Cloud:     resource.GetCloud(),
+		Region:    resource.GetRegion(),
+		Email:     resource.GetEmail(),
+		Filter:    opts.filterDesc,
+		DryRun:    !opts.doit,
+		Result:    audit.ResultOK,
+	}
+	switch {
+	case err != nil:
+		record.Result = audit.ResultError
+		record.Error = err.Error()
+	case !opts.doit:
+		record.Result = audit.ResultDryRun
+	}
+	if err := opts.audit.Log(record); err != nil {
+		log.Errorf("Error writing audit log: %s\n", err)
+	}
+}
+
+// journaled tells whether actionCode runs are recorded for undo
+func journaled(actionCode int) bool {
+	switch actionCode {
+	case STOP, START, DELETE, TAG, UNTAG:
+		return true
+	}
+	return false
+}
+
+// journalRecord saves resource state before the action, as it was listed,
+// into the run journal if any
+func journalRecord(opts *cliOptions, action string, resource openstack.OSResourceInterface, err error) {
+	if opts.journal == nil {
+		return
+	}
+
+	id, name, project := resource.GetData()
+	entry := journal.Entry{
+		Action:  action,
+		Kind:    resource.GetKind(),
+		ID:      id,
+		Name:    name,
+		Project: project,
+		Cloud:   resource.GetCloud(),
+		Region:  resource.GetRegion(),
+		Tags:    resource.GetTags(),
+		Result:  journal.ResultOK,
+	}
+	if power, ok := resource.(openstack.PowerController); ok {
+		entry.PowerState = power.GetPowerState()
+	}
+	if action == "tag" || action == "untag" {
+		entry.Tag = opts.tagValue
+	}
+	if err != nil {
+		entry.Result = journal.ResultError
+		entry.Error = err.Error()
+	}
+	if err := opts.journal.Add(entry); err != nil {
+		log.Errorf("Error writing run journal: %s\n", err)
+	}
+}
+
+// dispatchContext is done when no more actions should be started: ctx done
+// (e.g. --timeout), or the run interrupted, in-flight actions still using ctx
+func dispatchContext(ctx context.Context, opts *cliOptions) context.Context {
+	if opts.interrupt != nil {
+		return opts.interrupt
+	}
+	return ctx
+}
+
+// skipResource records action wasn't started on resource, e.g. interrupted
+func skipResource(run *RunResult, resource openstack.OSResourceInterface, action string, reason error) {
+	log.Warningf("Skipping %s %s: %s\n", action, resource.String(), reason)
+	id, name, project := resource.GetData()
+	run.Skip(resource.GetKind(), id, name, project, action, reason.Error())
+}
+
 func yesnoStr(yes bool, msg string) string {
 	yn := map[bool]string{true: "", false: "**NOT**(missing --yes) "}[yes]
 	return fmt.Sprintf("%s%s", yn, msg)
 }
 
-func actionPerResource(resources []openstack.OSResourceInterface, actionCode int, opts *cliOptions) error {
+// supportsAction checks resource capabilities needed by actionCode
+func supportsAction(resource openstack.OSResourceInterface, actionCode int) bool {
+	var ok bool
+	switch actionCode {
+	case STOP, START:
+		_, ok = resource.(openstack.PowerController)
+	case DELETE:
+		_, ok = resource.(openstack.Deleter)
+	case TAG, UNTAG:
+		_, ok = resource.(openstack.Tagger)
+	case PURGE:
+		_, ok = resource.(openstack.Expirer)
+		if ok {
+			_, ok = resource.(openstack.Deleter)
+		}
+	}
+	return ok
+}
+
+// actionResource runs actionCode on a single resource, returns the logged
+// action message and error if any
+func actionResource(
+	ctx context.Context, resource openstack.OSResourceInterface, actionCode int, opts *cliOptions,
+) (string, error) {
 	var err error
 	var msg string
 
+	switch actionCode {
+	case STOP:
+		msg = "Stopping"
+		log.Infof("%s: %s\n", yesnoStr(opts.doit, msg), resource.String())
+
+		if opts.doit {
+			err = resource.(openstack.PowerController).Stop(ctx)
+		}
+	case START:
+		msg = "Starting"
+		log.Infof("%s: %s\n", yesnoStr(opts.doit, msg), resource.String())
+
+		if opts.doit {
+			err = resource.(openstack.PowerController).Start(ctx)
+		}
+	case DELETE:
+		msg = "Deleting"
+		log.Infof("%s: %s\n", yesnoStr(opts.doit, msg), resource.String())
+
+		if opts.doit {
+			err = resource.(openstack.Deleter).Delete(ctx)
+		}
+	case TAG:
+		msg = "Tagging"
+		log.Infof("%s: %s <- %s\n", yesnoStr(opts.doit, msg), resource.String(), opts.tagValue)
+
+		if opts.doit {
+			err = resource.(openstack.Tagger).Tag(ctx, opts.tagValue)
+		}
+	case UNTAG:
+		msg = "Untagging"
+		log.Infof("%s: %s <- %s\n", yesnoStr(opts.doit, msg), resource.String(), opts.tagValue)
+
+		if opts.doit {
+			err = resource.(openstack.Tagger).Untag(ctx, opts.tagValue)
+		}
+	}
+	return msg, err
+}
+
+// actionPerResource runs actionCode on resources concurrently, as limited by
+// --workers, --per-project and --rate, recording each outcome in the run
+// result, returns a *RunError with all failures if any. Once interrupted,
+// the resources not started yet are skipped
+func actionPerResource(
+	ctx context.Context, resources []openstack.OSResourceInterface, actionCode int, opts *cliOptions,
+) error {
+	// Fail before touching anything if some resource can't do it
 	for _, resource := range resources {
-		switch actionCode {
-		case STOP:
-			msg = "Stopping server"
-			log.Infof("%s: %s\n", yesnoStr(opts.doit, msg), resource.String())
+		if !supportsAction(resource, actionCode) {
+			return fmt.Errorf("action %s not supported for kind %s",
+				codeStr(actionCode, actionsMap), resource.GetKind())
+		}
+	}
 
-			if opts.doit {
-				err = resource.Stop()
+	run := runResult(opts)
+	runner := newActionRunner(dispatchContext(ctx, opts), opts.workers, opts.perProject, opts.rate)
+	for _, resource := range resources {
+		resource := resource
+		runner.Submit(resource.GetProjectID(), func() {
+			msg, err := actionResource(ctx, resource, actionCode, opts)
+
+			auditRecord(opts, codeStr(actionCode, actionsMap), resource, err)
+			journalRecord(opts, codeStr(actionCode, actionsMap), resource, err)
+			run.Add(resource, codeStr(actionCode, actionsMap), opts.doit, err)
+			if err != nil {
+				log.Errorf("Error %s %s: %s\n", msg, resource.String(), err)
 			}
-		case START:
-			msg = "Starting server"
-			log.Infof("%s: %s\n", yesnoStr(opts.doit, msg), resource.String())
+		}, func(reason error) {
+			skipResource(run, resource, codeStr(actionCode, actionsMap), reason)
+		})
+	}
+	runner.Wait()
 
-			if opts.doit {
-				err = resource.Start()
+	return run.Err()
+}
+
+// restartLifecycle replaces the stage tags of resource by a new tagged one,
+// so that it's stopped again only after the stop grace period
+func restartLifecycle(ctx context.Context, resource openstack.OSResourceInterface, lifecycle *openstack.Lifecycle, now time.Time) error {
+	tagger := resource.(openstack.Tagger)
+	for _, tag := range lifecycle.StageTags(resource.GetTags()) {
+		if err := tagger.Untag(ctx, tag); err != nil {
+			return err
+		}
+	}
+	return tagger.Tag(ctx, lifecycle.StageTag(openstack.StageTagged, now))
+}
+
+// actionLifecycle advances each resource at most one lifecycle stage, as
+// recorded in its tags: tag -> (stopGrace days) -> stop -> (deleteGrace days) -> delete
+func actionLifecycle(ctx context.Context, resources []openstack.OSResourceInterface, opts *cliOptions, now time.Time) error {
+	run := runResult(opts)
+
+	for _, resource := range resources {
+		for _, actionCode := range []int{TAG, STOP, DELETE} {
+			if !supportsAction(resource, actionCode) {
+				return fmt.Errorf("action lifecycle not supported for kind %s", resource.GetKind())
 			}
-		case DELETE:
-			msg = "Deleting server"
-			log.Infof("%s: %s\n", yesnoStr(opts.doit, msg), resource.String())
+		}
+	}
+
+	lifecycle := openstack.NewLifecycle(opts.tagValue, opts.stopGrace, opts.deleteGrace)
+	dispatch := dispatchContext(ctx, opts)
+	for _, resource := range resources {
+		step, due := lifecycle.Next(resource.GetTags(), now)
+		if dispatch.Err() != nil {
+			skipResource(run, resource, "lifecycle:"+step, context.Cause(dispatch))
+			continue
+		}
+		if now.Before(due) {
+			log.Infof("Waiting until %s to %s: %s\n", due.Format(time.DateOnly), step, resource.String())
+			id, name, project := resource.GetData()
+			run.Skip(resource.GetKind(), id, name, project, "lifecycle:"+step, "waiting until "+due.Format(time.DateOnly))
+			continue
+		}
+
+		var err error
+		var msg string
+
+		switch step {
+		case openstack.LifecycleTag:
+			stageTag := lifecycle.StageTag(openstack.StageTagged, now)
+			msg = "Tagging (lifecycle)"
+			log.Infof("%s: %s <- %s,%s\n", yesnoStr(opts.doit, msg), resource.String(), opts.tagValue, stageTag)
 
 			if opts.doit {
-				err = resource.Delete()
+				err = resource.(openstack.Tagger).Tag(ctx, opts.tagValue)
+				if err == nil {
+					err = resource.(openstack.Tagger).Tag(ctx, stageTag)
+				}
 			}
-		case TAG:
-			msg = "Tagging server"
-			log.Infof("%s: %s <- %s\n", yesnoStr(opts.doit, msg), resource.String(), opts.tagValue)
+		case openstack.LifecycleStop:
+			stageTag := lifecycle.StageTag(openstack.StageStopped, now)
+			// Nova refuses to stop servers not running, just record the stage
+			running := resource.(openstack.PowerController).GetPowerState() == openstack.PowerStateRunning
+			msg = "Stopping (lifecycle)"
+			if !running {
+				msg = "Already stopped (lifecycle)"
+			}
+			log.Infof("%s: %s <- %s\n", yesnoStr(opts.doit, msg), resource.String(), stageTag)
 
 			if opts.doit {
-				err = resource.Tag(opts.tagValue)
+				if running {
+					err = resource.(openstack.PowerController).Stop(ctx)
+				}
+				if err == nil {
+					err = resource.(openstack.Tagger).Tag(ctx, stageTag)
+				}
+			}
+		case openstack.LifecycleDelete:
+			if resource.(openstack.PowerController).GetPowerState() == openstack.PowerStateRunning {
+				step = openstack.LifecycleRestart
+				msg = "Restarting lifecycle of started again"
+				log.Infof("%s: %s <- %s\n", yesnoStr(opts.doit, msg), resource.String(),
+					lifecycle.StageTag(openstack.StageTagged, now))
+
+				if opts.doit {
+					err = restartLifecycle(ctx, resource, lifecycle, now)
+				}
+				break
 			}
-		case UNTAG:
-			msg = "Untagging server"
-			log.Infof("%s: %s <- %s\n", yesnoStr(opts.doit, msg), resource.String(), opts.tagValue)
+			msg = "Deleting (lifecycle)"
+			log.Infof("%s: %s\n", yesnoStr(opts.doit, msg), resource.String())
 
 			if opts.doit {
-				err = resource.Untag(opts.tagValue)
+				err = resource.(openstack.Deleter).Delete(ctx)
 			}
 		}
 
+		auditRecord(opts, "lifecycle:"+step, resource, err)
+		run.Add(resource, "lifecycle:"+step, opts.doit, err)
 		if err != nil {
 			log.Errorf("Error %s %s: %s\n", msg, resource.String(), err)
 		}
 	}
-	return err
+	return run.Err()
+}
+
+// actionNotify sends each owner (resource Email) a single message listing
+// their resources with the planned lifecycle action and date
+func actionNotify(ctx context.Context, resources []openstack.OSResourceInterface, opts *cliOptions, now time.Time) error {
+	var err error
+
+	if opts.notifyTemplate == "" {
+		return fmt.Errorf("Missing --template for notify action")
+	}
+	notifier, err := notify.NewNotifier(opts.notifyTemplate, opts.smtpServer, opts.smtpFrom, opts.notifySubject)
+	if err != nil {
+		return err
+	}
+	notifier.WithAuth(opts.smtpUser, os.Getenv("SMTP_PASSWORD"))
+
+	run := runResult(opts)
+	lifecycle := openstack.NewLifecycle(opts.tagValue, opts.stopGrace, opts.deleteGrace)
+	byEmail := make(map[string][]notify.Resource)
+	for _, resource := range resources {
+		email := resource.GetEmail()
+		id, name, project := resource.GetData()
+		if email == "" {
+			log.Warningf("No email to notify for %s\n", resource.String())
+			run.Skip(resource.GetKind(), id, name, project, "notify", "no owner email")
+			continue
+		}
+		action, date := lifecycle.Planned(resource.GetTags(), now)
+		// Each of several owners (e.g. project admins) gets their own message
+		for _, recipient := range notify.Recipients(email) {
+			byEmail[recipient] = append(byEmail[recipient], notify.Resource{
+				Kind:    resource.GetKind(),
+				ID:      id,
+				Name:    name,
+				Project: project,
+				Created: resource.GetCreated(),
+				Action:  action,
+				Date:    date,
+			})
+		}
+	}
+
+	emails := make([]string, 0, len(byEmail))
+	for email := range byEmail {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+
+	dispatch := dispatchContext(ctx, opts)
+	for _, email := range emails {
+		if dispatch.Err() != nil {
+			log.Warningf("Skipping notification for %s: %s\n", email, context.Cause(dispatch))
+			run.Skip("email", email, fmt.Sprintf("%d resources", len(byEmail[email])), "", "notify", context.Cause(dispatch).Error())
+			continue
+		}
+		err = nil
+		msg := notifier.NewMessage(email, byEmail[email])
+		if opts.dryRunDir != "" {
+			var path string
+			path, err = notifier.WriteTo(opts.dryRunDir, msg)
+			if err == nil {
+				log.Infof("Rendered notification for %s (%d resources): %s\n", email, len(msg.Resources), path)
+			}
+		} else {
+			log.Infof("%s: %s (%d resources)\n", yesnoStr(opts.doit, "Notifying"), email, len(msg.Resources))
+			if opts.doit {
+				err = notifier.Send(msg)
+			}
+		}
+
+		run.AddNotification(email, len(msg.Resources), opts.doit || opts.dryRunDir != "", err)
+		if err != nil {
+			log.Errorf("Error notifying %s: %s\n", email, err)
+		}
+	}
+	return run.Err()
+}
+
+// actionPurge deletes resources whose retention period is over, e.g. safety
+// snapshots taken by server --snapshot-before-delete
+func actionPurge(ctx context.Context, resources []openstack.OSResourceInterface, opts *cliOptions, now time.Time) error {
+	run := runResult(opts)
+
+	for _, resource := range resources {
+		if !supportsAction(resource, PURGE) {
+			return fmt.Errorf("action purge not supported for kind %s", resource.GetKind())
+		}
+	}
+
+	dispatch := dispatchContext(ctx, opts)
+	for _, resource := range resources {
+		if dispatch.Err() != nil {
+			skipResource(run, resource, "purge", context.Cause(dispatch))
+			continue
+		}
+		if !resource.(openstack.Expirer).Expired(now) {
+			log.Debugf("Not expired yet: %s\n", resource.String())
+			id, name, project := resource.GetData()
+			run.Skip(resource.GetKind(), id, name, project, "purge", "not expired")
+			continue
+		}
+
+		log.Infof("%s: %s\n", yesnoStr(opts.doit, "Purging"), resource.String())
+		var err error
+		if opts.doit {
+			err = resource.(openstack.Deleter).Delete(ctx)
+		}
+
+		auditRecord(opts, "purge", resource, err)
+		run.Add(resource, "purge", opts.doit, err)
+		if err != nil {
+			log.Errorf("Error Purging %s: %s\n", resource.String(), err)
+		}
+	}
+	return run.Err()
 }
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -82,7 +86,7 @@ func Test_actionRun(t *testing.T) {
 		{
 			"actionRun: list all instances",
 			args{
-				(&mockOSclient{}).WithProjectToEmail(projectToEmailFunc),
+				(&mockOSclient{}).WithProjectToEmail(openstack.NewOwnerResolverFunc("regex", projectToEmailFunc)),
 				nil,
 				func(r openstack.OSResourceInterface) bool { return true },
 				LIST,
@@ -93,7 +97,7 @@ func Test_actionRun(t *testing.T) {
 		{
 			"actionRun: list no instance",
 			args{
-				(&mockOSclient{}).WithProjectToEmail(projectToEmailFunc),
+				(&mockOSclient{}).WithProjectToEmail(openstack.NewOwnerResolverFunc("regex", projectToEmailFunc)),
 				nil,
 				func(r openstack.OSResourceInterface) bool { return false },
 				LIST,
@@ -104,7 +108,7 @@ func Test_actionRun(t *testing.T) {
 		{
 			"actionRun: list one instance",
 			args{
-				(&mockOSclient{}).WithProjectToEmail(projectToEmailFunc),
+				(&mockOSclient{}).WithProjectToEmail(openstack.NewOwnerResolverFunc("regex", projectToEmailFunc)),
 				nil,
 				func(r openstack.OSResourceInterface) bool { return r.(*mockOSResource).ID == "1" },
 				LIST,
@@ -115,7 +119,7 @@ func Test_actionRun(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		opts := cliOptions{}
+		opts := cliOptions{kind: kindServer}
 
 		outFile, err := os.CreateTemp("", "testout")
 		if err != nil {
@@ -124,13 +128,13 @@ func Test_actionRun(t *testing.T) {
 
 		defer os.Remove(outFile.Name())
 
-		tt.args.instances, err = tt.args.osClient.GetInstances(tt.args.filter)
+		tt.args.instances, err = tt.args.osClient.GetInstances(context.Background(), tt.args.filter)
 		if err != nil {
 			t.Error(err)
 		}
 
 		t.Run(tt.name, func(t *testing.T) {
-			err := actionRun(tt.args.instances, tt.args.actionCode, tt.args.outputCode, outFile, &opts)
+			err := actionRun(context.Background(), tt.args.instances, tt.args.actionCode, tt.args.outputCode, outFile, &opts)
 			if err != nil {
 				t.Error(err)
 			}
@@ -199,7 +203,7 @@ func Test_actionPerResource(t *testing.T) {
 			opts := cliOptions{}
 			// Should show function (Delete, Stop, etc) called once
 			opts.doit = true
-			err := actionPerResource(resources, tt.args.actionCode, &opts)
+			err := actionPerResource(context.Background(), resources, tt.args.actionCode, &opts)
 			if err != nil {
 				t.Error(err)
 			}
@@ -208,7 +212,7 @@ func Test_actionPerResource(t *testing.T) {
 			}
 			// Should not increase called count (doit=false)
 			opts.doit = false
-			err = actionPerResource(resources, tt.args.actionCode, &opts)
+			err = actionPerResource(context.Background(), resources, tt.args.actionCode, &opts)
 			if err != nil {
 				t.Error(err)
 			}
@@ -218,3 +222,239 @@ func Test_actionPerResource(t *testing.T) {
 		})
 	}
 }
+
+func Test_actionPerResourceUnsupported(t *testing.T) {
+	t.Parallel()
+
+	// Only exposes openstack.OSResourceInterface methods, i.e. no capabilities
+	type listOnly struct {
+		openstack.OSResourceInterface
+	}
+
+	tests := []struct {
+		name       string
+		resources  []openstack.OSResourceInterface
+		actionCode int
+		wantErr    bool
+	}{
+		{"mock: stop", NewMockInstances(), STOP, false},
+		{"mock: tag", NewMockInstances(), TAG, false},
+		{"listOnly: stop", []openstack.OSResourceInterface{listOnly{m1}}, STOP, true},
+		{"listOnly: delete", []openstack.OSResourceInterface{listOnly{m1}}, DELETE, true},
+		{"listOnly: untag", []openstack.OSResourceInterface{listOnly{m1}}, UNTAG, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := cliOptions{}
+			err := actionPerResource(context.Background(), tt.resources, tt.actionCode, &opts)
+			if tt.wantErr {
+				require.ErrorContains(t, err, "not supported for kind server", tt.name)
+			} else {
+				require.NoError(t, err, tt.name)
+			}
+		})
+	}
+}
+
+// failingMock fails Stop() for its resource
+type failingMock struct {
+	*mockOSResource
+}
+
+func (m failingMock) Stop(_ context.Context) error {
+	m.calledStop++
+	return fmt.Errorf("409 conflict for %s", m.ID)
+}
+
+func Test_actionPerResourceErrors(t *testing.T) {
+	t.Parallel()
+
+	resources := []openstack.OSResourceInterface{
+		failingMock{newMockOSResource("1", "one", "foo__bar.com_project", 60, nil)},
+		newMockOSResource("2", "two", "foo__bar.com_project", 60, nil),
+		failingMock{newMockOSResource("3", "three", "bar__baz.com_project", 60, nil)},
+		newMockOSResource("4", "four", "bar__baz.com_project", 60, nil),
+	}
+	opts := cliOptions{doit: true, workers: 2, perProject: 1}
+	err := actionPerResource(context.Background(), resources, STOP, &opts)
+
+	// All errors are reported, not just the last one
+	require.ErrorContains(t, err, "409 conflict for 1")
+	require.ErrorContains(t, err, "409 conflict for 3")
+	for _, resource := range resources {
+		switch m := resource.(type) {
+		case failingMock:
+			require.Equal(t, 1, m.calledStop)
+		case *mockOSResource:
+			require.Equal(t, 1, m.calledStop)
+		}
+	}
+}
+
+// interruptingMock interrupts the run when stopped
+type interruptingMock struct {
+	*mockOSResource
+	interrupt context.CancelCauseFunc
+}
+
+func (m interruptingMock) Stop(ctx context.Context) error {
+	m.interrupt(errInterrupted)
+	return m.mockOSResource.Stop(ctx)
+}
+
+func Test_actionPerResourceInterrupted(t *testing.T) {
+	t.Parallel()
+
+	interrupt, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	first := interruptingMock{newMockOSResource("1", "one", "foo__bar.com_project", 60, nil), cancel}
+	rest := []*mockOSResource{
+		newMockOSResource("2", "two", "foo__bar.com_project", 60, nil),
+		newMockOSResource("3", "three", "foo__bar.com_project", 60, nil),
+	}
+	opts := cliOptions{doit: true, workers: 1, interrupt: interrupt}
+	err := actionPerResource(context.Background(), []openstack.OSResourceInterface{first, rest[0], rest[1]}, STOP, &opts)
+	require.NoError(t, err)
+
+	// In-flight action finished, the rest skipped
+	require.Equal(t, 1, first.calledStop)
+	for _, m := range rest {
+		require.Equal(t, 0, m.calledStop)
+	}
+	statuses := make(map[string]string)
+	for _, result := range opts.result.Results {
+		statuses[result.ID] = result.Status + ":" + result.Reason
+	}
+	require.Equal(t, map[string]string{
+		"1": StatusSucceeded + ":", "2": StatusSkipped + ":interrupted", "3": StatusSkipped + ":interrupted",
+	}, statuses)
+
+	err = interruptedErr(context.Background(), &opts, err)
+	require.ErrorIs(t, err, errInterrupted)
+	require.Equal(t, ExitInterrupted, exitCode(err))
+}
+
+func Test_actionLifecycle(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	lifecycle := openstack.NewLifecycle(osCleanupTag, 7, 14)
+	tagged := func(daysAgo int) string {
+		return lifecycle.StageTag(openstack.StageTagged, now.AddDate(0, 0, -daysAgo))
+	}
+	stopped := func(daysAgo int) string {
+		return lifecycle.StageTag(openstack.StageStopped, now.AddDate(0, 0, -daysAgo))
+	}
+
+	tests := []struct {
+		name       string
+		tags       []string
+		powerState string
+		wantTag    int
+		wantUntag  int
+		wantStop   int
+		wantDelete int
+	}{
+		{"new: tag", []string{}, openstack.PowerStateRunning, 2, 0, 0, 0},
+		{"tagged within grace: wait", []string{osCleanupTag, tagged(3)}, openstack.PowerStateRunning, 0, 0, 0, 0},
+		{"tagged after grace: stop", []string{osCleanupTag, tagged(7)}, openstack.PowerStateRunning, 1, 0, 1, 0},
+		{"tagged after grace, already stopped: tag only", []string{osCleanupTag, tagged(7)}, "SHUTDOWN", 1, 0, 0, 0},
+		{"stopped within grace: wait", []string{osCleanupTag, tagged(20), stopped(13)}, "SHUTDOWN", 0, 0, 0, 0},
+		{"stopped after grace: delete", []string{osCleanupTag, tagged(30), stopped(14)}, "SHUTDOWN", 0, 0, 0, 1},
+		{"stopped after grace, running again: restart", []string{osCleanupTag, tagged(30), stopped(14)}, openstack.PowerStateRunning, 1, 2, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockOSResource("1", "one", "foo__bar.com_project", 60, tt.tags)
+			m.PowerState = tt.powerState
+			opts := cliOptions{tagValue: osCleanupTag, stopGrace: 7, deleteGrace: 14}
+			err := actionLifecycle(context.Background(), []openstack.OSResourceInterface{m}, &opts, now)
+			require.NoError(t, err)
+			require.Equal(t, 0, m.calledTag+m.calledUntag+m.calledStop+m.calledDelete, "without --yes")
+
+			opts.doit = true
+			err = actionLifecycle(context.Background(), []openstack.OSResourceInterface{m}, &opts, now)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantTag, m.calledTag, "Tag() calls")
+			require.Equal(t, tt.wantUntag, m.calledUntag, "Untag() calls")
+			require.Equal(t, tt.wantStop, m.calledStop, "Stop() calls")
+			require.Equal(t, tt.wantDelete, m.calledDelete, "Delete() calls")
+		})
+	}
+}
+
+// expiringMock adds openstack.Expirer to mockOSResource
+type expiringMock struct {
+	*mockOSResource
+	expires time.Time
+}
+
+func (m expiringMock) Expired(t time.Time) bool {
+	return !t.Before(m.expires)
+}
+
+func Test_actionPurge(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	expired := expiringMock{newMockOSResource("1", "one", "foo__bar.com_project", 60, nil), now.AddDate(0, 0, -1)}
+	kept := expiringMock{newMockOSResource("2", "two", "foo__bar.com_project", 60, nil), now.AddDate(0, 0, 1)}
+	resources := []openstack.OSResourceInterface{expired, kept}
+
+	opts := cliOptions{}
+	require.NoError(t, actionPurge(context.Background(), resources, &opts, now))
+	require.Equal(t, 0, expired.calledDelete+kept.calledDelete, "without --yes")
+
+	opts.doit = true
+	require.NoError(t, actionPurge(context.Background(), resources, &opts, now))
+	require.Equal(t, 1, expired.calledDelete, "expired Delete() calls")
+	require.Equal(t, 0, kept.calledDelete, "kept Delete() calls")
+
+	err := actionPurge(context.Background(), NewMockInstances(), &opts, now)
+	require.ErrorContains(t, err, "not supported for kind server")
+}
+
+func Test_actionNotify(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	tmpl := filepath.Join(dir, "notify.txt")
+	err := os.WriteFile(tmpl, []byte(`{{range .Resources}}{{.Name}}: {{.Action}} on {{.Date.Format "2006-01-02"}}
+{{end}}`), 0o600)
+	require.NoError(t, err)
+
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	resources := NewMockInstances()
+	for _, r := range resources {
+		r.(*mockOSResource).Email = projectToEmailFunc(r)
+	}
+	// e.g. as resolved by keystone-roles for a project with several admins
+	resources[1].(*mockOSResource).Email += ",admin@bar.com"
+	// one is already tagged, two isn't
+	resources[0].(*mockOSResource).Tags = []string{openstack.NewLifecycle(osCleanupTag, 7, 14).
+		StageTag(openstack.StageTagged, now.AddDate(0, 0, -2))}
+
+	opts := cliOptions{
+		tagValue:       osCleanupTag,
+		stopGrace:      7,
+		deleteGrace:    14,
+		notifyTemplate: tmpl,
+		dryRunDir:      filepath.Join(dir, "out"),
+	}
+	err = actionNotify(context.Background(), resources, &opts, now)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(dir, "out", "foo@bar.com.eml"))
+	require.NoError(t, err)
+	require.Contains(t, string(content), "To: foo@bar.com\r\n")
+	require.Contains(t, string(content), "one: stop on 2026-10-22\n")
+	require.Contains(t, string(content), "two: stop on 2026-10-24\n")
+
+	content, err = os.ReadFile(filepath.Join(dir, "out", "admin@bar.com.eml"))
+	require.NoError(t, err)
+	require.Contains(t, string(content), "To: admin@bar.com\r\n")
+	require.NotContains(t, string(content), "one: ")
+	require.Contains(t, string(content), "two: stop on 2026-10-24\n")
+}
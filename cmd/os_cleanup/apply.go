@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jjo/openstack-ops/pkg/logger"
+	"github.com/jjo/openstack-ops/pkg/openstack"
+	"github.com/jjo/openstack-ops/pkg/plan"
+)
+
+// planOptions returns the opts recorded in a plan, to be applied as planned
+func planOptions(opts cliOptions) plan.Options {
+	options := plan.Options{Wait: opts.wait, SnapshotBeforeDelete: opts.snapshotBeforeDelete}
+	if opts.snapshotBeforeDelete {
+		options.SnapshotRetention = opts.snapshotRetention
+		options.SnapshotTimeout = opts.snapshotTimeout
+	}
+	if opts.wait {
+		options.WaitTimeout = opts.waitTimeout
+		options.ResetState = opts.resetState
+	}
+	return options
+}
+
+// withPlanOptions configures osClient as per the plan options
+func withPlanOptions(osClient openstack.OSClientInterface, options plan.Options) openstack.OSClientInterface {
+	if options.SnapshotBeforeDelete {
+		log.Infof("Planned with --snapshot-before-delete (retention: %d days, timeout: %s)\n",
+			options.SnapshotRetention, options.SnapshotTimeout)
+		osClient = osClient.WithSnapshotBeforeDelete(options.SnapshotRetention, options.SnapshotTimeout)
+	}
+	if options.Wait {
+		log.Infof("Planned with --wait (timeout: %s, reset-state: %v)\n", options.WaitTimeout, options.ResetState)
+		osClient = osClient.WithWait(options.WaitTimeout, options.ResetState)
+	}
+	return osClient
+}
+
+// runApply runs the action from plan p (loaded from path) only on its listed
+// resources, skipping those gone or changed (name, project, updated) since
+// planned
+func runApply(
+	ctx context.Context, osClient openstack.OSClientInterface, opts cliOptions, p *plan.Plan, path string, outFile io.Writer,
+) error {
+	_, err := logger.SetLevel(opts.logLevel)
+	if err != nil {
+		return err
+	}
+
+	actionCode := codeNum(p.Action, actionsMap)
+	if !journaled(actionCode) {
+		return fmt.Errorf("Invalid plan action: %s", p.Action)
+	}
+	opts.kind = p.Kind
+	opts.action = p.Action
+	opts.tagValue = p.TagValue
+	opts.filterDesc = "plan:" + path
+	// Planned resources must be found regardless of their attachment state
+	opts.inUse = true
+	osClient = withPlanOptions(osClient, p.Options)
+
+	ids := p.IDs()
+	found, err := getResources(dispatchContext(ctx, &opts), osClient, opts, func(resource openstack.OSResourceInterface) bool {
+		id, _, _ := resource.GetData()
+		return ids[id]
+	})
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]openstack.OSResourceInterface, len(found))
+	for _, resource := range found {
+		id, _, _ := resource.GetData()
+		byID[id] = resource
+	}
+
+	run := runResult(&opts)
+	resources := make([]openstack.OSResourceInterface, 0, len(p.Items))
+	for _, item := range p.Items {
+		resource, ok := byID[item.ID]
+		if !ok {
+			log.Warningf("Skipping %s %s (%s): not found\n", item.Kind, item.ID, item.Name)
+			run.Skip(item.Kind, item.ID, item.Name, item.Project, item.Action, "not found")
+			continue
+		}
+		if err := item.Verify(resource); err != nil {
+			log.Warningf("Skipping %s: %s\n", resource.String(), err)
+			run.Skip(item.Kind, item.ID, item.Name, item.Project, item.Action, err.Error())
+			continue
+		}
+		resources = append(resources, resource)
+	}
+	log.Infof("Applying %s to %d of %d planned %s resources from %s\n",
+		p.Action, len(resources), len(p.Items), p.Kind, path)
+
+	closeRunLogs, err := openRunLogs(&opts, actionCode, osClient.Operator())
+	if err != nil {
+		return err
+	}
+	defer closeRunLogs()
+
+	err = actionPerResource(ctx, resources, actionCode, &opts)
+	run.Summary(outFile)
+	return interruptedErr(ctx, &opts, err)
+}
+
+func cmdApply() *cobra.Command {
+	opts := &cliOptions{}
+	cmd := &cobra.Command{
+		Use:   "apply <plan.json>",
+		Short: "Run a reviewed --plan file, only on its resources still matching it",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := plan.Load(args[0])
+			if err != nil {
+				return err
+			}
+			// The client is set up as per kind, e.g. how to list servers
+			opts.kind = p.Kind
+			osClient, err := NewOSClient(*opts)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := runContext(opts)
+			defer cancel()
+			return runApply(ctx, osClient, *opts, p, args[0], os.Stdout)
+		},
+	}
+	flags := cmd.Flags()
+	flags.BoolVarP(&opts.doit, "yes", "", false, "commit dangerous actions, e.g. delete")
+	flags.StringVarP(&opts.logLevel, "loglevel", "l", "info", "set log level: debug, info, notice, warning, error, critical")
+	flags.IntVarP(&opts.workers, "workers", "w", workerCount, "number of workers, for listing and per-resource actions")
+	flags.IntVarP(&opts.perProject, "per-project", "", 0, "max concurrent per-resource actions within a project (0: no cap)")
+	flags.Float64VarP(&opts.rate, "rate", "", 0, "max per-resource actions per second overall (0: unlimited)")
+	flags.StringVarP(&opts.auditLog, "audit-log", "", "", "append a JSON Lines record of every mutating action to `file`")
+	flags.StringVarP(&opts.journalDir, "journal-dir", "", defaultJournalDir(),
+		"`dir` for run journals, used by undo (empty to disable)")
+	addOwnerFlags(flags, opts)
+	addRetryFlags(flags, opts)
+	addTimeoutFlags(flags, opts)
+	addCloudFlags(flags, opts)
+	return cmd
+}
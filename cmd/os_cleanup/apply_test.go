@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jjo/openstack-ops/pkg/audit"
+	"github.com/jjo/openstack-ops/pkg/plan"
+)
+
+func Test_runApply(t *testing.T) {
+	dir := t.TempDir()
+	planPath := filepath.Join(dir, "out", "plan.json")
+	opts := cliOptions{
+		kind:     kindServer,
+		action:   "stop",
+		output:   "table",
+		nDays:    nDays1,
+		logLevel: "info",
+		doit:     true,
+		plan:     planPath,
+		auditLog: filepath.Join(dir, "plan-audit.jsonl"),
+
+		wait:                 true,
+		waitTimeout:          time.Minute,
+		snapshotBeforeDelete: true,
+		snapshotRetention:    30,
+	}
+	devNull, _ := os.Open(os.DevNull)
+	defer devNull.Close()
+
+	// --plan doesn't act, even with --yes
+	require.NoError(t, runMain(context.Background(), &mockOSclient{operator: "planner"}, opts, devNull))
+	_, err := os.Stat(opts.auditLog)
+	require.True(t, os.IsNotExist(err), "no audit log written by --plan")
+
+	p, err := plan.Load(planPath)
+	require.NoError(t, err)
+	require.Equal(t, "stop", p.Action)
+	require.Equal(t, "planner", p.Operator)
+	require.Len(t, p.Items, 2)
+	require.Equal(t, plan.Options{Wait: true, WaitTimeout: time.Minute, SnapshotBeforeDelete: true, SnapshotRetention: 30}, p.Options)
+
+	// Applied as planned, regardless of apply options
+	opts = cliOptions{logLevel: "info", doit: true, auditLog: filepath.Join(dir, "audit.jsonl")}
+	osClient := &mockOSclient{operator: "applier"}
+	require.NoError(t, runApply(context.Background(), osClient, opts, p, planPath, devNull))
+	require.Equal(t, []string{"1", "2"}, auditIDs(t, opts.auditLog))
+	file, err := os.Open(opts.auditLog)
+	require.NoError(t, err)
+	defer file.Close()
+	records, err := audit.Search(file, audit.Query{})
+	require.NoError(t, err)
+	require.Equal(t, "applier", records[0].Operator)
+	require.Equal(t, 30, osClient.snapshotRetention)
+	require.Equal(t, time.Minute, osClient.waitTimeout)
+
+	// Resources changed since planned are skipped
+	p.Items[0].Name = "renamed"
+	p.Items = append(p.Items, plan.Item{Kind: kindServer, ID: "gone", Action: "stop"})
+	require.NoError(t, p.Write(planPath))
+	p, err = plan.Load(planPath)
+	require.NoError(t, err)
+	opts.auditLog = filepath.Join(dir, "audit-changed.jsonl")
+	require.NoError(t, runApply(context.Background(), NewMockOSClient(), opts, p, planPath, devNull))
+	require.Equal(t, []string{"2"}, auditIDs(t, opts.auditLog))
+
+	opts.kind = kindServer
+	opts.action = "list"
+	opts.output = "table"
+	opts.plan = planPath
+	require.ErrorContains(t, runMain(context.Background(), NewMockOSClient(), opts, devNull), "--plan not supported")
+}
+
+func auditIDs(t *testing.T, path string) []string {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	records, err := audit.Search(file, audit.Query{})
+	require.NoError(t, err)
+	ids := make([]string, 0, len(records))
+	for _, r := range records {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+
+	"github.com/jjo/openstack-ops/pkg/audit"
+)
+
+type auditQueryOptions struct {
+	auditLog string
+	project  string
+	id       string
+	since    string
+	until    string
+	output   string
+}
+
+// parseDate accepts either YYYY-MM-DD or RFC3339
+func parseDate(str string) (time.Time, error) {
+	if str == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, str); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, str)
+}
+
+func runAuditQuery(opts auditQueryOptions, outFile io.Writer) error {
+	query := audit.Query{Project: opts.project, ID: opts.id}
+	var err error
+	if query.Since, err = parseDate(opts.since); err != nil {
+		return fmt.Errorf("Invalid --since: %s", err)
+	}
+	if query.Until, err = parseDate(opts.until); err != nil {
+		return fmt.Errorf("Invalid --until: %s", err)
+	}
+
+	file, err := os.Open(opts.auditLog)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	records, err := audit.Search(file, query)
+	if err != nil {
+		return err
+	}
+
+	switch opts.output {
+	case "json":
+		jsonData, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = outFile.Write(jsonData)
+		return err
+	case "table":
+		tw := table.NewWriter()
+		tw.AppendHeader(table.Row{"Timestamp", "Operator", "Action", "Kind", "ID", "Name", "Project", "Email", "Dry_Run", "Result", "Error"})
+		for _, r := range records {
+			tw.AppendRow(table.Row{
+				r.Timestamp, r.Operator, r.Action, r.Kind, r.ID, r.Name, r.Project, r.Email, r.DryRun, r.Result, r.Error,
+			})
+		}
+		tw.SetStyle(table.StyleLight)
+		fmt.Fprintln(outFile, tw.Render())
+		return nil
+	}
+	return fmt.Errorf("Invalid output: %s", opts.output)
+}
+
+func cmdAudit() *cobra.Command {
+	opts := &auditQueryOptions{}
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Inspect the --audit-log written by mutating actions",
+	}
+	queryCmd := &cobra.Command{
+		Use:   "query",
+		Short: "Search audit log records by project, resource ID or date range",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runAuditQuery(*opts, os.Stdout)
+		},
+	}
+	pflags := cmd.PersistentFlags()
+	pflags.StringVarP(&opts.auditLog, "audit-log", "", "", "JSON Lines audit log `file`")
+	if err := cmd.MarkPersistentFlagRequired("audit-log"); err != nil {
+		log.Fatalf("MarkPersistentFlagRequired: %v", err)
+	}
+
+	flags := queryCmd.Flags()
+	flags.StringVarP(&opts.project, "project", "p", "", "project name or ID")
+	flags.StringVarP(&opts.id, "id", "", "", "resource ID")
+	flags.StringVarP(&opts.since, "since", "", "", "records at or after `date` (YYYY-MM-DD or RFC3339)")
+	flags.StringVarP(&opts.until, "until", "", "", "records before `date` (YYYY-MM-DD or RFC3339)")
+	flags.StringVarP(&opts.output, "output", "o", "table", "output format: table, json")
+
+	cmd.AddCommand(queryCmd)
+	return cmd
+}
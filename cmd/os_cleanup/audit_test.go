@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jjo/openstack-ops/pkg/audit"
+)
+
+func Test_auditActionPerResource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	logger, err := audit.Open(path, "operator1")
+	require.NoError(t, err)
+	opts := cliOptions{audit: logger, filterDesc: "include=\"foo\"", tagValue: osCleanupTag}
+	require.NoError(t, actionPerResource(context.Background(), NewMockInstances(), STOP, &opts))
+	opts.doit = true
+	require.NoError(t, actionPerResource(context.Background(), NewMockInstances(), TAG, &opts))
+	require.NoError(t, logger.Close())
+
+	var out bytes.Buffer
+	err = runAuditQuery(auditQueryOptions{auditLog: path, id: "1", output: "json"}, &out)
+	require.NoError(t, err)
+
+	var records []audit.Record
+	require.NoError(t, json.Unmarshal(out.Bytes(), &records))
+	require.Len(t, records, 2)
+	require.Equal(t, "stop", records[0].Action)
+	require.True(t, records[0].DryRun)
+	require.Equal(t, audit.ResultDryRun, records[0].Result)
+	require.Equal(t, "tag", records[1].Action)
+	require.Equal(t, audit.ResultOK, records[1].Result)
+	require.Equal(t, "operator1", records[1].Operator)
+	require.Equal(t, "foo__bar.com_project", records[1].Project)
+	require.Equal(t, "include=\"foo\"", records[1].Filter)
+
+	err = runAuditQuery(auditQueryOptions{auditLog: path, since: "yesterday", output: "json"}, &out)
+	require.ErrorContains(t, err, "Invalid --since")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jjo/openstack-ops/pkg/openstack"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// config holds per-site defaults, loaded from a YAML or TOML file (by extension),
+// e.g.:
+//
+//	include_re: "(.+)__(alumno|gmail).*"
+//	exclude_re: NOBORRAR
+//	exclude_fields: [name, metadata]
+//	where: '!("keep" in tags)'
+//	days: 90
+//	email:
+//	  rules:
+//	    - regex: "(.+)__(.+)_project"
+//	      template: "$1@$2"
+//	  lookup:
+//	    admin_project: cloud-admin@example.com
+type config struct {
+	IncludeRe *string     `yaml:"include_re" toml:"include_re"`
+	ExcludeRe *string     `yaml:"exclude_re" toml:"exclude_re"`
+	Where     *string     `yaml:"where" toml:"where"`
+	TagValue  *string     `yaml:"tag_value" toml:"tag_value"`
+	Workers   *int        `yaml:"workers" toml:"workers"`
+	Days      *int        `yaml:"days" toml:"days"`
+	Email     emailConfig `yaml:"email" toml:"email"`
+	// e.g. [keystone-extra, regex]
+	OwnerResolvers []string `yaml:"owner_resolvers" toml:"owner_resolvers"`
+	// e.g. [name, metadata]
+	IncludeFields []string `yaml:"include_fields" toml:"include_fields"`
+	ExcludeFields []string `yaml:"exclude_fields" toml:"exclude_fields"`
+}
+
+type emailConfig struct {
+	Rules  []emailRule       `yaml:"rules" toml:"rules"`
+	Lookup map[string]string `yaml:"lookup" toml:"lookup"`
+}
+
+// emailRule maps a project name matching Regex to an email, Template can use
+// regexp.Expand() `$1` style references
+type emailRule struct {
+	Regex    string `yaml:"regex" toml:"regex"`
+	Template string `yaml:"template" toml:"template"`
+	re       *regexp.Regexp
+}
+
+func loadConfig(path string) (*config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &config{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(content, cfg)
+	default:
+		err = yaml.Unmarshal(content, cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid config file %s: %s", path, err)
+	}
+
+	for i := range cfg.Email.Rules {
+		cfg.Email.Rules[i].re, err = regexp.Compile(cfg.Email.Rules[i].Regex)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid email rule regex %q: %s", cfg.Email.Rules[i].Regex, err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// flagValues returns config settings keyed by their CLI flag name
+func (cfg *config) flagValues() map[string]string {
+	values := make(map[string]string)
+	if cfg.IncludeRe != nil {
+		values["include-re"] = *cfg.IncludeRe
+	}
+	if cfg.ExcludeRe != nil {
+		values["exclude-re"] = *cfg.ExcludeRe
+	}
+	if cfg.Where != nil {
+		values["where"] = *cfg.Where
+	}
+	if cfg.TagValue != nil {
+		values["tag-value"] = *cfg.TagValue
+	}
+	if cfg.Workers != nil {
+		values["workers"] = strconv.Itoa(*cfg.Workers)
+	}
+	if cfg.Days != nil {
+		values["days"] = strconv.Itoa(*cfg.Days)
+	}
+	if cfg.OwnerResolvers != nil {
+		values["owner-resolvers"] = strings.Join(cfg.OwnerResolvers, ",")
+	}
+	if cfg.IncludeFields != nil {
+		values["include-field"] = strings.Join(cfg.IncludeFields, ",")
+	}
+	if cfg.ExcludeFields != nil {
+		values["exclude-field"] = strings.Join(cfg.ExcludeFields, ",")
+	}
+	return values
+}
+
+// apply sets cmd flags from cfg, unless explicitly given in the command line
+func (cfg *config) apply(cmd *cobra.Command, opts *cliOptions) error {
+	flags := cmd.Flags()
+	for name, value := range cfg.flagValues() {
+		if flags.Changed(name) {
+			continue
+		}
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("Invalid config value for %s: %s", name, err)
+		}
+	}
+
+	if len(cfg.Email.Rules) > 0 || len(cfg.Email.Lookup) > 0 {
+		opts.projectToEmail = cfg.Email.projectToEmail
+	}
+	return nil
+}
+
+// projectToEmail tries the lookup table first, then the first matching rule
+func (email *emailConfig) projectToEmail(resource openstack.OSResourceInterface) string {
+	project := resource.GetProjectName()
+	if address, ok := email.Lookup[project]; ok {
+		return address
+	}
+	for _, rule := range email.Rules {
+		if match := rule.re.FindStringSubmatchIndex(project); match != nil {
+			return string(rule.re.ExpandString(nil, rule.Template, project, match))
+		}
+	}
+	return ""
+}
+
+// loadConfigFlag loads and applies `--config` file, if any
+func loadConfigFlag(cmd *cobra.Command, opts *cliOptions) error {
+	if opts.config == "" {
+		return nil
+	}
+
+	cfg, err := loadConfig(opts.config)
+	if err != nil {
+		return err
+	}
+	return cfg.apply(cmd, opts)
+}
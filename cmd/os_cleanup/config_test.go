@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testConfigYAML = `
+include_re: "(.+)__campus.*"
+exclude_re: NOBORRAR
+where: '!("keep" in tags)'
+exclude_fields: [name, metadata]
+days: 90
+workers: 4
+email:
+  rules:
+    - regex: "^(.+)__campus_(.+)$"
+      template: "$1@$2.example.edu"
+  lookup:
+    admin: cloud-admin@example.edu
+`
+
+const testConfigTOML = `
+include_re = "(.+)__campus.*"
+exclude_re = "NOBORRAR"
+where = '!("keep" in tags)'
+exclude_fields = ["name", "metadata"]
+days = 90
+workers = 4
+
+[[email.rules]]
+regex = "^(.+)__campus_(.+)$"
+template = "$1@$2.example.edu"
+
+[email.lookup]
+admin = "cloud-admin@example.edu"
+`
+
+func Test_loadConfigFlag(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		args    []string
+		wantRe  string
+		days    int
+	}{
+		{"yaml defaults", "os_cleanup.yaml", testConfigYAML, []string{}, "(.+)__campus.*", 90},
+		{"toml defaults", "os_cleanup.toml", testConfigTOML, []string{}, "(.+)__campus.*", 90},
+		{"flags override", "os_cleanup.yaml", testConfigYAML, []string{"-i", "foo", "-d", "7"}, "foo", 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0o600))
+
+			cmd := cmdServer()
+			require.NoError(t, cmd.ParseFlags(append([]string{"-c", path, "-a", "list"}, tt.args...)))
+			opts := cliOptions{config: path}
+
+			// cmdServer() binds flags to its own cliOptions, read them back from the flagset
+			require.NoError(t, loadConfigFlag(cmd, &opts))
+			includeRe, _ := cmd.Flags().GetString("include-re")
+			excludeRe, _ := cmd.Flags().GetString("exclude-re")
+			where, _ := cmd.Flags().GetString("where")
+			excludeFields, _ := cmd.Flags().GetStringSlice("exclude-field")
+			days, _ := cmd.Flags().GetInt("days")
+			workers, _ := cmd.Flags().GetInt("workers")
+			require.Equal(t, tt.wantRe, includeRe)
+			require.Equal(t, "NOBORRAR", excludeRe)
+			require.Equal(t, `!("keep" in tags)`, where)
+			require.Equal(t, []string{"name", "metadata"}, excludeFields)
+			require.Equal(t, tt.days, days)
+			require.Equal(t, 4, workers)
+
+			require.NotNil(t, opts.projectToEmail)
+			require.Equal(t, "jdoe@unc.example.edu",
+				opts.projectToEmail(newMockOSResource("1", "one", "jdoe__campus_unc", 1, nil)))
+			require.Equal(t, "cloud-admin@example.edu",
+				opts.projectToEmail(newMockOSResource("1", "one", "admin", 1, nil)))
+			require.Equal(t, "",
+				opts.projectToEmail(newMockOSResource("1", "one", "other", 1, nil)))
+		})
+	}
+}
+
+func Test_loadConfigBadRegex(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "bad.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("email:\n  rules:\n    - regex: \"(\"\n"), 0o600))
+	_, err := loadConfig(path)
+	require.ErrorContains(t, err, "Invalid email rule regex")
+}
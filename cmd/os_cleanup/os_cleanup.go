@@ -1,15 +1,27 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/jjo/openstack-ops/pkg/audit"
+	"github.com/jjo/openstack-ops/pkg/journal"
 	"github.com/jjo/openstack-ops/pkg/logger"
 	"github.com/jjo/openstack-ops/pkg/openstack"
+	"github.com/jjo/openstack-ops/pkg/plan"
+	"github.com/jjo/openstack-ops/pkg/where"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/exp/slices"
 )
 
 const (
@@ -17,36 +29,227 @@ const (
 	workerCount  = 10
 )
 
+const (
+	kindServer     = "server"
+	kindVolume     = "volume"
+	kindSnapshot   = "snapshot"
+	kindFloatingIP = "floatingip"
+	kindSafety     = "safety-snapshot"
+)
+
 var mailRe = regexp.MustCompile("(.+)__(.+)_project")
 
 type cliOptions struct {
-	action    string
-	output    string
-	includeRe string
-	excludeRe string
-	nDays     int
-	tagged    bool
-	logLevel  string
-	tagValue  string
-	doit      bool
-	workers   int
+	kind        string
+	action      string
+	output      string
+	includeRe   string
+	excludeRe   string
+	where       string
+	nDays       int
+	tagged      bool
+	logLevel    string
+	tagValue    string
+	doit        bool
+	workers     int
+	inUse       bool
+	stopGrace   int
+	deleteGrace int
+
+	includeFields []string
+	excludeFields []string
+
+	notifyTemplate string
+	notifySubject  string
+	smtpServer     string
+	smtpFrom       string
+	smtpUser       string
+	dryRunDir      string
+
+	config         string
+	projectToEmail func(openstack.OSResourceInterface) string
+	ownerResolvers []string
+	ownerRole      string
+
+	auditLog   string
+	audit      *audit.Logger
+	filterDesc string
+
+	snapshotBeforeDelete bool
+	snapshotRetention    int
+	snapshotTimeout      time.Duration
+
+	journalDir string
+	journal    *journal.Journal
+
+	plan string
+
+	perProject int
+	rate       float64
+
+	result *RunResult
+
+	wait        bool
+	waitTimeout time.Duration
+	resetState  bool
+
+	retry openstack.RetryPolicy
+
+	clouds      []string
+	regions     []string
+	osInterface string
+
+	timeout time.Duration
+	// interrupt is canceled on SIGINT/SIGTERM: no more actions are started,
+	// in-flight ones finish, see dispatchContext()
+	interrupt context.Context
 }
 
 var log = logger.Log
-var c cliOptions
 
 func projectToEmailFunc(resource openstack.OSResourceInterface) string {
 	return mailRe.ReplaceAllString(resource.GetProjectName(), `$1@$2`)
 }
 
-func NewOSClient(opts cliOptions) openstack.OSClientInterface {
-	osClient := openstack.NewOSClient().
-		WithWorkers(opts.workers).
-		WithProjectToEmail(projectToEmailFunc)
-	return osClient
+// newOwnerResolver chains opts.ownerResolvers, "regex" being the project
+// name rewrite (from --config email rules, else mailRe)
+func newOwnerResolver(osClient *openstack.OSClient, opts cliOptions) (openstack.OwnerResolver, error) {
+	chain := make(openstack.ChainResolver, 0, len(opts.ownerResolvers))
+	for _, name := range opts.ownerResolvers {
+		switch name {
+		case "keystone-extra":
+			chain = append(chain, openstack.NewKeystoneExtraResolver(osClient, "email"))
+		case "keystone-roles":
+			chain = append(chain, openstack.NewKeystoneRoleResolver(osClient, opts.ownerRole))
+		case "regex":
+			projectToEmail := opts.projectToEmail
+			if projectToEmail == nil {
+				projectToEmail = projectToEmailFunc
+			}
+			chain = append(chain, openstack.NewOwnerResolverFunc("regex", projectToEmail))
+		default:
+			return nil, fmt.Errorf("Invalid owner resolver: %s", name)
+		}
+	}
+	return chain, nil
+}
+
+// NewOSClient returns a client for every --cloud and --region combination,
+// joined by openstack.MultiClient if more than one
+func NewOSClient(opts cliOptions) (openstack.OSClientInterface, error) {
+	return newOSClient(openstack.CloudConfigs(opts.clouds, opts.regions, opts.osInterface), opts)
+}
+
+// newOSClient is NewOSClient for the given clouds and regions configs
+func newOSClient(configs []openstack.CloudConfig, opts cliOptions) (openstack.OSClientInterface, error) {
+	clients := make([]openstack.OSClientInterface, 0)
+	for _, config := range configs {
+		osClient, err := openstack.NewOSClient(config)
+		if err != nil {
+			if len(configs) > 1 {
+				err = fmt.Errorf("cloud=%s region=%s: %w", config.Cloud, config.Region, err)
+			}
+			return nil, err
+		}
+		// Owner resolvers may query this cloud keystone
+		resolver, err := newOwnerResolver(osClient, opts)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, osClient.WithProjectToEmail(resolver))
+	}
+
+	client := clients[0]
+	if len(clients) > 1 {
+		client = openstack.NewMultiClient(clients...)
+	}
+	client = client.
+		WithRetryPolicy(opts.retry).
+		WithWorkers(opts.workers)
+	if opts.snapshotBeforeDelete {
+		client = client.WithSnapshotBeforeDelete(opts.snapshotRetention, opts.snapshotTimeout)
+	}
+	if opts.wait {
+		client = client.WithWait(opts.waitTimeout, opts.resetState)
+	}
+	if opts.kind == kindServer {
+		client = client.WithServerListOpts(serverListOpts(opts))
+	}
+	return client, nil
+}
+
+// serverListOpts narrows server listings at the API as much as runMain()
+// filter and the action allow, e.g. stop only needs ACTIVE servers
+func serverListOpts(opts cliOptions) openstack.ServerListOpts {
+	listOpts := openstack.ServerListOpts{CreatedBefore: time.Now().AddDate(0, 0, -opts.nDays)}
+	// Only safe if --include-re can't match other fields, as it does the
+	// whole searchable string by default
+	if opts.includeRe != "" && slices.Equal(opts.includeFields, []string{"project"}) {
+		listOpts.ProjectRe = regexp.MustCompile(opts.includeRe)
+	}
+	if opts.tagValue != "" {
+		switch {
+		case opts.tagged || opts.action == "untag":
+			listOpts.Tags = []string{opts.tagValue}
+		case opts.action == "tag":
+			listOpts.NotTags = []string{opts.tagValue}
+		}
+	}
+	switch opts.action {
+	case "stop":
+		listOpts.Status = "ACTIVE"
+	case "start":
+		listOpts.Status = "SHUTOFF"
+	}
+	return listOpts
+}
+
+func getResources(
+	ctx context.Context, osClient openstack.OSClientInterface, opts cliOptions, filter func(openstack.OSResourceInterface) bool,
+) ([]openstack.OSResourceInterface, error) {
+	switch opts.kind {
+	case kindServer:
+		return osClient.GetInstances(ctx, filter)
+	case kindVolume:
+		return osClient.GetVolumes(ctx, filter, opts.inUse)
+	case kindSnapshot:
+		return osClient.GetSnapshots(ctx, filter)
+	case kindFloatingIP:
+		return osClient.GetFloatingIPs(ctx, filter, opts.inUse)
+	case kindSafety:
+		return osClient.GetSafetySnapshots(ctx, filter)
+	}
+	return nil, fmt.Errorf("Invalid resource kind: %s", opts.kind)
+}
+
+// newFilter returns the resources filter as per opts, checking --where and
+// --include-field/--exclude-field against the fields of opts.kind
+func newFilter(opts cliOptions) (*openstack.OSResourceFilter, error) {
+	// An invalid kind is told by getResources()
+	searchFields := openstack.KindSearchFields(opts.kind)
+	for _, name := range append(append([]string{}, opts.includeFields...), opts.excludeFields...) {
+		if searchFields != nil && !slices.Contains(searchFields, name) {
+			return nil, fmt.Errorf("Invalid field %q for %s, valid ones: %s", name, opts.kind, strings.Join(searchFields, ", "))
+		}
+	}
+
+	// Calculate the timestamp for nDays ago
+	nDaysAgo := time.Now().AddDate(0, 0, -opts.nDays)
+
+	filter := openstack.NewOSResourceFilter(nDaysAgo, opts.includeRe, opts.excludeRe, opts.tagValue, opts.tagged).
+		WithIncFields(opts.includeFields).
+		WithExcFields(opts.excludeFields)
+	if opts.where != "" {
+		expr, err := where.Parse(opts.where, openstack.KindFields(opts.kind))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --where %q: %w", opts.where, err)
+		}
+		filter.WithWhere(expr)
+	}
+	return filter, nil
 }
 
-func runServerMain(osClient openstack.OSClientInterface, opts cliOptions, outFile *os.File) error {
+func runMain(ctx context.Context, osClient openstack.OSClientInterface, opts cliOptions, outFile *os.File) error {
 	_, err := logger.SetLevel(opts.logLevel)
 	if err != nil {
 		return err
@@ -64,64 +267,344 @@ func runServerMain(osClient openstack.OSClientInterface, opts cliOptions, outFil
 	if outputCode == -1 {
 		return fmt.Errorf("Invalid output: %s", opts.output)
 	}
-	// Calculate the timestamp for nDays ago
-	nDaysAgo := time.Now().AddDate(0, 0, -opts.nDays)
-
-	filter := openstack.NewOSResourceFilter(nDaysAgo, opts.includeRe, opts.excludeRe, opts.tagValue, opts.tagged)
+	filter, err := newFilter(opts)
+	if err != nil {
+		return err
+	}
 	filterFunc := func(resource openstack.OSResourceInterface) bool {
 		return filter.Run(resource)
 	}
-	instances, err := osClient.GetInstances(filterFunc)
+	opts.filterDesc = filter.String()
+	if opts.plan != "" && !journaled(actionCode) {
+		return fmt.Errorf("--plan not supported for action %s", opts.action)
+	}
+	instances, err := getResources(dispatchContext(ctx, &opts), osClient, opts, filterFunc)
 	if err != nil {
-		log.Error("Error while getting instances:", err)
+		// Go on with partial results, e.g. from the clouds that didn't fail
+		if len(instances) == 0 {
+			return fmt.Errorf("Error while getting %s resources: %w", opts.kind, err)
+		}
+		log.Errorf("Error while getting %s resources: %s", opts.kind, err)
+		runResult(&opts).ListFailed(opts.kind, err)
+	}
+
+	if opts.plan != "" {
+		err = plan.New(osClient.Operator(), opts.kind, opts.action, opts.tagValue, opts.filterDesc, planOptions(opts), instances).Write(opts.plan)
+		if err != nil {
+			return err
+		}
+		log.Infof("Wrote plan to %s (%d resources), review then run: os_cleanup apply %s --yes\n",
+			opts.plan, len(instances), opts.plan)
+		// A plan from a partial listing still fails the run
+		return runResult(&opts).Err()
 	}
 
-	return actionRun(instances, actionCode, outputCode, outFile, &opts)
+	closeRunLogs, err := openRunLogs(&opts, actionCode, osClient.Operator())
+	if err != nil {
+		return err
+	}
+	defer closeRunLogs()
+
+	err = actionRun(ctx, instances, actionCode, outputCode, outFile, &opts)
+	if opts.result != nil {
+		// Listing output stays parseable, e.g. -o json, its failure being
+		// already logged and reported by the exit code
+		if actionCode != LIST {
+			opts.result.Summary(outFile)
+		}
+		// e.g. a partial listing, even if all found were listed or acted upon
+		if err == nil {
+			err = opts.result.Err()
+		}
+	}
+	return interruptedErr(ctx, &opts, err)
+}
+
+// runContext returns the context for a whole run, done after --timeout, and
+// sets opts.interrupt, canceled on the first SIGINT or SIGTERM (a second one
+// kills the process). Returned func releases them
+func runContext(opts *cliOptions) (context.Context, func()) {
+	ctx, cancelTimeout := context.Background(), context.CancelFunc(func() {})
+	if opts.timeout > 0 {
+		ctx, cancelTimeout = context.WithTimeout(ctx, opts.timeout)
+	}
+	interrupt, cancel := context.WithCancelCause(ctx)
+	opts.interrupt = interrupt
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			signal.Stop(signals)
+			log.Warningf("Got %s, finishing in-flight actions and skipping the rest (repeat to abort)\n", sig)
+			cancel(errInterrupted)
+		case <-interrupt.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel(nil)
+		cancelTimeout()
+	}
+}
+
+// interruptedErr adds to err why the run didn't complete, if so
+func interruptedErr(ctx context.Context, opts *cliOptions, err error) error {
+	dispatch := dispatchContext(ctx, opts)
+	if dispatch.Err() == nil || errors.Is(err, context.Cause(dispatch)) {
+		return err
+	}
+	return errors.Join(err, context.Cause(dispatch))
+}
+
+// openRunLogs opens the --audit-log (recording operator) and run journal as
+// needed by actionCode, returned func closes them
+func openRunLogs(opts *cliOptions, actionCode int, operator string) (func(), error) {
+	var err error
+	if opts.auditLog != "" && actionCode != LIST {
+		opts.audit, err = audit.Open(opts.auditLog, operator)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.doit && opts.journalDir != "" && journaled(actionCode) {
+		opts.journal, err = journal.Create(opts.journalDir, journal.NewRunID(opts.action, time.Now()))
+		if err != nil {
+			if opts.audit != nil {
+				opts.audit.Close()
+			}
+			return nil, err
+		}
+	}
+
+	return func() {
+		if opts.audit != nil {
+			opts.audit.Close()
+		}
+		if opts.journal != nil {
+			opts.journal.Close()
+			log.Infof("Run journal: %s, revert with: os_cleanup undo %s\n",
+				journal.Path(opts.journalDir, opts.journal.RunID), opts.journal.RunID)
+		}
+	}, nil
+}
+
+// defaultJournalDir is ~/.os_cleanup/journal, or relative to cwd if there's
+// no $HOME
+func defaultJournalDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	return filepath.Join(home, ".os_cleanup", "journal")
+}
+
+func runResourceCmd(opts *cliOptions) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := loadConfigFlag(cmd, opts); err != nil {
+			return err
+		}
+		// Fail on a bad --where or field before authenticating to the clouds
+		if _, err := newFilter(*opts); err != nil {
+			return err
+		}
+		osClient, err := NewOSClient(*opts)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := runContext(opts)
+		defer cancel()
+		return runMain(ctx, osClient, *opts, os.Stdout)
+	}
+}
+
+func addCommonFlags(cmd *cobra.Command, opts *cliOptions) {
+	pflags := cmd.PersistentFlags()
+
+	pflags.StringVarP(&opts.config, "config", "c", "", "YAML or TOML config `file` with site defaults, overridden by flags")
+
+	pflags.StringVarP(&opts.includeRe, "include-re", "i", "(.+)__(alumno|gmail).*",
+		"regex for resources to include, with --include-field project servers are only listed from the projects it matches by name (if up to 20)")
+	pflags.StringVarP(&opts.excludeRe, "exclude-re", "e", "", "regex for resource projects,names,etc to exclude")
+	pflags.StringSliceVarP(&opts.includeFields, "include-field", "", nil,
+		"match --include-re against the values of these `fields` only, instead of the searchable string: "+
+			"name, id, project, email, tags, metadata (key=value), image, flavor (as per kind)")
+	pflags.StringSliceVarP(&opts.excludeFields, "exclude-field", "", nil,
+		"match --exclude-re against the values of these `fields` only, e.g. name,metadata")
+	pflags.StringVarP(&opts.where, "where", "", "",
+		`expression over resource fields to include, on top of the other filters, e.g. 'power_state == "RUNNING" && created < now - 90d && !("keep" in tags)'`)
+
+	pflags.StringVarP(&opts.action, "action", "a", "", "action to perform: list, stop, start, delete, tag, untag, lifecycle, notify, purge")
+	err := cmd.MarkPersistentFlagRequired("action")
+	if err != nil {
+		log.Fatalf("MarkPersistentFlagRequired: %v", err)
+	}
+
+	pflags.StringVarP(&opts.output, "output", "o", "table", "output format: table, json, csv, html, md")
+	pflags.IntVarP(&opts.nDays, "days", "d", 60, "resources older than `days`")
+
+	pflags.BoolVarP(&opts.tagged, "tagged", "t", false, "list only tagged resources")
+	pflags.StringVarP(&opts.tagValue, "tag-value", "", osCleanupTag, "tag value to use")
+	pflags.BoolVarP(&opts.doit, "yes", "", false, "commit dangerous actions, e.g. delete")
+
+	pflags.StringVarP(&opts.logLevel, "loglevel", "l", "info", "set log level: debug, info, notice, warning, error, critical")
+	pflags.IntVarP(&opts.workers, "workers", "w", workerCount, "number of workers, for listing and per-resource actions")
+	pflags.IntVarP(&opts.perProject, "per-project", "", 0, "max concurrent per-resource actions within a project (0: no cap)")
+	pflags.Float64VarP(&opts.rate, "rate", "", 0, "max per-resource actions per second overall (0: unlimited)")
+
+	pflags.StringVarP(&opts.auditLog, "audit-log", "", "", "append a JSON Lines record of every mutating action to `file`")
+	pflags.StringVarP(&opts.journalDir, "journal-dir", "", defaultJournalDir(),
+		"`dir` for run journals of stop, start, tag, untag and delete, used by undo (empty to disable)")
+	pflags.StringVarP(&opts.plan, "plan", "", "",
+		"write resources and planned action to `file` instead of acting, run it later with apply")
+
+	addOwnerFlags(pflags, opts)
+	addRetryFlags(pflags, opts)
+	addTimeoutFlags(pflags, opts)
+	addCloudFlags(pflags, opts)
+}
+
+// addOwnerFlags select how resources owner emails are resolved, see
+// newOwnerResolver()
+func addOwnerFlags(flags *pflag.FlagSet, opts *cliOptions) {
+	flags.StringSliceVarP(&opts.ownerResolvers, "owner-resolvers", "", []string{"regex"},
+		"owner email resolvers to try in order: keystone-extra, keystone-roles, regex")
+	flags.StringVarP(&opts.ownerRole, "owner-role", "", "admin", "keystone-roles resolver: project role held by owners")
+}
+
+// addTimeoutFlags bound the whole run, and each API request attempt
+func addTimeoutFlags(flags *pflag.FlagSet, opts *cliOptions) {
+	flags.DurationVarP(&opts.timeout, "timeout", "", 0,
+		"max time for the whole run, then in-flight API requests are canceled and the rest skipped (0: none)")
+	flags.DurationVarP(&opts.retry.RequestTimeout, "request-timeout", "", 2*time.Minute,
+		"max time per API request attempt, retried as per --retry-attempts (0: none)")
+}
+
+// addCloudFlags select credentials and endpoints, see openstack.CloudConfig,
+// each --cloud and --region combination is swept
+func addCloudFlags(flags *pflag.FlagSet, opts *cliOptions) {
+	flags.StringSliceVarP(&opts.clouds, "cloud", "", nil,
+		"`name` of the clouds.yaml entry to use, repeat for several (default $OS_CLOUD, else OS_* env vars)")
+	flags.StringSliceVarP(&opts.regions, "region", "", nil,
+		"region `names`, comma separated, override clouds.yaml region_name or $OS_REGION_NAME")
+	flags.StringSliceVarP(&opts.clouds, "os-cloud", "", nil, "same as --cloud")
+	flags.StringSliceVarP(&opts.regions, "os-region", "", nil, "same as --region")
+	flags.StringVarP(&opts.osInterface, "os-interface", "", "",
+		"endpoint interface: public, internal or admin, overrides clouds.yaml interface or $OS_INTERFACE")
+}
+
+// addRetryFlags for transient API errors, see openstack.RetryPolicy
+func addRetryFlags(flags *pflag.FlagSet, opts *cliOptions) {
+	opts.retry = openstack.DefaultRetryPolicy()
+	flags.IntVarP(&opts.retry.MaxAttempts, "retry-attempts", "", opts.retry.MaxAttempts,
+		"max attempts per API request on 409, 429, 5xx and network errors (1: no retries)")
+	flags.DurationVarP(&opts.retry.BaseDelay, "retry-delay", "", opts.retry.BaseDelay,
+		"delay before the first retry, doubled on each next one (with jitter), unless Retry-After is longer")
+	flags.DurationVarP(&opts.retry.MaxDelay, "retry-max-delay", "", opts.retry.MaxDelay, "max delay between retries")
 }
 
 func cmdServer() *cobra.Command {
-	var osClient openstack.OSClientInterface
+	opts := &cliOptions{kind: kindServer}
 	cmd := &cobra.Command{
 		Use:   "server",
 		Short: "Cleanup unused openstack `server` resources (VM intances)",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			return runServerMain(osClient, c, os.Stdout)
-		},
+		RunE:  runResourceCmd(opts),
 	}
+	addCommonFlags(cmd, opts)
 	pflags := cmd.PersistentFlags()
+	pflags.IntVarP(&opts.stopGrace, "stop-grace", "", 7, "lifecycle: `days` from tagged to stopped")
+	pflags.IntVarP(&opts.deleteGrace, "delete-grace", "", 7, "lifecycle: `days` from stopped to deleted")
 
-	pflags.StringVarP(&c.includeRe, "include-re", "i", "(.+)__(alumno|gmail).*", "regex for instance projects to include")
-	pflags.StringVarP(&c.excludeRe, "exclude-re", "e", "", "regex for instance projects,names,etc to exclude")
+	pflags.StringVarP(&opts.notifyTemplate, "template", "", "", "notify: text/template (or html/template if *.html) `file` for owner messages")
+	pflags.StringVarP(&opts.notifySubject, "subject", "", "Your OpenStack resources are scheduled for cleanup", "notify: message subject")
+	pflags.StringVarP(&opts.smtpServer, "smtp-server", "", "localhost:25", "notify: SMTP relay `host:port`")
+	pflags.StringVarP(&opts.smtpFrom, "smtp-from", "", "", "notify: sender address")
+	pflags.StringVarP(&opts.smtpUser, "smtp-user", "", "", "notify: SMTP auth user, password read from SMTP_PASSWORD env var")
+	pflags.StringVarP(&opts.dryRunDir, "dry-run", "", "", "notify: render messages into `dir` instead of sending them")
 
-	pflags.StringVarP(&c.action, "action", "a", "", "action to perform: list, stop, start, delete, tag, untag")
-	err := cmd.MarkPersistentFlagRequired("action")
-	if err != nil {
-		log.Fatalf("MarkPersistentFlagRequired: %v", err)
+	pflags.BoolVarP(&opts.snapshotBeforeDelete, "snapshot-before-delete", "", false,
+		"delete: first create an image of the server and snapshots of its attached volumes")
+	pflags.IntVarP(&opts.snapshotRetention, "snapshot-retention", "", 30, "safety snapshots: `days` to keep them before purge")
+	pflags.DurationVarP(&opts.snapshotTimeout, "snapshot-timeout", "", 30*time.Minute, "safety snapshots: max time to wait for them to be ready")
+
+	pflags.BoolVarP(&opts.wait, "wait", "", false, "stop, start, delete: wait for each server to reach the expected state, or be gone")
+	pflags.DurationVarP(&opts.waitTimeout, "wait-timeout", "", 5*time.Minute, "--wait: max time to wait per server")
+	pflags.BoolVarP(&opts.resetState, "reset-state", "", false,
+		"--wait: on timeout or ERROR, reset-state the server (admin) and retry stop or delete once")
+	return cmd
+}
+
+func cmdVolume() *cobra.Command {
+	opts := &cliOptions{kind: kindVolume}
+	cmd := &cobra.Command{
+		Use:   "volume",
+		Short: "Cleanup unused openstack `volume` resources (detached Cinder volumes)",
+		RunE:  runResourceCmd(opts),
 	}
+	addCommonFlags(cmd, opts)
+	cmd.PersistentFlags().BoolVarP(&opts.inUse, "in-use", "", false, "also include `in-use` (attached) volumes")
+	return cmd
+}
 
-	pflags.StringVarP(&c.output, "output", "o", "table", "output format: table, json, csv, html, md")
-	pflags.IntVarP(&c.nDays, "days", "d", 60, "instances older than `days`")
+func cmdSnapshot() *cobra.Command {
+	opts := &cliOptions{kind: kindSnapshot}
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Cleanup stale openstack `snapshot` resources (Cinder volume snapshots and Glance server snapshot images)",
+		RunE:  runResourceCmd(opts),
+	}
+	addCommonFlags(cmd, opts)
+	return cmd
+}
 
-	pflags.BoolVarP(&c.tagged, "tagged", "t", false, "list only tagged instances")
-	pflags.StringVarP(&c.tagValue, "tag-value", "", osCleanupTag, "tag value to use")
-	pflags.BoolVarP(&c.doit, "yes", "", false, "commit dangerous actions, e.g. delete")
+func cmdFloatingIP() *cobra.Command {
+	opts := &cliOptions{kind: kindFloatingIP}
+	cmd := &cobra.Command{
+		Use:   "floatingip",
+		Short: "Cleanup unused openstack `floatingip` resources (unassociated Neutron floating IPs)",
+		RunE:  runResourceCmd(opts),
+	}
+	addCommonFlags(cmd, opts)
+	cmd.PersistentFlags().BoolVarP(&opts.inUse, "in-use", "", false, "also include floating IPs associated to a port")
+	return cmd
+}
 
-	pflags.StringVarP(&c.logLevel, "loglevel", "l", "info", "set log level: debug, info, notice, warning, error, critical")
-	pflags.IntVarP(&c.workers, "workers", "w", workerCount, "number of workers")
-	osClient = NewOSClient(c)
+func cmdSafetySnapshot() *cobra.Command {
+	opts := &cliOptions{kind: kindSafety}
+	cmd := &cobra.Command{
+		Use:   "safety-snapshot",
+		Short: "Purge expired `safety-snapshot` resources (images and volume snapshots from server --snapshot-before-delete)",
+		RunE:  runResourceCmd(opts),
+	}
+	addCommonFlags(cmd, opts)
+	// Retention (rather than age) is what matters here, see purge action
+	days := cmd.PersistentFlags().Lookup("days")
+	days.DefValue = "0"
+	opts.nDays = 0
 	return cmd
 }
+
 func NewRootCommand() *cobra.Command {
 	rootCmd := &cobra.Command{
 		Use:   "os_cleanup",
 		Short: "Cleanup unused openstack resources",
 	}
 	rootCmd.AddCommand(cmdServer())
+	rootCmd.AddCommand(cmdVolume())
+	rootCmd.AddCommand(cmdSnapshot())
+	rootCmd.AddCommand(cmdFloatingIP())
+	rootCmd.AddCommand(cmdSafetySnapshot())
+	rootCmd.AddCommand(cmdAudit())
+	rootCmd.AddCommand(cmdUndo())
+	rootCmd.AddCommand(cmdApply())
 	return rootCmd
 }
 func main() {
 	cmd := NewRootCommand()
 	if err := cmd.Execute(); err != nil {
-		log.Fatal(err)
+		log.Error(err)
+		os.Exit(exitCode(err))
 	}
 }
@@ -1,22 +1,36 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/jjo/openstack-ops/pkg/fakeopenstack"
 	"github.com/jjo/openstack-ops/pkg/openstack"
+	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/require"
 )
 
 type mockOSclient struct {
-	projectToEmail func(openstack.OSResourceInterface) string
+	ownerResolver openstack.OwnerResolver
+	cloud         string
+	region        string
+	listErr       error
+	operator      string
+	// as set by WithSnapshotBeforeDelete() and WithWait()
+	snapshotRetention int
+	waitTimeout       time.Duration
 }
 
-func (m *mockOSclient) WithProjectToEmail(f func(r openstack.OSResourceInterface) string) openstack.OSClientInterface {
-	m.projectToEmail = f
+func (m *mockOSclient) WithProjectToEmail(resolver openstack.OwnerResolver) openstack.OSClientInterface {
+	m.ownerResolver = resolver
 	return m
 }
 
@@ -24,6 +38,28 @@ func (m *mockOSclient) WithWorkers(int) openstack.OSClientInterface {
 	return m
 }
 
+func (m *mockOSclient) WithServerListOpts(openstack.ServerListOpts) openstack.OSClientInterface {
+	return m
+}
+
+func (m *mockOSclient) WithSnapshotBeforeDelete(retentionDays int, _ time.Duration) openstack.OSClientInterface {
+	m.snapshotRetention = retentionDays
+	return m
+}
+
+func (m *mockOSclient) WithWait(timeout time.Duration, _ bool) openstack.OSClientInterface {
+	m.waitTimeout = timeout
+	return m
+}
+
+func (m *mockOSclient) Operator() string {
+	return m.operator
+}
+
+func (m *mockOSclient) WithRetryPolicy(openstack.RetryPolicy) openstack.OSClientInterface {
+	return m
+}
+
 type mockOSResource struct {
 	osClient     *mockOSclient
 	ID           string    `json:"id"`
@@ -31,7 +67,12 @@ type mockOSResource struct {
 	Project      string    `json:"project"`
 	Email        string    `json:"email"`
 	Created      time.Time `json:"created"`
+	Updated      time.Time `json:"updated"`
 	Tags         []string  `json:"tags"`
+	Cloud        string    `json:"cloud,omitempty"`
+	Region       string    `json:"region,omitempty"`
+	Status       string    `json:"status,omitempty"`
+	PowerState   string    `json:"powerstate,omitempty"`
 	calledStart  int
 	calledStop   int
 	calledDelete int
@@ -39,6 +80,10 @@ type mockOSResource struct {
 	calledUntag  int
 }
 
+func (m *mockOSResource) GetKind() string {
+	return "server"
+}
+
 func (m *mockOSResource) GetData() (string, string, string) {
 	return m.ID, m.Name, m.Project
 }
@@ -47,7 +92,7 @@ func (m *mockOSResource) CreatedBefore(t time.Time) bool {
 	return m.Created.Before(t)
 }
 
-func (m *mockOSResource) Delete() error {
+func (m *mockOSResource) Delete(_ context.Context) error {
 	m.calledDelete++
 	return nil
 }
@@ -56,26 +101,54 @@ func (m *mockOSResource) GetProjectName() string {
 	return m.Project
 }
 
+func (m *mockOSResource) GetProjectID() string {
+	return m.Project + "-id"
+}
+
+func (m *mockOSResource) GetCloud() string {
+	return m.Cloud
+}
+
+func (m *mockOSResource) GetRegion() string {
+	return m.Region
+}
+
+func (m *mockOSResource) GetEmail() string {
+	return m.Email
+}
+
+func (m *mockOSResource) GetCreated() time.Time {
+	return m.Created
+}
+
+func (m *mockOSResource) GetUpdated() time.Time {
+	return m.Updated
+}
+
 func (m *mockOSResource) GetTags() []string {
 	return m.Tags
 }
 
-func (m *mockOSResource) Start() error {
+func (m *mockOSResource) Start(_ context.Context) error {
 	m.calledStart++
 	return nil
 }
 
-func (m *mockOSResource) Tag(_ string) error {
+func (m *mockOSResource) Tag(_ context.Context, _ string) error {
 	m.calledTag++
 	return nil
 }
 
-func (m *mockOSResource) Untag(_ string) error {
+func (m *mockOSResource) Untag(_ context.Context, _ string) error {
 	m.calledUntag++
 	return nil
 }
 
-func (m *mockOSResource) Stop() error {
+func (m *mockOSResource) GetPowerState() string {
+	return m.PowerState
+}
+
+func (m *mockOSResource) Stop(_ context.Context) error {
 	m.calledStop++
 	return nil
 }
@@ -85,13 +158,36 @@ func (m *mockOSResource) String() string {
 }
 
 func (m *mockOSResource) StringAll() string {
-	return fmt.Sprintf("%v", *m)
+	return openstack.SearchString(m.GetSearchFields())
+}
+
+func (m *mockOSResource) GetSearchFields() []openstack.SearchField {
+	return []openstack.SearchField{
+		{Name: "name", Values: []string{m.Name}},
+		{Name: "id", Values: []string{m.ID}},
+		{Name: "project", Values: []string{m.Project}},
+		{Name: "email", Values: []string{m.Email}},
+		{Name: "tags", Values: m.GetTags()},
+	}
 }
 
 func (m *mockOSResource) GetRow() []interface{} {
 	return []interface{}{m.Name, m.ID, m.Created, "active", "RUNNING", m.Project, m.Email, m.GetTags()}
 }
 
+func (m *mockOSResource) GetFields() map[string]interface{} {
+	return map[string]interface{}{
+		"kind":        m.GetKind(),
+		"id":          m.ID,
+		"name":        m.Name,
+		"project":     m.Project,
+		"email":       m.Email,
+		"created":     m.Created,
+		"tags":        m.GetTags(),
+		"power_state": m.GetPowerState(),
+	}
+}
+
 func newMockOSResource(id, name, project string, nDaysAgo int, tags []string) *mockOSResource {
 	return &mockOSResource{
 		ID:      id,
@@ -103,11 +199,14 @@ func newMockOSResource(id, name, project string, nDaysAgo int, tags []string) *m
 }
 func copyMockOSResource(m *mockOSResource) *mockOSResource {
 	return &mockOSResource{
-		ID:      m.ID,
-		Name:    m.Name,
-		Project: m.Project,
-		Tags:    m.Tags,
-		Created: m.Created,
+		ID:         m.ID,
+		Name:       m.Name,
+		Project:    m.Project,
+		Tags:       m.Tags,
+		Status:     m.Status,
+		PowerState: m.PowerState,
+		Created:    m.Created,
+		Updated:    m.Updated,
 	}
 }
 
@@ -118,6 +217,18 @@ var (
 	m2     = newMockOSResource("2", "two", "foo__bar.com_project", nDays2, []string{"tag2"})
 )
 
+var (
+	v1 = newMockOSResource("v1", "vol-one", "foo__bar.com_project", nDays2, []string{})
+	v2 = newMockOSResource("v2", "vol-two", "foo__bar.com_project", nDays2, []string{})
+)
+
+func init() {
+	m1.PowerState = openstack.PowerStateRunning
+	m2.PowerState = openstack.PowerStateRunning
+	v1.Status = "available"
+	v2.Status = "in-use"
+}
+
 func NewMockOSClient() openstack.OSClientInterface {
 	return &mockOSclient{}
 }
@@ -130,18 +241,22 @@ func NewMockInstances() []openstack.OSResourceInterface {
 }
 
 func (m *mockOSclient) GetInstances(
-	filter func(r openstack.OSResourceInterface) bool) (
+	_ context.Context, filter func(r openstack.OSResourceInterface) bool) (
 	[]openstack.OSResourceInterface, error,
 ) {
+	if m.listErr != nil {
+		return nil, m.listErr
+	}
 	instances := make([]openstack.OSResourceInterface, 0)
 	mockInstances := NewMockInstances()
 
 	for _, i := range mockInstances {
 		instance := i.(*mockOSResource)
 		instance.osClient = m
+		instance.Cloud, instance.Region = m.cloud, m.region
 
-		if m.projectToEmail != nil {
-			instance.Email = m.projectToEmail(instance)
+		if m.ownerResolver != nil {
+			instance.Email, _ = m.ownerResolver.ResolveOwner(context.Background(), instance)
 		}
 		if filter(instance) {
 			instances = append(instances, instance)
@@ -150,7 +265,47 @@ func (m *mockOSclient) GetInstances(
 	return instances, nil
 }
 
-func Test_runServerMain(t *testing.T) {
+func (m *mockOSclient) GetVolumes(
+	_ context.Context, filter func(r openstack.OSResourceInterface) bool, inUse bool) (
+	[]openstack.OSResourceInterface, error,
+) {
+	volumes := make([]openstack.OSResourceInterface, 0)
+
+	for _, volume := range []*mockOSResource{copyMockOSResource(v1), copyMockOSResource(v2)} {
+		volume.osClient = m
+
+		if volume.Status == "in-use" && !inUse {
+			continue
+		}
+		if filter(volume) {
+			volumes = append(volumes, volume)
+		}
+	}
+	return volumes, nil
+}
+
+func (m *mockOSclient) GetSnapshots(
+	_ context.Context, filter func(r openstack.OSResourceInterface) bool) (
+	[]openstack.OSResourceInterface, error,
+) {
+	return make([]openstack.OSResourceInterface, 0), nil
+}
+
+func (m *mockOSclient) GetFloatingIPs(
+	_ context.Context, filter func(r openstack.OSResourceInterface) bool, inUse bool) (
+	[]openstack.OSResourceInterface, error,
+) {
+	return make([]openstack.OSResourceInterface, 0), nil
+}
+
+func (m *mockOSclient) GetSafetySnapshots(
+	_ context.Context, filter func(r openstack.OSResourceInterface) bool) (
+	[]openstack.OSResourceInterface, error,
+) {
+	return make([]openstack.OSResourceInterface, 0), nil
+}
+
+func Test_runMain(t *testing.T) {
 	type args struct {
 		opts cliOptions
 	}
@@ -164,9 +319,10 @@ func Test_runServerMain(t *testing.T) {
 		wantErr       bool
 	}{
 		{
-			"runServerMain: bad logLevel",
+			"runMain: bad logLevel",
 			args{
 				cliOptions{
+					kind:     kindServer,
 					action:   "list",
 					output:   "json",
 					logLevel: "foobar",
@@ -176,9 +332,10 @@ func Test_runServerMain(t *testing.T) {
 			true,
 		},
 		{
-			"runServerMain: bad action",
+			"runMain: bad action",
 			args{
 				cliOptions{
+					kind:     kindServer,
 					action:   "listfoo",
 					output:   "json",
 					logLevel: "debug",
@@ -188,9 +345,10 @@ func Test_runServerMain(t *testing.T) {
 			true,
 		},
 		{
-			"runServerMain: bad output",
+			"runMain: bad output",
 			args{
 				cliOptions{
+					kind:     kindServer,
 					action:   "list",
 					output:   "foobar",
 					logLevel: "debug",
@@ -200,9 +358,10 @@ func Test_runServerMain(t *testing.T) {
 			true,
 		},
 		{
-			"runServerMain: list all from 0 days ago",
+			"runMain: list all from 0 days ago",
 			args{
 				cliOptions{
+					kind:      kindServer,
 					action:    "list",
 					output:    "json",
 					includeRe: "(.+)__.*",
@@ -219,9 +378,10 @@ func Test_runServerMain(t *testing.T) {
 			false,
 		},
 		{
-			"runServerMain: list all from just after newest",
+			"runMain: list all from just after newest",
 			args{
 				cliOptions{
+					kind:      kindServer,
 					action:    "list",
 					output:    "json",
 					includeRe: "(.+)__.*",
@@ -238,9 +398,10 @@ func Test_runServerMain(t *testing.T) {
 			false,
 		},
 		{
-			"runServerMain: list oldest",
+			"runMain: list oldest",
 			args{
 				cliOptions{
+					kind:      kindServer,
 					action:    "list",
 					output:    "json",
 					includeRe: "(.+)__.*",
@@ -257,9 +418,10 @@ func Test_runServerMain(t *testing.T) {
 			false,
 		},
 		{
-			"runServerMain: list tagged (one instance)",
+			"runMain: list tagged (one instance)",
 			args{
 				cliOptions{
+					kind:      kindServer,
 					action:    "list",
 					output:    "json",
 					includeRe: "(.+)__.*",
@@ -276,9 +438,10 @@ func Test_runServerMain(t *testing.T) {
 			false,
 		},
 		{
-			"runServerMain: list tagged (no instance)",
+			"runMain: list tagged (no instance)",
 			args{
 				cliOptions{
+					kind:      kindServer,
 					action:    "list",
 					output:    "json",
 					includeRe: "(.+)__.*",
@@ -295,9 +458,10 @@ func Test_runServerMain(t *testing.T) {
 			false,
 		},
 		{
-			"runServerMain: list includeRe (one instance)",
+			"runMain: list includeRe (one instance)",
 			args{
 				cliOptions{
+					kind:      kindServer,
 					action:    "list",
 					output:    "json",
 					includeRe: "one",
@@ -314,9 +478,10 @@ func Test_runServerMain(t *testing.T) {
 			false,
 		},
 		{
-			"runServerMain: list includeRe (one instance)",
+			"runMain: list includeRe (one instance)",
 			args{
 				cliOptions{
+					kind:      kindServer,
 					action:    "list",
 					output:    "json",
 					includeRe: "foo__bar",
@@ -333,9 +498,10 @@ func Test_runServerMain(t *testing.T) {
 			false,
 		},
 		{
-			"runServerMain: list exclude (no instance)",
+			"runMain: list exclude (no instance)",
 			args{
 				cliOptions{
+					kind:      kindServer,
 					action:    "list",
 					output:    "json",
 					includeRe: "FOOone",
@@ -351,6 +517,83 @@ func Test_runServerMain(t *testing.T) {
 			[]openstack.OSResourceInterface{},
 			false,
 		},
+		{
+			"runMain: list volumes (skip in-use)",
+			args{
+				cliOptions{
+					kind:      kindVolume,
+					action:    "list",
+					output:    "json",
+					includeRe: "(.+)__.*",
+					logLevel:  "info",
+					workers:   10,
+				},
+			},
+			[]openstack.OSResourceInterface{v1},
+			false,
+		},
+		{
+			"runMain: list volumes (include in-use)",
+			args{
+				cliOptions{
+					kind:      kindVolume,
+					action:    "list",
+					output:    "json",
+					includeRe: "(.+)__.*",
+					logLevel:  "info",
+					workers:   10,
+					inUse:     true,
+				},
+			},
+			[]openstack.OSResourceInterface{v1, v2},
+			false,
+		},
+		{
+			"runMain: list snapshots (none)",
+			args{
+				cliOptions{
+					kind:      kindSnapshot,
+					action:    "list",
+					output:    "json",
+					includeRe: "(.+)__.*",
+					logLevel:  "info",
+					workers:   10,
+				},
+			},
+			[]openstack.OSResourceInterface{},
+			false,
+		},
+		{
+			"runMain: list where tagged (one instance)",
+			args{
+				cliOptions{
+					kind:      kindServer,
+					action:    "list",
+					output:    "json",
+					includeRe: "(.+)__.*",
+					where:     `"tag1" in tags && created < now - 1d`,
+					logLevel:  "info",
+					workers:   10,
+				},
+			},
+			[]openstack.OSResourceInterface{m1},
+			false,
+		},
+		{
+			"runMain: bad where",
+			args{
+				cliOptions{
+					kind:      kindServer,
+					action:    "list",
+					output:    "json",
+					includeRe: "(.+)__.*",
+					where:     `tags == "tag1"`,
+					logLevel:  "info",
+				},
+			},
+			[]openstack.OSResourceInterface{},
+			true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -365,7 +608,7 @@ func Test_runServerMain(t *testing.T) {
 		osClient := NewMockOSClient()
 
 		t.Run(tt.name, func(t *testing.T) {
-			err := runServerMain(osClient, tt.args.opts, outFile)
+			err := runMain(context.Background(), osClient, tt.args.opts, outFile)
 			if tt.wantErr {
 				require.Error(t, err)
 				return
@@ -386,3 +629,201 @@ func Test_runServerMain(t *testing.T) {
 		})
 	}
 }
+
+func Test_runMainMultiCloud(t *testing.T) {
+	osClient := openstack.NewMultiClient(
+		&mockOSclient{cloud: "a", region: "r1"},
+		&mockOSclient{cloud: "b", region: "r2"},
+	)
+	outFile, err := os.CreateTemp(t.TempDir(), "testout")
+	require.NoError(t, err)
+	defer outFile.Close()
+
+	opts := cliOptions{kind: kindServer, action: "list", output: "json", includeRe: "(.+)__.*", logLevel: "info"}
+	require.NoError(t, runMain(context.Background(), osClient, opts, outFile))
+
+	content, err := os.ReadFile(outFile.Name())
+	require.NoError(t, err)
+	var result []mockOSResource
+	require.NoError(t, json.Unmarshal(content, &result))
+	locations := make([]string, 0, len(result))
+	for _, resource := range result {
+		locations = append(locations, resource.ID+"@"+resource.Cloud+"/"+resource.Region)
+	}
+	require.Equal(t, []string{"1@a/r1", "2@a/r1", "1@b/r2", "2@b/r2"}, locations)
+}
+
+func Test_runMainListError(t *testing.T) {
+	devNull, _ := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	defer devNull.Close()
+	listErr := &openstack.Error{Kind: openstack.ErrList, Op: "list servers", Err: errors.New("boom")}
+	opts := cliOptions{kind: kindServer, action: "list", output: "json", includeRe: "(.+)__.*", logLevel: "info"}
+
+	err := runMain(context.Background(), &mockOSclient{listErr: listErr}, opts, devNull)
+	require.ErrorIs(t, err, openstack.ErrList)
+	require.Equal(t, ExitError, exitCode(err))
+
+	// Partial results from the clouds that didn't fail are still listed,
+	// or acted upon, the run failing partially
+	multi := openstack.NewMultiClient(&mockOSclient{listErr: listErr}, &mockOSclient{cloud: "b"})
+	outPath := filepath.Join(t.TempDir(), "out.json")
+	outFile, err := os.Create(outPath)
+	require.NoError(t, err)
+	err = runMain(context.Background(), multi, opts, outFile)
+	outFile.Close()
+	require.ErrorIs(t, err, openstack.ErrList)
+	require.Equal(t, ExitPartial, exitCode(err))
+	out, err := os.ReadFile(outPath)
+	require.NoError(t, err)
+	var listed []map[string]interface{}
+	require.NoError(t, json.Unmarshal(out, &listed), "-o json output still parses")
+	require.Len(t, listed, 2)
+
+	opts.action = "stop"
+	opts.doit = true
+	multi = openstack.NewMultiClient(&mockOSclient{listErr: listErr}, &mockOSclient{cloud: "b"})
+	err = runMain(context.Background(), multi, opts, devNull)
+	require.ErrorIs(t, err, openstack.ErrList)
+	require.Equal(t, ExitPartial, exitCode(err))
+}
+
+func Test_newOwnerResolver(t *testing.T) {
+	opts := cliOptions{ownerResolvers: []string{"keystone-extra", "keystone-roles", "regex"}, ownerRole: "admin"}
+	resolver, err := newOwnerResolver(&openstack.OSClient{}, opts)
+	require.NoError(t, err)
+	require.Equal(t, "keystone-extra,keystone-roles,regex", resolver.Name())
+
+	opts.ownerResolvers = []string{"ldap"}
+	_, err = newOwnerResolver(&openstack.OSClient{}, opts)
+	require.ErrorContains(t, err, "Invalid owner resolver: ldap")
+}
+
+func Test_ownerFlags(t *testing.T) {
+	for _, cmd := range []*cobra.Command{cmdServer(), cmdApply(), cmdUndo()} {
+		for flag, value := range map[string]string{"owner-resolvers": "[regex]", "owner-role": "admin"} {
+			require.NotNil(t, cmd.Flag(flag), "%s --%s", cmd.Name(), flag)
+			require.Equal(t, value, cmd.Flag(flag).DefValue, "%s --%s", cmd.Name(), flag)
+		}
+	}
+}
+
+// Test_serverCommandFakeCloud runs `os_cleanup server` end to end, against
+// the real client talking to an in-process fake cloud
+func Test_serverCommandFakeCloud(t *testing.T) {
+	cloud := fakeopenstack.New()
+	defer cloud.Close()
+	cloud.PageSize = 2
+	cloud.AddProject(fakeopenstack.Project{ID: "p1", Name: "foo__bar.com_project"})
+	cloud.AddProject(fakeopenstack.Project{ID: "p2", Name: "admin"})
+	old := time.Now().AddDate(0, 0, -90)
+	cloud.AddServer(fakeopenstack.Server{ID: "s1", Name: "old", ProjectID: "p1", Created: old})
+	cloud.AddServer(fakeopenstack.Server{ID: "s2", Name: "new", ProjectID: "p1", Created: time.Now()})
+	cloud.AddServer(fakeopenstack.Server{ID: "s3", Name: "old-admin", ProjectID: "p2", Created: old})
+	cloud.AddServer(fakeopenstack.Server{ID: "s4", Name: "old-stopped", ProjectID: "p1", Created: old, Tags: []string{"keep"}})
+
+	dir := t.TempDir()
+	cloudsYAML := filepath.Join(dir, "clouds.yaml")
+	require.NoError(t, cloud.WriteCloudsYAML(cloudsYAML, "fake"))
+	t.Setenv("OS_CLIENT_CONFIG_FILE", cloudsYAML)
+	auditLog := filepath.Join(dir, "audit.jsonl")
+
+	run := func(args ...string) error {
+		cmd := NewRootCommand()
+		cmd.SetArgs(append([]string{
+			"server", "--cloud", "fake", "--include-re", "(.+)__.*", "--loglevel", "error",
+			"--journal-dir", filepath.Join(dir, "journal"), "--audit-log", auditLog, "--retry-delay", "1ms",
+		}, args...))
+		return cmd.Execute()
+	}
+	tags := func(id string) []string {
+		server, ok := cloud.GetServer(id)
+		require.True(t, ok, id)
+		return server.Tags
+	}
+
+	require.NoError(t, run("--action", "tag", "--yes"))
+	require.Equal(t, []string{osCleanupTag}, tags("s1"))
+	require.Empty(t, tags("s2"), "too new")
+	require.Empty(t, tags("s3"), "excluded project")
+	require.Equal(t, []string{"keep", osCleanupTag}, tags("s4"))
+	require.ElementsMatch(t, []string{"s1", "s4"}, auditIDs(t, auditLog))
+
+	// A transient failure is retried, a persistent one fails just that server
+	cloud.Fail(fakeopenstack.Failure{
+		Method: http.MethodPost, Path: fakeopenstack.ComputePath + "servers/s1/action", Status: http.StatusConflict, Times: 1,
+	})
+	cloud.Fail(fakeopenstack.Failure{
+		Method: http.MethodPost, Path: fakeopenstack.ComputePath + "servers/s4/action", Status: http.StatusForbidden,
+	})
+	err := run("--action", "stop", "--tagged", "--yes")
+	require.Equal(t, ExitPartial, exitCode(err))
+	s1, _ := cloud.GetServer("s1")
+	require.Equal(t, "stopped", s1.VMState)
+	require.Equal(t, 2, cloud.Requests(http.MethodPost, fakeopenstack.ComputePath+"servers/s1/action"))
+	require.Equal(t, 1, cloud.Requests(http.MethodPost, fakeopenstack.ComputePath+"servers/s4/action"))
+
+	require.NoError(t, run("--action", "untag", "--yes"))
+	require.Empty(t, tags("s1"))
+	require.Equal(t, []string{"keep"}, tags("s4"))
+}
+
+func Test_serverListOpts(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        cliOptions
+		wantTags    []string
+		wantNotTags []string
+		wantStatus  string
+	}{
+		{"list", cliOptions{action: "list", tagValue: osCleanupTag}, nil, nil, ""},
+		{"list tagged", cliOptions{action: "list", tagValue: osCleanupTag, tagged: true}, []string{osCleanupTag}, nil, ""},
+		{"tag", cliOptions{action: "tag", tagValue: osCleanupTag}, nil, []string{osCleanupTag}, ""},
+		{"untag", cliOptions{action: "untag", tagValue: osCleanupTag}, []string{osCleanupTag}, nil, ""},
+		{"stop tagged", cliOptions{action: "stop", tagValue: osCleanupTag, tagged: true}, []string{osCleanupTag}, nil, "ACTIVE"},
+		{"start", cliOptions{action: "start", tagValue: osCleanupTag}, nil, nil, "SHUTOFF"},
+		{"lifecycle", cliOptions{action: "lifecycle", tagValue: osCleanupTag}, nil, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.nDays = 30
+			tt.opts.includeRe = "(.+)__.*"
+			listOpts := serverListOpts(tt.opts)
+			require.Equal(t, tt.wantTags, listOpts.Tags)
+			require.Equal(t, tt.wantNotTags, listOpts.NotTags)
+			require.Equal(t, tt.wantStatus, listOpts.Status)
+			require.Nil(t, listOpts.ProjectRe)
+			require.WithinDuration(t, time.Now().AddDate(0, 0, -30), listOpts.CreatedBefore, time.Minute)
+		})
+	}
+}
+
+func Test_serverListOptsIncludeFields(t *testing.T) {
+	opts := cliOptions{action: "list", includeRe: "(.+)__.*", includeFields: []string{"project"}}
+	require.Equal(t, "(.+)__.*", serverListOpts(opts).ProjectRe.String())
+
+	// Projects can't be narrowed if --include-re is about other fields, as
+	// by default the whole searchable string
+	opts.includeFields = []string{"name", "project"}
+	require.Nil(t, serverListOpts(opts).ProjectRe)
+	opts.includeFields = nil
+	require.Nil(t, serverListOpts(opts).ProjectRe)
+}
+
+func Test_newFilterFields(t *testing.T) {
+	opts := cliOptions{kind: kindServer, includeRe: "one|two", excludeRe: "^tag1$", excludeFields: []string{"tags"}}
+	filter, err := newFilter(opts)
+	require.NoError(t, err)
+	require.Equal(t, `include="one|two" exclude="^tag1$" exclude_fields=tags`, strings.SplitN(filter.String(), " ", 2)[1])
+	require.False(t, filter.Run(m1))
+	require.True(t, filter.Run(m2))
+
+	opts.includeFields = []string{"flavour"}
+	_, err = newFilter(opts)
+	require.EqualError(t, err,
+		`Invalid field "flavour" for server, valid ones: name, id, project, email, tags, metadata, image, flavor`)
+	opts.kind = kindFloatingIP
+	opts.includeFields = []string{"image"}
+	_, err = newFilter(opts)
+	require.ErrorContains(t, err, `Invalid field "image" for floatingip`)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+
+	"github.com/jjo/openstack-ops/pkg/openstack"
+)
+
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusSkipped   = "skipped"
+)
+
+// Process exit codes, so that e.g. a cron wrapper can tell partial failures
+const (
+	ExitOK      = 0
+	ExitError   = 1 // usage, auth, listing, etc errors before acting
+	ExitPartial = 2 // some resources failed
+	ExitTotal   = 3 // all resources acted upon failed
+
+	ExitInterrupted = 130 // SIGINT or SIGTERM, the resources not started yet skipped
+)
+
+var errInterrupted = errors.New("interrupted")
+
+// ResourceResult is the outcome of an action on a single resource, Reason
+// being the error if failed, or why it was skipped
+type ResourceResult struct {
+	Kind    string `json:"kind"`
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Project string `json:"project"`
+	Action  string `json:"action"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	err     error
+}
+
+// RunResult collects ResourceResults from a run, safe for concurrent use
+type RunResult struct {
+	mutex   sync.Mutex
+	Results []ResourceResult
+	// listFailure is the error listing resources, if the ones found were
+	// still acted upon
+	listFailure *ResourceResult
+}
+
+func (run *RunResult) add(result ResourceResult) {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+	run.Results = append(run.Results, result)
+}
+
+// Add records action on resource: failed if err, else succeeded or skipped
+// as told by doit (i.e. without --yes)
+func (run *RunResult) Add(resource openstack.OSResourceInterface, action string, doit bool, err error) {
+	id, name, project := resource.GetData()
+	result := ResourceResult{
+		Kind:    resource.GetKind(),
+		ID:      id,
+		Name:    name,
+		Project: project,
+		Action:  action,
+		Status:  StatusSucceeded,
+	}
+	switch {
+	case err != nil:
+		result.Status = StatusFailed
+		result.Reason = err.Error()
+		result.err = fmt.Errorf("%s %s: %w", action, resource.String(), err)
+	case !doit:
+		result.Status = StatusSkipped
+		result.Reason = "dry-run (missing --yes)"
+	}
+	run.add(result)
+}
+
+// AddNotification records a message sent (or rendered) to email
+func (run *RunResult) AddNotification(email string, resources int, doit bool, err error) {
+	result := ResourceResult{
+		Kind:   "email",
+		ID:     email,
+		Name:   fmt.Sprintf("%d resources", resources),
+		Action: "notify",
+		Status: StatusSucceeded,
+	}
+	switch {
+	case err != nil:
+		result.Status = StatusFailed
+		result.Reason = err.Error()
+		result.err = fmt.Errorf("notify %s: %w", email, err)
+	case !doit:
+		result.Status = StatusSkipped
+		result.Reason = "dry-run (missing --yes)"
+	}
+	run.add(result)
+}
+
+// ListFailed records listing kind resources failed, partially as the ones
+// found are still acted upon, so that the run isn't reported as ok
+func (run *RunResult) ListFailed(kind string, err error) {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+	run.listFailure = &ResourceResult{
+		Kind:   kind,
+		Action: "list",
+		Status: StatusFailed,
+		Reason: err.Error(),
+		err:    fmt.Errorf("list %s: %w", kind, err),
+	}
+}
+
+// Skip records resource wasn't acted upon, for reason
+func (run *RunResult) Skip(kind, id, name, project, action, reason string) {
+	run.add(ResourceResult{
+		Kind: kind, ID: id, Name: name, Project: project, Action: action, Status: StatusSkipped, Reason: reason,
+	})
+}
+
+func (run *RunResult) Counts() (succeeded, failed, skipped int) {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+	for _, result := range run.Results {
+		switch result.Status {
+		case StatusSucceeded:
+			succeeded++
+		case StatusFailed:
+			failed++
+		case StatusSkipped:
+			skipped++
+		}
+	}
+	return
+}
+
+// ExitCode tells if all, some or none of the resources failed, a failed
+// listing being partial at least
+func (run *RunResult) ExitCode() int {
+	succeeded, failed, _ := run.Counts()
+	run.mutex.Lock()
+	listFailed := run.listFailure != nil
+	run.mutex.Unlock()
+	switch {
+	case failed == 0 && !listFailed:
+		return ExitOK
+	case failed > 0 && succeeded == 0:
+		return ExitTotal
+	}
+	return ExitPartial
+}
+
+// Err returns nil if nothing failed, else a *RunError with all of them
+func (run *RunResult) Err() error {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+	errs := make([]error, 0)
+	if run.listFailure != nil {
+		errs = append(errs, run.listFailure.err)
+	}
+	for _, result := range run.Results {
+		if result.err != nil {
+			errs = append(errs, result.err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &RunError{run: run, err: errors.Join(errs...)}
+}
+
+// Summary writes a table with one row per failed or skipped resource, and
+// the totals per status
+func (run *RunResult) Summary(outFile io.Writer) {
+	succeeded, failed, skipped := run.Counts()
+	run.mutex.Lock()
+	if succeeded+failed+skipped == 0 && run.listFailure == nil {
+		run.mutex.Unlock()
+		return
+	}
+	results := make([]ResourceResult, 0, len(run.Results)+1)
+	if run.listFailure != nil {
+		results = append(results, *run.listFailure)
+	}
+	for _, result := range run.Results {
+		if result.Status != StatusSucceeded {
+			results = append(results, result)
+		}
+	}
+	run.mutex.Unlock()
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Status != results[j].Status {
+			return results[i].Status < results[j].Status
+		}
+		return results[i].Project < results[j].Project
+	})
+
+	tw := table.NewWriter()
+	tw.SetTitle("Summary")
+	tw.AppendHeader(table.Row{"Status", "Action", "Kind", "ID", "Name", "Project", "Reason"})
+	for _, r := range results {
+		tw.AppendRow(table.Row{r.Status, r.Action, r.Kind, r.ID, r.Name, r.Project, r.Reason})
+	}
+	tw.AppendFooter(table.Row{
+		fmt.Sprintf("%s: %d", StatusSucceeded, succeeded),
+		fmt.Sprintf("%s: %d", StatusFailed, failed),
+		fmt.Sprintf("%s: %d", StatusSkipped, skipped),
+	})
+	tw.SetStyle(table.StyleLight)
+	fmt.Fprintln(outFile, tw.Render())
+}
+
+// runResult returns opts.result, creating it if needed, to be called before
+// spawning any goroutine
+func runResult(opts *cliOptions) *RunResult {
+	if opts.result == nil {
+		opts.result = &RunResult{}
+	}
+	return opts.result
+}
+
+// RunError is returned by runs with failed resources, carrying the exit code
+type RunError struct {
+	run *RunResult
+	err error
+}
+
+func (runErr *RunError) Error() string {
+	return runErr.err.Error()
+}
+
+func (runErr *RunError) Unwrap() error {
+	return runErr.err
+}
+
+func (runErr *RunError) ExitCode() int {
+	return runErr.run.ExitCode()
+}
+
+// exitCode for the error returned by a command
+func exitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	if errors.Is(err, errInterrupted) {
+		return ExitInterrupted
+	}
+	var runErr *RunError
+	if errors.As(err, &runErr) {
+		return runErr.ExitCode()
+	}
+	return ExitError
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RunResult(t *testing.T) {
+	t.Parallel()
+
+	errConflict := errors.New("409 conflict")
+	tests := []struct {
+		name     string
+		errs     []error
+		doit     bool
+		wantCode int
+	}{
+		{"all ok", []error{nil, nil}, true, ExitOK},
+		{"dry-run", []error{nil, nil}, false, ExitOK},
+		{"partial failure", []error{nil, errConflict}, true, ExitPartial},
+		{"total failure", []error{errConflict, errConflict}, true, ExitTotal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := &RunResult{}
+			for i, err := range tt.errs {
+				m := newMockOSResource(fmt.Sprint(i), "name", "foo__bar.com_project", 60, nil)
+				run.Add(m, "stop", tt.doit, err)
+			}
+			run.Skip("server", "x", "skipped", "foo__bar.com_project", "stop", "not found")
+
+			require.Equal(t, tt.wantCode, run.ExitCode())
+			err := run.Err()
+			require.Equal(t, tt.wantCode, exitCode(err))
+			if tt.wantCode != ExitOK {
+				require.ErrorIs(t, err, errConflict)
+			}
+
+			var out bytes.Buffer
+			run.Summary(&out)
+			require.Contains(t, out.String(), "not found")
+		})
+	}
+
+	require.Equal(t, ExitError, exitCode(errors.New("auth failed")))
+}
+
+func Test_RunResultListFailed(t *testing.T) {
+	t.Parallel()
+
+	errList := errors.New("list volumes: 503")
+	tests := []struct {
+		name     string
+		errs     []error
+		wantCode int
+	}{
+		{"nothing acted upon", nil, ExitPartial},
+		{"all ok", []error{nil, nil}, ExitPartial},
+		{"total failure", []error{errors.New("409 conflict")}, ExitTotal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := &RunResult{}
+			run.ListFailed("volume", errList)
+			for i, err := range tt.errs {
+				run.Add(newMockOSResource(fmt.Sprint(i), "name", "foo__bar.com_project", 60, nil), "delete", true, err)
+			}
+
+			err := run.Err()
+			require.ErrorIs(t, err, errList)
+			require.Equal(t, tt.wantCode, exitCode(err))
+
+			var out bytes.Buffer
+			run.Summary(&out)
+			require.Contains(t, out.String(), "list volumes: 503")
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/alitto/pond"
+	"golang.org/x/time/rate"
+)
+
+// actionRunner runs per-resource actions with at most `workers` of them at
+// once, at most perProject per project (0: no cap) and at most rate per
+// second overall (0: unlimited). Once ctx is done no more actions are
+// started, the in-flight ones finish
+type actionRunner struct {
+	ctx        context.Context
+	perProject int
+	limiter    *rate.Limiter
+	pool       *pond.WorkerPool
+	mutex      sync.Mutex
+	queues     map[string]*projectQueue
+}
+
+// projectQueue holds the tasks of a project waiting for its cap
+type projectQueue struct {
+	running int
+	pending []func()
+}
+
+func newActionRunner(ctx context.Context, workers, perProject int, perSecond float64) *actionRunner {
+	if workers < 1 {
+		workers = 1
+	}
+	runner := &actionRunner{
+		ctx:        ctx,
+		perProject: perProject,
+		pool:       pond.New(workers, 0, pond.MinWorkers(workers)),
+		queues:     make(map[string]*projectQueue),
+	}
+	if perSecond > 0 {
+		runner.limiter = rate.NewLimiter(rate.Limit(perSecond), 1)
+	}
+	return runner
+}
+
+// Submit task to be run, else skip is called with the reason it wasn't.
+// Tasks over their project cap are queued, so that a busy project neither
+// blocks the dispatch of others nor hogs workers waiting for its own cap
+func (runner *actionRunner) Submit(project string, task func(), skip func(reason error)) {
+	run := func() {
+		if runner.limiter != nil {
+			if err := runner.limiter.Wait(runner.ctx); err != nil {
+				skip(contextReason(runner.ctx, err))
+				return
+			}
+		}
+		if runner.ctx.Err() != nil {
+			skip(context.Cause(runner.ctx))
+			return
+		}
+		task()
+	}
+	if runner.perProject < 1 {
+		runner.pool.Submit(run)
+		return
+	}
+
+	runner.mutex.Lock()
+	queue, ok := runner.queues[project]
+	if !ok {
+		queue = &projectQueue{}
+		runner.queues[project] = queue
+	}
+	if queue.running >= runner.perProject {
+		queue.pending = append(queue.pending, run)
+		runner.mutex.Unlock()
+		return
+	}
+	queue.running++
+	runner.mutex.Unlock()
+
+	// The worker keeps running the project's queued tasks until drained
+	runner.pool.Submit(func() {
+		for run != nil {
+			run()
+			run = runner.next(queue)
+		}
+	})
+}
+
+// next pops the next pending task from queue, nil if none (freeing its slot)
+func (runner *actionRunner) next(queue *projectQueue) func() {
+	runner.mutex.Lock()
+	defer runner.mutex.Unlock()
+	if len(queue.pending) == 0 {
+		queue.running--
+		return nil
+	}
+	run := queue.pending[0]
+	queue.pending = queue.pending[1:]
+	return run
+}
+
+// contextReason is the cause of ctx being done if so, else err
+func contextReason(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return err
+}
+
+// Wait for all submitted tasks to finish, runner can't be reused afterwards
+func (runner *actionRunner) Wait() {
+	runner.pool.StopAndWait()
+}
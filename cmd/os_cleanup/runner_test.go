@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_actionRunner(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		workers        int
+		perProject     int
+		wantMax        int32
+		wantMaxProject int32
+	}{
+		{"workers only", 4, 0, 4, 4},
+		{"per-project cap", 4, 1, 2, 1},
+		{"cap above workers", 2, 5, 2, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var running, maxRunning int32
+			runningProject := map[string]*int32{"foo": new(int32), "bar": new(int32)}
+			maxProject := map[string]*int32{"foo": new(int32), "bar": new(int32)}
+			setMax := func(max *int32, value int32) {
+				for {
+					old := atomic.LoadInt32(max)
+					if value <= old || atomic.CompareAndSwapInt32(max, old, value) {
+						return
+					}
+				}
+			}
+
+			var done int32
+			runner := newActionRunner(context.Background(), tt.workers, tt.perProject, 0)
+			for i := 0; i < 20; i++ {
+				project := []string{"foo", "bar"}[i%2]
+				runner.Submit(project, func() {
+					setMax(&maxRunning, atomic.AddInt32(&running, 1))
+					setMax(maxProject[project], atomic.AddInt32(runningProject[project], 1))
+					time.Sleep(5 * time.Millisecond)
+					atomic.AddInt32(runningProject[project], -1)
+					atomic.AddInt32(&running, -1)
+					atomic.AddInt32(&done, 1)
+				}, func(reason error) { t.Error(reason) })
+			}
+			runner.Wait()
+
+			require.Equal(t, int32(20), done)
+			require.Equal(t, tt.wantMax, maxRunning, "max concurrent")
+			for project, max := range maxProject {
+				require.LessOrEqual(t, *max, tt.wantMaxProject, fmt.Sprintf("max concurrent for %s", project))
+			}
+		})
+	}
+}
+
+func Test_actionRunnerBusyProject(t *testing.T) {
+	t.Parallel()
+
+	// foo's first task only finishes once bar's ran: neither the dispatch of
+	// bar nor a worker for it must wait on foo's cap
+	barDone := make(chan struct{})
+	var order []string
+	var mutex sync.Mutex
+	record := func(name string) {
+		mutex.Lock()
+		order = append(order, name)
+		mutex.Unlock()
+	}
+	runner := newActionRunner(context.Background(), 2, 1, 0)
+	submitted := make(chan struct{})
+	go func() {
+		defer close(submitted)
+		runner.Submit("foo", func() {
+			select {
+			case <-barDone:
+			case <-time.After(5 * time.Second):
+				t.Error("bar blocked by busy foo")
+			}
+			record("foo1")
+		}, func(reason error) { t.Error(reason) })
+		for i := 2; i <= 3; i++ {
+			name := fmt.Sprintf("foo%d", i)
+			runner.Submit("foo", func() { record(name) }, func(reason error) { t.Error(reason) })
+		}
+		runner.Submit("bar", func() {
+			record("bar1")
+			close(barDone)
+		}, func(reason error) { t.Error(reason) })
+	}()
+
+	select {
+	case <-submitted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Submit blocked by busy foo")
+	}
+	runner.Wait()
+	require.Equal(t, []string{"bar1", "foo1", "foo2", "foo3"}, order)
+}
+
+func Test_actionRunnerRate(t *testing.T) {
+	t.Parallel()
+
+	var mutex sync.Mutex
+	times := make([]time.Time, 0)
+	runner := newActionRunner(context.Background(), 4, 0, 50)
+	for i := 0; i < 5; i++ {
+		runner.Submit("foo", func() {
+			mutex.Lock()
+			times = append(times, time.Now())
+			mutex.Unlock()
+		}, func(reason error) { t.Error(reason) })
+	}
+	runner.Wait()
+
+	require.Len(t, times, 5)
+	first, last := times[0], times[0]
+	for _, ts := range times {
+		if ts.Before(first) {
+			first = ts
+		}
+		if ts.After(last) {
+			last = ts
+		}
+	}
+	// 5 actions at 50/s: 4 intervals of 20ms
+	require.GreaterOrEqual(t, last.Sub(first), 70*time.Millisecond)
+}
+
+func Test_actionRunnerCanceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(errInterrupted)
+	for _, perSecond := range []float64{0, 1} {
+		var skipped int32
+		runner := newActionRunner(ctx, 2, 0, perSecond)
+		for i := 0; i < 5; i++ {
+			runner.Submit("foo", func() { t.Error("task run after cancel") }, func(reason error) {
+				require.ErrorIs(t, reason, errInterrupted)
+				atomic.AddInt32(&skipped, 1)
+			})
+		}
+		runner.Wait()
+		require.Equal(t, int32(5), skipped)
+	}
+}
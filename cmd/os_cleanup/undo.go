@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+
+	"github.com/jjo/openstack-ops/pkg/audit"
+	"github.com/jjo/openstack-ops/pkg/journal"
+	"github.com/jjo/openstack-ops/pkg/logger"
+	"github.com/jjo/openstack-ops/pkg/openstack"
+)
+
+// undoEntry reverts a single journal entry on resource, returns a description
+// of what was (or would be) done, empty if there was nothing to revert
+func undoEntry(ctx context.Context, entry journal.Entry, resource openstack.OSResourceInterface, doit bool) (string, error) {
+	unsupported := fmt.Errorf("cannot undo %s for kind %s", entry.Action, entry.Kind)
+	switch entry.Action {
+	case "stop", "start":
+		power, ok := resource.(openstack.PowerController)
+		if !ok {
+			return "", unsupported
+		}
+		wasRunning := entry.PowerState == openstack.PowerStateRunning
+		switch {
+		case entry.Action == "stop" && wasRunning:
+			if doit {
+				return "Starting", power.Start(ctx)
+			}
+			return "Starting", nil
+		case entry.Action == "start" && !wasRunning:
+			if doit {
+				return "Stopping", power.Stop(ctx)
+			}
+			return "Stopping", nil
+		}
+	case "tag", "untag":
+		tagger, ok := resource.(openstack.Tagger)
+		if !ok {
+			return "", unsupported
+		}
+		hadTag := entry.HasTag(entry.Tag)
+		switch {
+		case entry.Action == "tag" && !hadTag:
+			if doit {
+				return "Untagging " + entry.Tag, tagger.Untag(ctx, entry.Tag)
+			}
+			return "Untagging " + entry.Tag, nil
+		case entry.Action == "untag" && hadTag:
+			if doit {
+				return "Tagging " + entry.Tag, tagger.Tag(ctx, entry.Tag)
+			}
+			return "Tagging " + entry.Tag, nil
+		}
+	default:
+		return "", unsupported
+	}
+	return "", nil
+}
+
+// undoConfigs returns the clouds and regions the entries to undo were
+// recorded at, else (e.g. older journals) as per --cloud and --region
+func undoConfigs(entries []journal.Entry, opts cliOptions) []openstack.CloudConfig {
+	configs := make([]openstack.CloudConfig, 0)
+	add := func(config openstack.CloudConfig) {
+		if !slices.Contains(configs, config) {
+			configs = append(configs, config)
+		}
+	}
+	for _, entry := range entries {
+		if entry.Result != journal.ResultOK || entry.Action == "delete" {
+			continue
+		}
+		if entry.Cloud == "" && entry.Region == "" {
+			for _, config := range openstack.CloudConfigs(opts.clouds, opts.regions, opts.osInterface) {
+				add(config)
+			}
+			continue
+		}
+		add(openstack.CloudConfig{Cloud: entry.Cloud, Region: entry.Region, Interface: opts.osInterface})
+	}
+	if len(configs) == 0 {
+		return openstack.CloudConfigs(opts.clouds, opts.regions, opts.osInterface)
+	}
+	return configs
+}
+
+// undoTarget returns the resource entry was recorded for among found ones
+// with its kind and ID, i.e. at the same cloud and region if recorded
+func undoTarget(entry journal.Entry, found []openstack.OSResourceInterface) (openstack.OSResourceInterface, bool) {
+	for _, resource := range found {
+		if entry.Cloud == "" && entry.Region == "" ||
+			resource.GetCloud() == entry.Cloud && resource.GetRegion() == entry.Region {
+			return resource, true
+		}
+	}
+	return nil, false
+}
+
+// runUndo reverts run journal `runID`: a stop by starting what was running,
+// a tag by removing it where it wasn't there before, etc, at the clouds and
+// regions recorded in it, using the client from newClient. Deletes can't be
+// reverted, they're listed into outFile
+func runUndo(
+	ctx context.Context, newClient func([]openstack.CloudConfig) (openstack.OSClientInterface, error),
+	opts cliOptions, runID string, outFile io.Writer,
+) error {
+	_, err := logger.SetLevel(opts.logLevel)
+	if err != nil {
+		return err
+	}
+	entries, err := journal.Load(opts.journalDir, runID)
+	if err != nil {
+		return err
+	}
+	osClient, err := newClient(undoConfigs(entries, opts))
+	if err != nil {
+		return err
+	}
+	if opts.auditLog != "" {
+		opts.audit, err = audit.Open(opts.auditLog, osClient.Operator())
+		if err != nil {
+			return err
+		}
+		defer opts.audit.Close()
+	}
+
+	deleted := make([]journal.Entry, 0)
+	idsByKind := make(map[string]map[string]bool)
+	for _, entry := range entries {
+		if entry.Result != journal.ResultOK {
+			continue
+		}
+		if entry.Action == "delete" {
+			deleted = append(deleted, entry)
+			continue
+		}
+		if idsByKind[entry.Kind] == nil {
+			idsByKind[entry.Kind] = make(map[string]bool)
+		}
+		idsByKind[entry.Kind][entry.ID] = true
+	}
+
+	// Fetch current resources by ID, regardless of age, project, etc
+	resources := make(map[string][]openstack.OSResourceInterface)
+	for kind, ids := range idsByKind {
+		kindOpts := opts
+		kindOpts.kind = kind
+		kindOpts.inUse = true
+		found, err := getResources(dispatchContext(ctx, &opts), osClient, kindOpts, func(resource openstack.OSResourceInterface) bool {
+			id, _, _ := resource.GetData()
+			return ids[id]
+		})
+		if err != nil {
+			return err
+		}
+		for _, resource := range found {
+			id, _, _ := resource.GetData()
+			resources[kind+"/"+id] = append(resources[kind+"/"+id], resource)
+		}
+	}
+
+	run := runResult(&opts)
+	for _, entry := range entries {
+		if entry.Result != journal.ResultOK || entry.Action == "delete" {
+			continue
+		}
+		action := "undo:" + entry.Action
+		if dispatch := dispatchContext(ctx, &opts); dispatch.Err() != nil {
+			reason := context.Cause(dispatch).Error()
+			log.Warningf("Skipping undo %s: %s %s (%s): %s\n", entry.Action, entry.Kind, entry.ID, entry.Name, reason)
+			run.Skip(entry.Kind, entry.ID, entry.Name, entry.Project, action, reason)
+			continue
+		}
+		resource, ok := undoTarget(entry, resources[entry.Kind+"/"+entry.ID])
+		if !ok {
+			log.Errorf("Error undoing %s: %s %s (%s) not found\n", entry.Action, entry.Kind, entry.ID, entry.Name)
+			run.Skip(entry.Kind, entry.ID, entry.Name, entry.Project, action, "not found")
+			continue
+		}
+
+		msg, err := undoEntry(ctx, entry, resource, opts.doit)
+		if msg == "" && err == nil {
+			log.Debugf("Nothing to undo for %s: %s\n", entry.Action, resource.String())
+			run.Skip(entry.Kind, entry.ID, entry.Name, entry.Project, action, "nothing to undo")
+			continue
+		}
+		log.Infof("%s: %s\n", yesnoStr(opts.doit, msg+" (undo "+runID+")"), resource.String())
+		auditRecord(&opts, action, resource, err)
+		run.Add(resource, action, opts.doit, err)
+		if err != nil {
+			log.Errorf("Error undoing %s %s: %s\n", entry.Action, resource.String(), err)
+		}
+	}
+
+	if len(deleted) > 0 {
+		log.Warningf("%d deleted resources can't be undone\n", len(deleted))
+		tw := table.NewWriter()
+		tw.SetTitle("Deleted (cannot undo)")
+		tw.AppendHeader(table.Row{"Kind", "ID", "Name", "Project", "Timestamp"})
+		for _, entry := range deleted {
+			tw.AppendRow(table.Row{entry.Kind, entry.ID, entry.Name, entry.Project, entry.Timestamp})
+		}
+		tw.SetStyle(table.StyleLight)
+		fmt.Fprintln(outFile, tw.Render())
+	}
+	run.Summary(outFile)
+	return interruptedErr(ctx, &opts, run.Err())
+}
+
+func cmdUndo() *cobra.Command {
+	opts := &cliOptions{}
+	cmd := &cobra.Command{
+		Use:   "undo <run-id>",
+		Short: "Revert a stop, start, tag or untag run recorded in --journal-dir",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := runContext(opts)
+			defer cancel()
+			newClient := func(configs []openstack.CloudConfig) (openstack.OSClientInterface, error) {
+				return newOSClient(configs, *opts)
+			}
+			return runUndo(ctx, newClient, *opts, args[0], os.Stdout)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&opts.journalDir, "journal-dir", "", defaultJournalDir(), "`dir` holding run journals")
+	flags.BoolVarP(&opts.doit, "yes", "", false, "commit reverting actions")
+	flags.StringVarP(&opts.logLevel, "loglevel", "l", "info", "set log level: debug, info, notice, warning, error, critical")
+	flags.IntVarP(&opts.workers, "workers", "w", workerCount, "number of workers")
+	flags.StringVarP(&opts.auditLog, "audit-log", "", "", "append a JSON Lines record of every reverting action to `file`")
+	addOwnerFlags(flags, opts)
+	addRetryFlags(flags, opts)
+	addTimeoutFlags(flags, opts)
+	// Only for journals without clouds and regions recorded, e.g. older ones
+	addCloudFlags(flags, opts)
+	return cmd
+}
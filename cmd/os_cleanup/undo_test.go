@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jjo/openstack-ops/pkg/journal"
+	"github.com/jjo/openstack-ops/pkg/openstack"
+)
+
+func Test_undoEntry(t *testing.T) {
+	t.Parallel()
+
+	running := openstack.PowerStateRunning
+	tests := []struct {
+		name      string
+		entry     journal.Entry
+		wantMsg   string
+		wantStart int
+		wantStop  int
+		wantTag   int
+		wantUntag int
+	}{
+		{"stop was running: start", journal.Entry{Action: "stop", PowerState: running}, "Starting", 1, 0, 0, 0},
+		{"stop was shutdown: noop", journal.Entry{Action: "stop", PowerState: "SHUTDOWN"}, "", 0, 0, 0, 0},
+		{"start was shutdown: stop", journal.Entry{Action: "start", PowerState: "SHUTDOWN"}, "Stopping", 0, 1, 0, 0},
+		{"tag new: untag", journal.Entry{Action: "tag", Tag: "x", Tags: []string{}}, "Untagging x", 0, 0, 0, 1},
+		{"tag existing: noop", journal.Entry{Action: "tag", Tag: "x", Tags: []string{"x"}}, "", 0, 0, 0, 0},
+		{"untag existing: tag", journal.Entry{Action: "untag", Tag: "x", Tags: []string{"x"}}, "Tagging x", 0, 0, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := copyMockOSResource(m1)
+			msg, err := undoEntry(context.Background(), tt.entry, m, false)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantMsg, msg)
+			require.Equal(t, 0, m.calledStart+m.calledStop+m.calledTag+m.calledUntag, "without --yes")
+
+			msg, err = undoEntry(context.Background(), tt.entry, m, true)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantMsg, msg)
+			require.Equal(t, tt.wantStart, m.calledStart, "Start() calls")
+			require.Equal(t, tt.wantStop, m.calledStop, "Stop() calls")
+			require.Equal(t, tt.wantTag, m.calledTag, "Tag() calls")
+			require.Equal(t, tt.wantUntag, m.calledUntag, "Untag() calls")
+		})
+	}
+
+	_, err := undoEntry(context.Background(), journal.Entry{Action: "delete", Kind: "server"}, m1, true)
+	require.ErrorContains(t, err, "cannot undo delete")
+}
+
+func newMockClient([]openstack.CloudConfig) (openstack.OSClientInterface, error) {
+	return NewMockOSClient(), nil
+}
+
+func Test_runUndo(t *testing.T) {
+	dir := t.TempDir()
+	opts := cliOptions{
+		kind:       kindServer,
+		action:     "stop",
+		output:     "table",
+		nDays:      nDays1,
+		logLevel:   "info",
+		doit:       true,
+		journalDir: dir,
+	}
+	devNull, _ := os.Open(os.DevNull)
+	defer devNull.Close()
+
+	require.NoError(t, runMain(context.Background(), NewMockOSClient(), opts, devNull))
+	runIDs, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, runIDs, 1)
+	runID := runIDs[0].Name()[:len(runIDs[0].Name())-len(".jsonl")]
+
+	entries, err := journal.Load(dir, runID)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "stop", entries[0].Action)
+	require.Equal(t, openstack.PowerStateRunning, entries[0].PowerState)
+
+	var out bytes.Buffer
+	require.NoError(t, runUndo(context.Background(), newMockClient, opts, runID, &out))
+	require.Contains(t, out.String(), "SUCCEEDED: 2")
+	require.NotContains(t, out.String(), "cannot undo")
+
+	// deletes are listed as not undoable
+	deleteJournal, err := journal.Create(dir, "delete-run")
+	require.NoError(t, err)
+	require.NoError(t, deleteJournal.Add(journal.Entry{Action: "delete", Kind: kindServer, ID: "gone", Result: journal.ResultOK}))
+	require.NoError(t, deleteJournal.Close())
+
+	out.Reset()
+	require.NoError(t, runUndo(context.Background(), newMockClient, opts, "delete-run", &out))
+	require.Contains(t, out.String(), "cannot undo")
+	require.Contains(t, out.String(), "gone")
+
+	require.ErrorContains(t, runUndo(context.Background(), newMockClient, opts, "missing", &out), "Failed to open journal")
+}
+
+func Test_runUndoClouds(t *testing.T) {
+	dir := t.TempDir()
+	opts := cliOptions{
+		kind:       kindServer,
+		action:     "stop",
+		output:     "table",
+		nDays:      nDays1,
+		logLevel:   "info",
+		doit:       true,
+		journalDir: dir,
+	}
+	devNull, _ := os.Open(os.DevNull)
+	defer devNull.Close()
+
+	// Same IDs at both clouds
+	multi := openstack.NewMultiClient(&mockOSclient{cloud: "a", region: "r1"}, &mockOSclient{cloud: "b", region: "r2"})
+	require.NoError(t, runMain(context.Background(), multi, opts, devNull))
+	runIDs, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, runIDs, 1)
+	runID := runIDs[0].Name()[:len(runIDs[0].Name())-len(".jsonl")]
+	entries, err := journal.Load(dir, runID)
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+
+	// Undone at the recorded clouds and regions, regardless of --cloud
+	opts.clouds = []string{"other"}
+	var configs []openstack.CloudConfig
+	newClient := func(c []openstack.CloudConfig) (openstack.OSClientInterface, error) {
+		configs = c
+		clients := make([]openstack.OSClientInterface, 0, len(c))
+		for _, config := range c {
+			clients = append(clients, &mockOSclient{cloud: config.Cloud, region: config.Region})
+		}
+		return openstack.NewMultiClient(clients...), nil
+	}
+	var out bytes.Buffer
+	require.NoError(t, runUndo(context.Background(), newClient, opts, runID, &out))
+	require.Equal(t, []openstack.CloudConfig{{Cloud: "a", Region: "r1"}, {Cloud: "b", Region: "r2"}}, configs)
+	require.Contains(t, out.String(), "SUCCEEDED: 4")
+
+	// Each entry matched to the resource at its own cloud
+	found := []openstack.OSResourceInterface{
+		&mockOSResource{ID: "1", Cloud: "a", Region: "r1"}, &mockOSResource{ID: "1", Cloud: "b", Region: "r2"},
+	}
+	matched := make(map[string]int)
+	for _, entry := range entries {
+		if entry.ID != "1" {
+			continue
+		}
+		resource, ok := undoTarget(entry, found)
+		require.True(t, ok)
+		require.Equal(t, entry.Cloud, resource.GetCloud())
+		matched[entry.Cloud]++
+	}
+	require.Equal(t, map[string]int{"a": 1, "b": 1}, matched)
+	_, ok := undoTarget(journal.Entry{ID: "1", Cloud: "c", Region: "r1"}, found)
+	require.False(t, ok, "not at the recorded cloud")
+
+	// Older journals, without clouds and regions, as per --cloud and --region
+	require.Equal(t, []openstack.CloudConfig{{Cloud: "other"}},
+		undoConfigs([]journal.Entry{{Action: "stop", Result: journal.ResultOK}}, opts))
+}
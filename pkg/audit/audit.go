@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	ResultOK     = "ok"
+	ResultError  = "error"
+	ResultDryRun = "dry-run"
+)
+
+// Record is a single JSON line in the audit log, one per resource touched
+type Record struct {
+	Timestamp time.Time `json:"timestamp"`
+	Operator  string    `json:"operator"`
+	Action    string    `json:"action"`
+	Kind      string    `json:"kind"`
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Project   string    `json:"project"`
+	ProjectID string    `json:"project_id"`
+	Cloud     string    `json:"cloud,omitempty"`
+	Region    string    `json:"region,omitempty"`
+	Email     string    `json:"email"`
+	Filter    string    `json:"filter"`
+	DryRun    bool      `json:"dry_run"`
+	Result    string    `json:"result"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Logger appends Records to a JSON Lines file, safe for concurrent use
+type Logger struct {
+	mutex    sync.Mutex
+	file     *os.File
+	Operator string
+}
+
+// Open appends to path the records of operator, who's running the tool as
+// known by OpenStack
+func Open(path, operator string) (*Logger, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open audit log: %w", err)
+	}
+
+	return &Logger{file: file, Operator: operator}, nil
+}
+
+// Log fills in Timestamp and Operator, and appends record as a single line
+func (logger *Logger) Log(record Record) error {
+	record.Timestamp = time.Now().UTC()
+	record.Operator = logger.Operator
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	_, err = logger.file.Write(append(line, '\n'))
+
+	return err
+}
+
+func (logger *Logger) Close() error {
+	return logger.file.Close()
+}
+
+// Query selects Records, zero-valued fields match everything
+type Query struct {
+	Project string
+	ID      string
+	Since   time.Time
+	Until   time.Time
+}
+
+func (query *Query) Match(record *Record) bool {
+	return (query.Project == "" || query.Project == record.Project || query.Project == record.ProjectID) &&
+		(query.ID == "" || query.ID == record.ID) &&
+		(query.Since.IsZero() || !record.Timestamp.Before(query.Since)) &&
+		(query.Until.IsZero() || record.Timestamp.Before(query.Until))
+}
+
+// Search returns Records from a JSON Lines audit log matching query
+func Search(reader io.Reader, query Query) ([]Record, error) {
+	records := make([]Record, 0)
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("Invalid audit log line %d: %w", lineNum, err)
+		}
+		if query.Match(&record) {
+			records = append(records, record)
+		}
+	}
+
+	return records, scanner.Err()
+}
@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogAndSearch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	logger, err := Open(path, "admin")
+	require.NoError(t, err)
+	require.NoError(t, logger.Log(Record{Action: "stop", Kind: "server", ID: "1", Project: "foo", Result: ResultOK}))
+	require.NoError(t, logger.Log(Record{Action: "delete", Kind: "server", ID: "2", Project: "bar", Result: ResultError, Error: "409"}))
+	require.NoError(t, logger.Close())
+
+	// append-only: reopening doesn't truncate
+	logger, err = Open(path, "admin")
+	require.NoError(t, err)
+	require.NoError(t, logger.Log(Record{Action: "tag", Kind: "volume", ID: "3", Project: "foo", DryRun: true, Result: ResultDryRun}))
+	require.NoError(t, logger.Close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	all, err := Search(file, Query{})
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	require.Equal(t, "admin", all[0].Operator)
+	require.False(t, all[0].Timestamp.IsZero())
+
+	tests := []struct {
+		name  string
+		query Query
+		ids   []string
+	}{
+		{"by project", Query{Project: "foo"}, []string{"1", "3"}},
+		{"by id", Query{ID: "2"}, []string{"2"}},
+		{"since future", Query{Since: time.Now().Add(time.Hour)}, []string{}},
+		{"until future", Query{Until: time.Now().Add(time.Hour)}, []string{"1", "2", "3"}},
+	}
+	for _, tt := range tests {
+		records, err := Search(mustReadString(t, path), tt.query)
+		require.NoError(t, err, tt.name)
+		ids := make([]string, 0)
+		for _, r := range records {
+			ids = append(ids, r.ID)
+		}
+		require.Equal(t, tt.ids, ids, tt.name)
+	}
+}
+
+func TestSearchInvalidLine(t *testing.T) {
+	_, err := Search(strings.NewReader("{\"id\":\"1\"}\nnot json\n"), Query{})
+	require.ErrorContains(t, err, "line 2")
+}
+
+func mustReadString(t *testing.T, path string) *strings.Reader {
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return strings.NewReader(string(content))
+}
@@ -0,0 +1,310 @@
+package fakeopenstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// cinderTime is how Cinder formats timestamps, no timezone
+const cinderTime = "2006-01-02T15:04:05.000000"
+
+type Volume struct {
+	ID         string
+	Name       string
+	ProjectID  string
+	Created    time.Time
+	Updated    time.Time
+	Status     string // default "available", "in-use" if AttachedTo
+	Size       int
+	AttachedTo []string // server IDs
+	SnapshotID string   // created from
+	Metadata   map[string]string
+}
+
+// AddVolume seeds volume, listed in the order added
+func (cloud *Cloud) AddVolume(volume Volume) {
+	cloud.mutex.Lock()
+	defer cloud.mutex.Unlock()
+	if volume.Status == "" {
+		volume.Status = "available"
+		if len(volume.AttachedTo) > 0 {
+			volume.Status = "in-use"
+		}
+	}
+	volume.Metadata = copyMetadata(volume.Metadata)
+	cloud.volumes = append(cloud.volumes, &volume)
+}
+
+// GetVolume returns a copy of volume `id` current state, false if deleted
+func (cloud *Cloud) GetVolume(id string) (Volume, bool) {
+	cloud.mutex.Lock()
+	defer cloud.mutex.Unlock()
+	for _, volume := range cloud.volumes {
+		if volume.ID == id {
+			copied := *volume
+			copied.Metadata = copyMetadata(volume.Metadata)
+			return copied, true
+		}
+	}
+	return Volume{}, false
+}
+
+type Snapshot struct {
+	ID        string
+	Name      string
+	ProjectID string
+	VolumeID  string
+	Created   time.Time
+	Updated   time.Time
+	Status    string // default "available"
+	Size      int
+	Metadata  map[string]string
+	// pending statuses, the next one shown on each GET
+	pending []string
+}
+
+// AddSnapshot seeds volume snapshot, listed in the order added
+func (cloud *Cloud) AddSnapshot(snapshot Snapshot) {
+	cloud.mutex.Lock()
+	defer cloud.mutex.Unlock()
+	if snapshot.Status == "" {
+		snapshot.Status = "available"
+	}
+	snapshot.Metadata = copyMetadata(snapshot.Metadata)
+	cloud.snapshots = append(cloud.snapshots, &snapshot)
+}
+
+// Snapshots returns a copy of the current volume snapshots, e.g. to find the
+// ones created
+func (cloud *Cloud) Snapshots() []Snapshot {
+	cloud.mutex.Lock()
+	defer cloud.mutex.Unlock()
+	snapshots := make([]Snapshot, 0, len(cloud.snapshots))
+	for _, snapshot := range cloud.snapshots {
+		copied := *snapshot
+		copied.Metadata = copyMetadata(snapshot.Metadata)
+		copied.pending = nil
+		snapshots = append(snapshots, copied)
+	}
+	return snapshots
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	copied := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		copied[key] = value
+	}
+	return copied
+}
+
+func volumeBody(volume *Volume) map[string]interface{} {
+	attachments := make([]map[string]interface{}, 0, len(volume.AttachedTo))
+	for _, serverID := range volume.AttachedTo {
+		attachments = append(attachments, map[string]interface{}{"server_id": serverID, "volume_id": volume.ID})
+	}
+	return map[string]interface{}{
+		"id":                           volume.ID,
+		"name":                         volume.Name,
+		"os-vol-tenant-attr:tenant_id": volume.ProjectID,
+		"created_at":                   volume.Created.UTC().Format(cinderTime),
+		"updated_at":                   volume.Updated.UTC().Format(cinderTime),
+		"status":                       volume.Status,
+		"size":                         volume.Size,
+		"attachments":                  attachments,
+		"metadata":                     volume.Metadata,
+		"snapshot_id":                  volume.SnapshotID,
+	}
+}
+
+func snapshotBody(snapshot *Snapshot) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         snapshot.ID,
+		"name":       snapshot.Name,
+		"volume_id":  snapshot.VolumeID,
+		"created_at": snapshot.Created.UTC().Format(cinderTime),
+		"updated_at": snapshot.Updated.UTC().Format(cinderTime),
+		"status":     snapshot.Status,
+		"size":       snapshot.Size,
+		"metadata":   snapshot.Metadata,
+		"os-extended-snapshot-attributes:project_id": snapshot.ProjectID,
+	}
+}
+
+func (cloud *Cloud) serveBlockStorage(w http.ResponseWriter, r *http.Request, resource string) {
+	parts := strings.Split(resource, "/")
+	switch {
+	case parts[0] == "snapshots":
+		cloud.serveSnapshots(w, r, parts)
+		return
+	case parts[0] != "volumes" || len(parts) < 2:
+		replyError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if parts[1] == "detail" && r.Method == http.MethodGet {
+		volumes := make([]map[string]interface{}, 0, len(cloud.volumes))
+		for _, volume := range cloud.volumes {
+			volumes = append(volumes, volumeBody(volume))
+		}
+		reply(w, http.StatusOK, map[string]interface{}{"volumes": volumes})
+		return
+	}
+
+	index := -1
+	for i, volume := range cloud.volumes {
+		if volume.ID == parts[1] {
+			index = i
+		}
+	}
+	if index < 0 {
+		replyError(w, http.StatusNotFound, fmt.Sprintf("Volume %s could not be found.", parts[1]))
+		return
+	}
+	volume := cloud.volumes[index]
+	switch {
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		reply(w, http.StatusOK, map[string]interface{}{"volume": volumeBody(volume)})
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		if volume.Status != "available" && volume.Status != "error" {
+			replyError(w, http.StatusBadRequest, "Volume status must be available or error, but current status is: "+volume.Status)
+			return
+		}
+		cloud.volumes = append(cloud.volumes[:index], cloud.volumes[index+1:]...)
+		w.WriteHeader(http.StatusAccepted)
+	case len(parts) >= 3 && parts[2] == "metadata":
+		serveMetadata(w, r, volume.Metadata, parts[3:])
+		if r.Method != http.MethodGet {
+			volume.Updated = time.Now()
+		}
+	default:
+		replyError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (cloud *Cloud) serveSnapshots(w http.ResponseWriter, r *http.Request, parts []string) {
+	switch {
+	case (len(parts) == 1 || parts[1] == "detail") && r.Method == http.MethodGet:
+		snapshots := make([]map[string]interface{}, 0, len(cloud.snapshots))
+		for _, snapshot := range cloud.snapshots {
+			snapshots = append(snapshots, snapshotBody(snapshot))
+		}
+		reply(w, http.StatusOK, map[string]interface{}{"snapshots": snapshots})
+		return
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		cloud.createSnapshot(w, r)
+		return
+	}
+
+	index := -1
+	for i, snapshot := range cloud.snapshots {
+		if snapshot.ID == parts[1] {
+			index = i
+		}
+	}
+	if index < 0 {
+		replyError(w, http.StatusNotFound, fmt.Sprintf("Snapshot %s could not be found.", parts[1]))
+		return
+	}
+	snapshot := cloud.snapshots[index]
+	switch {
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		if len(snapshot.pending) > 0 {
+			snapshot.Status, snapshot.pending = snapshot.pending[0], snapshot.pending[1:]
+		}
+		reply(w, http.StatusOK, map[string]interface{}{"snapshot": snapshotBody(snapshot)})
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		for _, volume := range cloud.volumes {
+			if volume.SnapshotID == snapshot.ID {
+				replyError(w, http.StatusBadRequest, "Invalid snapshot: snapshot has dependent volumes")
+				return
+			}
+		}
+		cloud.snapshots = append(cloud.snapshots[:index], cloud.snapshots[index+1:]...)
+		w.WriteHeader(http.StatusAccepted)
+	case len(parts) >= 3 && parts[2] == "metadata":
+		serveMetadata(w, r, snapshot.Metadata, parts[3:])
+		if r.Method != http.MethodGet {
+			snapshot.Updated = time.Now()
+		}
+	default:
+		replyError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// createSnapshot adds the snapshot of a volume, going through
+// CreatedSnapshotStatuses
+func (cloud *Cloud) createSnapshot(w http.ResponseWriter, r *http.Request) {
+	var create struct {
+		Snapshot struct {
+			VolumeID string            `json:"volume_id"`
+			Name     string            `json:"name"`
+			Force    bool              `json:"force"`
+			Metadata map[string]string `json:"metadata"`
+		} `json:"snapshot"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&create); err != nil {
+		replyError(w, http.StatusBadRequest, "malformed snapshot")
+		return
+	}
+	var volume *Volume
+	for _, v := range cloud.volumes {
+		if v.ID == create.Snapshot.VolumeID {
+			volume = v
+		}
+	}
+	switch {
+	case volume == nil:
+		replyError(w, http.StatusNotFound, fmt.Sprintf("Volume %s could not be found.", create.Snapshot.VolumeID))
+		return
+	case volume.Status == "in-use" && !create.Snapshot.Force:
+		replyError(w, http.StatusBadRequest, "Volume "+volume.ID+" is in-use, force needed")
+		return
+	}
+
+	now := time.Now()
+	snapshot := &Snapshot{
+		ID:        cloud.nextID("snapshot"),
+		Name:      create.Snapshot.Name,
+		ProjectID: volume.ProjectID,
+		VolumeID:  volume.ID,
+		Created:   now,
+		Updated:   now,
+		Status:    "creating",
+		Size:      volume.Size,
+		Metadata:  copyMetadata(create.Snapshot.Metadata),
+		pending:   append([]string{}, cloud.CreatedSnapshotStatuses...),
+	}
+	cloud.snapshots = append(cloud.snapshots, snapshot)
+	reply(w, http.StatusAccepted, map[string]interface{}{"snapshot": snapshotBody(snapshot)})
+}
+
+// serveMetadata updates (POST, merging) or deletes a key of Cinder metadata
+func serveMetadata(w http.ResponseWriter, r *http.Request, metadata map[string]string, key []string) {
+	switch {
+	case len(key) == 0 && r.Method == http.MethodGet:
+		reply(w, http.StatusOK, map[string]interface{}{"metadata": metadata})
+	case len(key) == 0 && r.Method == http.MethodPost:
+		var update struct {
+			Metadata map[string]string `json:"metadata"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			replyError(w, http.StatusBadRequest, "malformed metadata")
+			return
+		}
+		for k, v := range update.Metadata {
+			metadata[k] = v
+		}
+		reply(w, http.StatusOK, map[string]interface{}{"metadata": metadata})
+	case len(key) == 1 && r.Method == http.MethodDelete:
+		if _, ok := metadata[key[0]]; !ok {
+			replyError(w, http.StatusNotFound, "metadata "+key[0]+" not found")
+			return
+		}
+		delete(metadata, key[0])
+		w.WriteHeader(http.StatusOK)
+	default:
+		replyError(w, http.StatusNotFound, "not found")
+	}
+}
@@ -0,0 +1,660 @@
+// Package fakeopenstack is an in-process fake OpenStack cloud (Keystone,
+// Nova, Cinder, Neutron and Glance so far) for offline tests: seed it with projects and servers, point
+// a clouds.yaml at it (see WriteCloudsYAML), then check its state and the
+// requests it got. Failures can be injected per method and path
+package fakeopenstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Nova power_state values
+const (
+	PowerStateRunning  = 1
+	PowerStateShutdown = 4
+)
+
+// TagsMicroversion is the Nova microversion needed for server tags
+const TagsMicroversion = "2.26"
+
+// Paths served, relative to Cloud.URL
+const (
+	IdentityPath     = "/v3/"
+	ComputePath      = "/compute/v2.1/"
+	BlockStoragePath = "/volume/v3/"
+	NetworkPath      = "/network/v2.0/"
+	ImagePath        = "/image/v2/"
+)
+
+type Project struct {
+	ID   string
+	Name string
+}
+
+type User struct {
+	ID    string
+	Name  string
+	Email string
+}
+
+// RoleAssignment grants user UserID role Role (by name) on ProjectID
+type RoleAssignment struct {
+	ProjectID string
+	UserID    string
+	Role      string
+}
+
+type Server struct {
+	ID         string
+	Name       string
+	ProjectID  string
+	Created    time.Time
+	Updated    time.Time
+	VMState    string // default "active"
+	TaskState  string
+	PowerState int // default PowerStateRunning
+	Tags       []string
+}
+
+// Failure replies requests matching Method (empty: any) and Path (a
+// path.Match pattern, e.g. "/compute/v2.1/servers/*/tags") with Status and
+// Body (default an error message) instead, the first Times of them (0: all)
+type Failure struct {
+	Method string
+	Path   string
+	Status int
+	Body   string
+	Times  int
+}
+
+// failure counts the times Failure replied
+type failure struct {
+	Failure
+	replied int
+}
+
+// Request as recorded by Cloud, Path without the query string
+type Request struct {
+	Method string
+	Path   string
+}
+
+// Cloud is the fake cloud, its exported fields are to be set before any
+// request is made to it
+type Cloud struct {
+	URL      string
+	Region   string
+	Username string
+	Password string
+	// Catalog lists the service types in the token catalog
+	Catalog []string
+	// PageSize is the max servers per page, clients following the next
+	// links (0: all in one page)
+	PageSize int
+	// OmitListTags leaves tags out of server listings (even with microversion
+	// >= 2.26), as some clouds do, to be fetched per server
+	OmitListTags bool
+	// CreatedImageStatuses are the statuses images created from servers go
+	// through, one per GET, the last one staying
+	CreatedImageStatuses []string
+	// CreatedSnapshotStatuses likewise for volume snapshots
+	CreatedSnapshotStatuses []string
+
+	server      *httptest.Server
+	mutex       sync.Mutex
+	projects    []Project
+	users       []User
+	assignments []RoleAssignment
+	servers     []*Server
+	volumes     []*Volume
+	floatingIPs []*FloatingIP
+	images      []*Image
+	snapshots   []*Snapshot
+	lastID      int
+	failures    []*failure
+	requests    []Request
+}
+
+// New starts a Cloud, to be closed with Close()
+func New() *Cloud {
+	cloud := &Cloud{
+		Region:   "RegionOne",
+		Username: "admin",
+		Password: "secret",
+		Catalog:  []string{"identity", "compute", "volumev3", "network", "image"},
+
+		CreatedImageStatuses:    []string{"saving", "active"},
+		CreatedSnapshotStatuses: []string{"creating", "available"},
+	}
+	cloud.server = httptest.NewServer(cloud)
+	cloud.URL = cloud.server.URL
+	return cloud
+}
+
+func (cloud *Cloud) Close() {
+	cloud.server.Close()
+}
+
+// WriteCloudsYAML writes to file a clouds.yaml with entry `name` for cloud,
+// to be used with OS_CLIENT_CONFIG_FILE
+func (cloud *Cloud) WriteCloudsYAML(file, name string) error {
+	return os.WriteFile(file, []byte(fmt.Sprintf(`clouds:
+  %s:
+    region_name: %s
+    auth:
+      auth_url: %s%s
+      username: %s
+      password: %s
+      project_name: admin
+      user_domain_name: Default
+      project_domain_name: Default
+`, name, cloud.Region, cloud.URL, IdentityPath, cloud.Username, cloud.Password)), 0o600)
+}
+
+func (cloud *Cloud) AddProject(project Project) {
+	cloud.mutex.Lock()
+	defer cloud.mutex.Unlock()
+	cloud.projects = append(cloud.projects, project)
+}
+
+func (cloud *Cloud) AddUser(user User) {
+	cloud.mutex.Lock()
+	defer cloud.mutex.Unlock()
+	cloud.users = append(cloud.users, user)
+}
+
+func (cloud *Cloud) AddRoleAssignment(assignment RoleAssignment) {
+	cloud.mutex.Lock()
+	defer cloud.mutex.Unlock()
+	cloud.assignments = append(cloud.assignments, assignment)
+}
+
+// AddServer seeds server, listed in the order added
+func (cloud *Cloud) AddServer(server Server) {
+	cloud.mutex.Lock()
+	defer cloud.mutex.Unlock()
+	if server.VMState == "" {
+		server.VMState = "active"
+	}
+	if server.PowerState == 0 {
+		server.PowerState = PowerStateRunning
+	}
+	server.Tags = append([]string{}, server.Tags...)
+	cloud.servers = append(cloud.servers, &server)
+}
+
+// GetServer returns a copy of server `id` current state, false if deleted
+func (cloud *Cloud) GetServer(id string) (Server, bool) {
+	cloud.mutex.Lock()
+	defer cloud.mutex.Unlock()
+	server := cloud.findServer(id)
+	if server == nil {
+		return Server{}, false
+	}
+	copied := *server
+	copied.Tags = append([]string{}, server.Tags...)
+	return copied, true
+}
+
+// nextID returns a new unique ID for created resources, e.g. "image-1"
+func (cloud *Cloud) nextID(prefix string) string {
+	cloud.lastID++
+	return fmt.Sprintf("%s-%d", prefix, cloud.lastID)
+}
+
+func (cloud *Cloud) Fail(f Failure) {
+	cloud.mutex.Lock()
+	defer cloud.mutex.Unlock()
+	cloud.failures = append(cloud.failures, &failure{Failure: f})
+}
+
+// Requests returns how many requests matched method (empty: any) and the
+// path.Match pattern
+func (cloud *Cloud) Requests(method, pattern string) int {
+	cloud.mutex.Lock()
+	defer cloud.mutex.Unlock()
+	count := 0
+	for _, request := range cloud.requests {
+		if matches(method, pattern, request) {
+			count++
+		}
+	}
+	return count
+}
+
+func matches(method, pattern string, request Request) bool {
+	if method != "" && method != request.Method {
+		return false
+	}
+	ok, _ := path.Match(pattern, request.Path)
+	return ok
+}
+
+func (cloud *Cloud) findServer(id string) *Server {
+	for _, server := range cloud.servers {
+		if server.ID == id {
+			return server
+		}
+	}
+	return nil
+}
+
+// failure returns the Failure to reply request with, if any
+func (cloud *Cloud) failure(request Request) *Failure {
+	for _, failure := range cloud.failures {
+		if failure.Times > 0 && failure.replied >= failure.Times {
+			continue
+		}
+		if matches(failure.Method, failure.Path, request) {
+			failure.replied++
+			return &failure.Failure
+		}
+	}
+	return nil
+}
+
+func reply(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if body != nil {
+		_ = json.NewEncoder(w).Encode(body)
+	}
+}
+
+func replyError(w http.ResponseWriter, status int, message string) {
+	reply(w, status, map[string]interface{}{
+		"error": map[string]interface{}{"code": status, "message": message},
+	})
+}
+
+func (cloud *Cloud) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cloud.mutex.Lock()
+	defer cloud.mutex.Unlock()
+
+	request := Request{Method: r.Method, Path: r.URL.Path}
+	cloud.requests = append(cloud.requests, request)
+	if failure := cloud.failure(request); failure != nil {
+		if failure.Body == "" {
+			replyError(w, failure.Status, "injected failure")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(failure.Status)
+		fmt.Fprint(w, failure.Body)
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, IdentityPath):
+		cloud.serveIdentity(w, r, strings.TrimPrefix(r.URL.Path, IdentityPath))
+	case strings.HasPrefix(r.URL.Path, ComputePath):
+		cloud.serveCompute(w, r, strings.TrimPrefix(r.URL.Path, ComputePath))
+	case strings.HasPrefix(r.URL.Path, BlockStoragePath):
+		cloud.serveBlockStorage(w, r, strings.TrimPrefix(r.URL.Path, BlockStoragePath))
+	case strings.HasPrefix(r.URL.Path, NetworkPath):
+		cloud.serveNetwork(w, r, strings.TrimPrefix(r.URL.Path, NetworkPath))
+	case strings.HasPrefix(r.URL.Path, ImagePath):
+		cloud.serveImage(w, r, strings.TrimPrefix(r.URL.Path, ImagePath))
+	default:
+		replyError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (cloud *Cloud) serveIdentity(w http.ResponseWriter, r *http.Request, resource string) {
+	switch {
+	case r.Method == http.MethodPost && resource == "auth/tokens":
+		cloud.serveToken(w, r)
+	case r.Method == http.MethodGet && resource == "projects":
+		projects := make([]map[string]interface{}, 0, len(cloud.projects))
+		for _, project := range cloud.projects {
+			projects = append(projects, map[string]interface{}{"id": project.ID, "name": project.Name})
+		}
+		reply(w, http.StatusOK, map[string]interface{}{
+			"projects": projects,
+			"links":    map[string]interface{}{"next": nil},
+		})
+	case r.Method == http.MethodGet && resource == "role_assignments":
+		cloud.serveRoleAssignments(w, r)
+	case r.Method == http.MethodGet && strings.HasPrefix(resource, "users/"):
+		id := strings.TrimPrefix(resource, "users/")
+		for _, user := range cloud.users {
+			if user.ID == id {
+				reply(w, http.StatusOK, map[string]interface{}{"user": map[string]interface{}{
+					"id": user.ID, "name": user.Name, "email": user.Email, "enabled": true,
+				}})
+				return
+			}
+		}
+		replyError(w, http.StatusNotFound, "Could not find user: "+id)
+	default:
+		replyError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// serveRoleAssignments lists the role assignments of ?scope.project.id (all
+// if not set), with role and user names as for ?include_names
+func (cloud *Cloud) serveRoleAssignments(w http.ResponseWriter, r *http.Request) {
+	projectID := r.URL.Query().Get("scope.project.id")
+	assignments := make([]map[string]interface{}, 0, len(cloud.assignments))
+	for _, assignment := range cloud.assignments {
+		if projectID != "" && assignment.ProjectID != projectID {
+			continue
+		}
+		userName := ""
+		for _, user := range cloud.users {
+			if user.ID == assignment.UserID {
+				userName = user.Name
+			}
+		}
+		assignments = append(assignments, map[string]interface{}{
+			"role":  map[string]interface{}{"id": assignment.Role, "name": assignment.Role},
+			"user":  map[string]interface{}{"id": assignment.UserID, "name": userName},
+			"scope": map[string]interface{}{"project": map[string]interface{}{"id": assignment.ProjectID}},
+		})
+	}
+	reply(w, http.StatusOK, map[string]interface{}{
+		"role_assignments": assignments,
+		"links":            map[string]interface{}{"next": nil},
+	})
+}
+
+func (cloud *Cloud) serveToken(w http.ResponseWriter, r *http.Request) {
+	var auth struct {
+		Auth struct {
+			Identity struct {
+				Password struct {
+					User struct {
+						Name     string `json:"name"`
+						Password string `json:"password"`
+					} `json:"user"`
+				} `json:"password"`
+			} `json:"identity"`
+		} `json:"auth"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&auth)
+	user := auth.Auth.Identity.Password.User
+	if user.Name != cloud.Username || user.Password != cloud.Password {
+		replyError(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+
+	urls := map[string]string{
+		"identity": cloud.URL + IdentityPath,
+		"compute":  cloud.URL + ComputePath,
+		"volumev3": cloud.URL + BlockStoragePath,
+		// Neutron endpoints are unversioned, clients add "v2.0/"
+		"network": cloud.URL + strings.TrimSuffix(NetworkPath, "v2.0/"),
+		"image":   cloud.URL + strings.TrimSuffix(ImagePath, "v2/"),
+	}
+	catalog := make([]map[string]interface{}, 0, len(cloud.Catalog))
+	for _, kind := range cloud.Catalog {
+		catalog = append(catalog, map[string]interface{}{"type": kind, "endpoints": []map[string]interface{}{
+			{"interface": "public", "region": cloud.Region, "region_id": cloud.Region, "url": urls[kind]},
+		}})
+	}
+	w.Header().Set("X-Subject-Token", "fake-token")
+	reply(w, http.StatusCreated, map[string]interface{}{"token": map[string]interface{}{
+		"catalog":    catalog,
+		"expires_at": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+	}})
+}
+
+// version returns a "major.minor" microversion as a comparable number
+func version(microversion string) int {
+	major, minor, _ := strings.Cut(microversion, ".")
+	majorNum, _ := strconv.Atoi(major)
+	minorNum, _ := strconv.Atoi(minor)
+	return majorNum*1000 + minorNum
+}
+
+// supportsTags tells if r asked for a Nova microversion with server tags
+func supportsTags(r *http.Request) bool {
+	requested := r.Header.Get("X-OpenStack-Nova-API-Version")
+	return requested != "" && version(requested) >= version(TagsMicroversion)
+}
+
+func (cloud *Cloud) serveCompute(w http.ResponseWriter, r *http.Request, resource string) {
+	parts := strings.Split(resource, "/")
+	if parts[0] != "servers" || len(parts) < 2 {
+		replyError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if parts[1] == "detail" && r.Method == http.MethodGet {
+		cloud.serveServers(w, r)
+		return
+	}
+
+	server := cloud.findServer(parts[1])
+	if server == nil {
+		replyError(w, http.StatusNotFound, fmt.Sprintf("Instance %s could not be found.", parts[1]))
+		return
+	}
+	switch {
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		reply(w, http.StatusOK, map[string]interface{}{"server": cloud.serverBody(server, supportsTags(r))})
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		for i := range cloud.servers {
+			if cloud.servers[i] == server {
+				cloud.servers = append(cloud.servers[:i], cloud.servers[i+1:]...)
+				break
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 3 && parts[2] == "action" && r.Method == http.MethodPost:
+		cloud.serveAction(w, r, server)
+	case len(parts) >= 3 && parts[2] == "tags":
+		if !supportsTags(r) {
+			replyError(w, http.StatusNotFound, "tags need microversion "+TagsMicroversion)
+			return
+		}
+		cloud.serveTags(w, r, server, parts[3:])
+	default:
+		replyError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (cloud *Cloud) serverBody(server *Server, withTags bool) map[string]interface{} {
+	attached := make([]map[string]interface{}, 0)
+	for _, volume := range cloud.volumes {
+		for _, serverID := range volume.AttachedTo {
+			if serverID == server.ID {
+				attached = append(attached, map[string]interface{}{"id": volume.ID})
+			}
+		}
+	}
+	body := map[string]interface{}{
+		"os-extended-volumes:volumes_attached": attached,
+		"id":                                   server.ID,
+		"name":                                 server.Name,
+		"tenant_id":                            server.ProjectID,
+		"created":                              server.Created.UTC().Format(time.RFC3339),
+		"updated":                              server.Updated.UTC().Format(time.RFC3339),
+		"status":                               status(server),
+		"OS-EXT-STS:vm_state":                  server.VMState,
+		"OS-EXT-STS:task_state":                server.TaskState,
+		"OS-EXT-STS:power_state":               server.PowerState,
+	}
+	if withTags {
+		body["tags"] = server.Tags
+	}
+	return body
+}
+
+// status returns the Nova server status for its vm_state
+func status(server *Server) string {
+	switch server.VMState {
+	case "stopped":
+		return "SHUTOFF"
+	case "building":
+		return "BUILD"
+	}
+	return strings.ToUpper(server.VMState)
+}
+
+// hasAll tells if server has all tags (comma separated)
+func hasAll(server *Server, tags string) bool {
+	for _, tag := range strings.Split(tags, ",") {
+		found := false
+		for _, t := range server.Tags {
+			found = found || t == tag
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// listServers returns the servers matching query filters: project_id (or
+// tenant_id), status, tags and not-tags, sorted by ?sort_key=created_at if
+// asked so, else in the order added
+func (cloud *Cloud) listServers(query url.Values) []*Server {
+	projectID := query.Get("project_id")
+	if projectID == "" {
+		projectID = query.Get("tenant_id")
+	}
+	servers := make([]*Server, 0, len(cloud.servers))
+	for _, server := range cloud.servers {
+		switch {
+		case projectID != "" && server.ProjectID != projectID:
+		case query.Get("status") != "" && !strings.EqualFold(query.Get("status"), status(server)):
+		case query.Get("tags") != "" && !hasAll(server, query.Get("tags")):
+		case query.Get("not-tags") != "" && hasAll(server, query.Get("not-tags")):
+		default:
+			servers = append(servers, server)
+		}
+	}
+	if query.Get("sort_key") == "created_at" {
+		desc := query.Get("sort_dir") != "asc"
+		sort.SliceStable(servers, func(i, j int) bool {
+			if desc {
+				return servers[i].Created.After(servers[j].Created)
+			}
+			return servers[i].Created.Before(servers[j].Created)
+		})
+	}
+	return servers
+}
+
+// serveServers lists servers after ?marker, up to ?limit or PageSize, with a
+// next link if the page is full as Nova does
+func (cloud *Cloud) serveServers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	list := cloud.listServers(query)
+	start := 0
+	if marker := query.Get("marker"); marker != "" {
+		start = -1
+		for i, server := range list {
+			if server.ID == marker {
+				start = i + 1
+			}
+		}
+		if start < 0 {
+			replyError(w, http.StatusBadRequest, "marker "+marker+" not found")
+			return
+		}
+	}
+	limit := cloud.PageSize
+	if queryLimit, err := strconv.Atoi(query.Get("limit")); err == nil && (limit == 0 || queryLimit < limit) {
+		limit = queryLimit
+	}
+	page := list[start:]
+	if limit > 0 && len(page) > limit {
+		page = page[:limit]
+	}
+
+	servers := make([]map[string]interface{}, 0, len(page))
+	for _, server := range page {
+		servers = append(servers, cloud.serverBody(server, supportsTags(r) && !cloud.OmitListTags))
+	}
+	body := map[string]interface{}{"servers": servers}
+	if limit > 0 && len(page) == limit {
+		next := *r.URL
+		nextQuery := next.Query()
+		nextQuery.Set("marker", page[len(page)-1].ID)
+		next.RawQuery = nextQuery.Encode()
+		body["servers_links"] = []map[string]interface{}{
+			{"rel": "next", "href": cloud.URL + next.RequestURI()},
+		}
+	}
+	reply(w, http.StatusOK, body)
+}
+
+func (cloud *Cloud) serveAction(w http.ResponseWriter, r *http.Request, server *Server) {
+	var action map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&action); err != nil || len(action) != 1 {
+		replyError(w, http.StatusBadRequest, "malformed action")
+		return
+	}
+	switch {
+	case action["os-stop"] != nil:
+		server.VMState, server.PowerState = "stopped", PowerStateShutdown
+	case action["os-start"] != nil:
+		server.VMState, server.PowerState = "active", PowerStateRunning
+	case action["createImage"] != nil:
+		var create struct {
+			Name     string            `json:"name"`
+			Metadata map[string]string `json:"metadata"`
+		}
+		_ = json.Unmarshal(action["createImage"], &create)
+		image := cloud.createImage(server, create.Name, create.Metadata)
+		// As before microversion 2.45, the image ID only in Location
+		w.Header().Set("Location", cloud.URL+ImagePath+"images/"+image.ID)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	case action["os-resetState"] != nil:
+		var reset struct {
+			State string `json:"state"`
+		}
+		_ = json.Unmarshal(action["os-resetState"], &reset)
+		server.VMState = reset.State
+	default:
+		replyError(w, http.StatusBadRequest, "unsupported action")
+		return
+	}
+	server.Updated = time.Now()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cloud *Cloud) serveTags(w http.ResponseWriter, r *http.Request, server *Server, tag []string) {
+	has := func(tag string) int {
+		for i, t := range server.Tags {
+			if t == tag {
+				return i
+			}
+		}
+		return -1
+	}
+	switch {
+	case len(tag) == 0 && r.Method == http.MethodGet:
+		reply(w, http.StatusOK, map[string]interface{}{"tags": server.Tags})
+	case len(tag) == 1 && r.Method == http.MethodPut:
+		if has(tag[0]) >= 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		server.Tags = append(server.Tags, tag[0])
+		w.WriteHeader(http.StatusCreated)
+	case len(tag) == 1 && r.Method == http.MethodDelete:
+		i := has(tag[0])
+		if i < 0 {
+			replyError(w, http.StatusNotFound, "tag "+tag[0]+" not found")
+			return
+		}
+		server.Tags = append(server.Tags[:i], server.Tags[i+1:]...)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		replyError(w, http.StatusNotFound, "not found")
+	}
+}
@@ -0,0 +1,54 @@
+package fakeopenstack
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloud(t *testing.T) {
+	cloud := New()
+	defer cloud.Close()
+	cloud.PageSize = 1
+	cloud.AddServer(Server{ID: "s1", Name: "one", Tags: []string{"a"}})
+	cloud.AddServer(Server{ID: "s2", Name: "two"})
+	cloud.Fail(Failure{Method: http.MethodGet, Path: ComputePath + "servers/detail", Status: http.StatusServiceUnavailable, Times: 1})
+
+	get := func(url, microversion string) (int, map[string]json.RawMessage) {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		require.NoError(t, err)
+		if microversion != "" {
+			req.Header.Set("X-OpenStack-Nova-API-Version", microversion)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body := make(map[string]json.RawMessage)
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	status, _ := get(cloud.URL+ComputePath+"servers/detail", "")
+	require.Equal(t, http.StatusServiceUnavailable, status)
+	status, body := get(cloud.URL+ComputePath+"servers/detail", "")
+	require.Equal(t, http.StatusOK, status)
+	require.JSONEq(t, `[{"rel": "next", "href": "`+cloud.URL+ComputePath+`servers/detail?marker=s1"}]`,
+		string(body["servers_links"]))
+	require.NotContains(t, string(body["servers"]), `"tags"`)
+
+	status, body = get(cloud.URL+ComputePath+"servers/detail?marker=s1", TagsMicroversion)
+	require.Equal(t, http.StatusOK, status)
+	require.Contains(t, string(body["servers"]), `"id":"s2"`)
+	require.Contains(t, string(body["servers"]), `"tags":[]`)
+
+	status, _ = get(cloud.URL+ComputePath+"servers/s1/tags", "2.1")
+	require.Equal(t, http.StatusNotFound, status)
+	status, body = get(cloud.URL+ComputePath+"servers/s1/tags", TagsMicroversion)
+	require.Equal(t, http.StatusOK, status)
+	require.JSONEq(t, `["a"]`, string(body["tags"]))
+
+	require.Equal(t, 3, cloud.Requests(http.MethodGet, ComputePath+"servers/detail"))
+	require.Equal(t, 2, cloud.Requests("", ComputePath+"servers/*/tags"))
+}
@@ -0,0 +1,149 @@
+package fakeopenstack
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type Image struct {
+	ID         string
+	Name       string
+	ProjectID  string // owner
+	Created    time.Time
+	Updated    time.Time
+	Status     string // default "active"
+	Protected  bool
+	Tags       []string
+	Properties map[string]string
+	// pending statuses, the next one shown on each GET
+	pending []string
+}
+
+// AddImage seeds image, listed in the order added
+func (cloud *Cloud) AddImage(image Image) {
+	cloud.mutex.Lock()
+	defer cloud.mutex.Unlock()
+	if image.Status == "" {
+		image.Status = "active"
+	}
+	image.Tags = append([]string{}, image.Tags...)
+	image.Properties = copyMetadata(image.Properties)
+	cloud.images = append(cloud.images, &image)
+}
+
+// Images returns a copy of the current images, e.g. to find the ones created
+// from servers
+func (cloud *Cloud) Images() []Image {
+	cloud.mutex.Lock()
+	defer cloud.mutex.Unlock()
+	images := make([]Image, 0, len(cloud.images))
+	for _, image := range cloud.images {
+		copied := *image
+		copied.Tags = append([]string{}, image.Tags...)
+		copied.Properties = copyMetadata(image.Properties)
+		copied.pending = nil
+		images = append(images, copied)
+	}
+	return images
+}
+
+// createImage adds the snapshot image of server, going through
+// CreatedImageStatuses
+func (cloud *Cloud) createImage(server *Server, name string, metadata map[string]string) *Image {
+	now := time.Now()
+	image := &Image{
+		ID:         cloud.nextID("image"),
+		Name:       name,
+		ProjectID:  server.ProjectID,
+		Created:    now,
+		Updated:    now,
+		Status:     "queued",
+		Tags:       []string{},
+		Properties: copyMetadata(metadata),
+		pending:    append([]string{}, cloud.CreatedImageStatuses...),
+	}
+	image.Properties["image_type"] = "snapshot"
+	image.Properties["instance_uuid"] = server.ID
+	cloud.images = append(cloud.images, image)
+	return image
+}
+
+func imageBody(image *Image) map[string]interface{} {
+	body := map[string]interface{}{
+		"id":         image.ID,
+		"name":       image.Name,
+		"owner":      image.ProjectID,
+		"created_at": image.Created.UTC().Format(time.RFC3339),
+		"updated_at": image.Updated.UTC().Format(time.RFC3339),
+		"status":     image.Status,
+		"visibility": "private",
+		"protected":  image.Protected,
+		"tags":       image.Tags,
+	}
+	// Glance properties are top level
+	for key, value := range image.Properties {
+		body[key] = value
+	}
+	return body
+}
+
+func (cloud *Cloud) serveImage(w http.ResponseWriter, r *http.Request, resource string) {
+	parts := strings.Split(resource, "/")
+	if parts[0] != "images" {
+		replyError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if len(parts) == 1 && r.Method == http.MethodGet {
+		images := make([]map[string]interface{}, 0, len(cloud.images))
+		for _, image := range cloud.images {
+			images = append(images, imageBody(image))
+		}
+		reply(w, http.StatusOK, map[string]interface{}{"images": images})
+		return
+	}
+
+	index := -1
+	for i, image := range cloud.images {
+		if image.ID == parts[1] {
+			index = i
+		}
+	}
+	if index < 0 {
+		replyError(w, http.StatusNotFound, fmt.Sprintf("No image found with ID %s", parts[1]))
+		return
+	}
+	image := cloud.images[index]
+	switch {
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		if len(image.pending) > 0 {
+			image.Status, image.pending = image.pending[0], image.pending[1:]
+		}
+		reply(w, http.StatusOK, imageBody(image))
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		if image.Protected {
+			replyError(w, http.StatusForbidden, "Image "+image.ID+" is protected and cannot be deleted.")
+			return
+		}
+		cloud.images = append(cloud.images[:index], cloud.images[index+1:]...)
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 4 && parts[2] == "tags" && r.Method == http.MethodPut:
+		if indexOf(image.Tags, parts[3]) < 0 {
+			image.Tags = append(image.Tags, parts[3])
+		}
+		image.Updated = time.Now()
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 4 && parts[2] == "tags" && r.Method == http.MethodDelete:
+		i := indexOf(image.Tags, parts[3])
+		if i < 0 {
+			replyError(w, http.StatusNotFound, "Tag "+parts[3]+" not found")
+			return
+		}
+		image.Tags = append(image.Tags[:i], image.Tags[i+1:]...)
+		image.Updated = time.Now()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		replyError(w, http.StatusNotFound, "not found")
+	}
+}
@@ -0,0 +1,122 @@
+package fakeopenstack
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type FloatingIP struct {
+	ID        string
+	Address   string
+	ProjectID string
+	Created   time.Time
+	Updated   time.Time
+	PortID    string // associated if set
+	FixedIP   string
+	Tags      []string
+}
+
+// AddFloatingIP seeds fip, listed in the order added
+func (cloud *Cloud) AddFloatingIP(fip FloatingIP) {
+	cloud.mutex.Lock()
+	defer cloud.mutex.Unlock()
+	fip.Tags = append([]string{}, fip.Tags...)
+	cloud.floatingIPs = append(cloud.floatingIPs, &fip)
+}
+
+// GetFloatingIP returns a copy of floating IP `id` current state, false if
+// deleted
+func (cloud *Cloud) GetFloatingIP(id string) (FloatingIP, bool) {
+	cloud.mutex.Lock()
+	defer cloud.mutex.Unlock()
+	for _, fip := range cloud.floatingIPs {
+		if fip.ID == id {
+			copied := *fip
+			copied.Tags = append([]string{}, fip.Tags...)
+			return copied, true
+		}
+	}
+	return FloatingIP{}, false
+}
+
+func floatingIPBody(fip *FloatingIP) map[string]interface{} {
+	body := map[string]interface{}{
+		"id":                  fip.ID,
+		"floating_ip_address": fip.Address,
+		"project_id":          fip.ProjectID,
+		"tenant_id":           fip.ProjectID,
+		"created_at":          fip.Created.UTC().Format(time.RFC3339),
+		"updated_at":          fip.Updated.UTC().Format(time.RFC3339),
+		"port_id":             nil,
+		"fixed_ip_address":    nil,
+		"status":              "DOWN",
+		"tags":                fip.Tags,
+	}
+	if fip.PortID != "" {
+		body["port_id"], body["fixed_ip_address"], body["status"] = fip.PortID, fip.FixedIP, "ACTIVE"
+	}
+	return body
+}
+
+func (cloud *Cloud) serveNetwork(w http.ResponseWriter, r *http.Request, resource string) {
+	parts := strings.Split(resource, "/")
+	if parts[0] != "floatingips" {
+		replyError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if len(parts) == 1 && r.Method == http.MethodGet {
+		fips := make([]map[string]interface{}, 0, len(cloud.floatingIPs))
+		for _, fip := range cloud.floatingIPs {
+			fips = append(fips, floatingIPBody(fip))
+		}
+		reply(w, http.StatusOK, map[string]interface{}{"floatingips": fips})
+		return
+	}
+
+	index := -1
+	for i, fip := range cloud.floatingIPs {
+		if fip.ID == parts[1] {
+			index = i
+		}
+	}
+	if index < 0 {
+		replyError(w, http.StatusNotFound, fmt.Sprintf("Floating IP %s could not be found", parts[1]))
+		return
+	}
+	fip := cloud.floatingIPs[index]
+	switch {
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		reply(w, http.StatusOK, map[string]interface{}{"floatingip": floatingIPBody(fip)})
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		cloud.floatingIPs = append(cloud.floatingIPs[:index], cloud.floatingIPs[index+1:]...)
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 4 && parts[2] == "tags" && r.Method == http.MethodPut:
+		if indexOf(fip.Tags, parts[3]) < 0 {
+			fip.Tags = append(fip.Tags, parts[3])
+		}
+		fip.Updated = time.Now()
+		w.WriteHeader(http.StatusCreated)
+	case len(parts) == 4 && parts[2] == "tags" && r.Method == http.MethodDelete:
+		i := indexOf(fip.Tags, parts[3])
+		if i < 0 {
+			replyError(w, http.StatusNotFound, "Tag "+parts[3]+" could not be found")
+			return
+		}
+		fip.Tags = append(fip.Tags[:i], fip.Tags[i+1:]...)
+		fip.Updated = time.Now()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		replyError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func indexOf(tags []string, tag string) int {
+	for i, t := range tags {
+		if t == tag {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,119 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	ResultOK    = "ok"
+	ResultError = "error"
+)
+
+// Entry is a single JSON line in a run journal, recording the state of a
+// resource right before the action was applied to it
+type Entry struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Action     string    `json:"action"`
+	Kind       string    `json:"kind"`
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Project    string    `json:"project"`
+	Cloud      string    `json:"cloud,omitempty"`
+	Region     string    `json:"region,omitempty"`
+	PowerState string    `json:"power_state,omitempty"`
+	Tags       []string  `json:"tags"`
+	Tag        string    `json:"tag,omitempty"`
+	Result     string    `json:"result"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Journal appends Entries to `<dir>/<run-id>.jsonl`, safe for concurrent use
+type Journal struct {
+	mutex sync.Mutex
+	file  *os.File
+	RunID string
+}
+
+// NewRunID is sortable and tells which action was run, e.g.
+// "20261017T120000.123456Z-stop", to the microsecond so that runs started
+// within the same second (e.g. per kind from cron) don't collide
+func NewRunID(action string, t time.Time) string {
+	return fmt.Sprintf("%s-%s", t.UTC().Format("20060102T150405.000000Z"), action)
+}
+
+func Path(dir, runID string) string {
+	return filepath.Join(dir, runID+".jsonl")
+}
+
+func Create(dir, runID string) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("Failed to create journal dir: %w", err)
+	}
+	file, err := os.OpenFile(Path(dir, runID), os.O_APPEND|os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create journal: %w", err)
+	}
+
+	return &Journal{file: file, RunID: runID}, nil
+}
+
+// Add fills in Timestamp, and appends entry as a single line, so that a run
+// interrupted midway can still be undone
+func (journal *Journal) Add(entry Entry) error {
+	entry.Timestamp = time.Now().UTC()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	_, err = journal.file.Write(append(line, '\n'))
+
+	return err
+}
+
+func (journal *Journal) Close() error {
+	return journal.file.Close()
+}
+
+// Load returns all Entries from run journal `runID`
+func Load(dir, runID string) ([]Entry, error) {
+	file, err := os.Open(Path(dir, runID))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open journal: %w", err)
+	}
+	defer file.Close()
+
+	entries := make([]Entry, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("Invalid journal line %d: %w", lineNum, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// HasTag tells whether the resource had `tag` before the action
+func (entry *Entry) HasTag(tag string) bool {
+	for _, t := range entry.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package journal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 10, 17, 12, 0, 0, 123456789, time.UTC)
+	runID := NewRunID("stop", now)
+	require.Equal(t, "20261017T120000.123456Z-stop", runID)
+	require.Less(t, runID, NewRunID("stop", now.Add(time.Millisecond)))
+
+	journal, err := Create(dir, runID)
+	require.NoError(t, err)
+	require.NoError(t, journal.Add(Entry{Action: "stop", Kind: "server", ID: "1", PowerState: "RUNNING", Result: ResultOK}))
+	require.NoError(t, journal.Add(Entry{Action: "stop", Kind: "server", ID: "2", PowerState: "SHUTDOWN", Result: ResultOK}))
+	require.NoError(t, journal.Close())
+
+	// never overwrite an existing run
+	_, err = Create(dir, runID)
+	require.Error(t, err)
+
+	entries, err := Load(dir, runID)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "1", entries[0].ID)
+	require.Equal(t, "SHUTDOWN", entries[1].PowerState)
+	require.False(t, entries[0].Timestamp.IsZero())
+
+	_, err = Load(dir, "missing")
+	require.ErrorContains(t, err, "Failed to open journal")
+}
+
+func TestHasTag(t *testing.T) {
+	entry := Entry{Tags: []string{"foo", "os-cleanup"}}
+	require.True(t, entry.HasTag("os-cleanup"))
+	require.False(t, entry.HasTag("bar"))
+}
@@ -0,0 +1,163 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/jjo/openstack-ops/pkg/logger"
+)
+
+var log = logger.Log
+
+// Resource is what owners get told about each of their resources
+type Resource struct {
+	Kind    string
+	ID      string
+	Name    string
+	Project string
+	Created time.Time
+	Action  string
+	Date    time.Time
+}
+
+// Message is the data passed to the user-supplied template
+type Message struct {
+	To        string
+	From      string
+	Subject   string
+	Resources []Resource
+}
+
+type executor interface {
+	Execute(io.Writer, interface{}) error
+}
+
+type Notifier struct {
+	SMTPAddr string
+	From     string
+	Subject  string
+	auth     smtp.Auth
+	tmpl     executor
+	html     bool
+}
+
+// NewNotifier parses templatePath as a html/template if it has a .html or
+// .htm extension, else as a text/template
+func NewNotifier(templatePath, smtpAddr, from, subject string) (*Notifier, error) {
+	content, err := os.ReadFile(templatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	notifier := &Notifier{
+		SMTPAddr: smtpAddr,
+		From:     from,
+		Subject:  subject,
+	}
+	name := filepath.Base(templatePath)
+	switch strings.ToLower(filepath.Ext(templatePath)) {
+	case ".html", ".htm":
+		notifier.html = true
+		notifier.tmpl, err = htmltemplate.New(name).Parse(string(content))
+	default:
+		notifier.tmpl, err = texttemplate.New(name).Parse(string(content))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse template %s: %w", templatePath, err)
+	}
+
+	return notifier, nil
+}
+
+// WithAuth sets PLAIN auth against the SMTP relay, net/smtp refuses to send
+// it over non-TLS connections except to localhost
+func (notifier *Notifier) WithAuth(user, password string) *Notifier {
+	if user != "" {
+		host := notifier.SMTPAddr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		notifier.auth = smtp.PlainAuth("", user, password, host)
+	}
+
+	return notifier
+}
+
+// Recipients splits a comma separated list of emails, e.g. as resolved for
+// projects with several owners, each one to get their own message
+func Recipients(emails string) []string {
+	recipients := make([]string, 0)
+	for _, email := range strings.Split(emails, ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			recipients = append(recipients, email)
+		}
+	}
+	return recipients
+}
+
+func (notifier *Notifier) NewMessage(to string, resources []Resource) *Message {
+	return &Message{
+		To:        to,
+		From:      notifier.From,
+		Subject:   notifier.Subject,
+		Resources: resources,
+	}
+}
+
+// Render returns the full RFC 5322 message, headers included
+func (notifier *Notifier) Render(msg *Message) ([]byte, error) {
+	contentType := "text/plain"
+	if notifier.html {
+		contentType = "text/html"
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", msg.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: %s; charset=UTF-8\r\n", contentType)
+	fmt.Fprintf(&buf, "\r\n")
+	if err := notifier.tmpl.Execute(&buf, msg); err != nil {
+		return nil, fmt.Errorf("Failed to render message to %s: %w", msg.To, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (notifier *Notifier) Send(msg *Message) error {
+	body, err := notifier.Render(msg)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Sending %d bytes message to %s via %s", len(body), msg.To, notifier.SMTPAddr)
+	return smtp.SendMail(notifier.SMTPAddr, notifier.auth, msg.From, []string{msg.To}, body)
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9@._+-]`)
+
+// WriteTo renders msg into `dir/<To>.eml` instead of sending it
+func (notifier *Notifier) WriteTo(dir string, msg *Message) (string, error) {
+	body, err := notifier.Render(msg)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, unsafeFileChars.ReplaceAllString(msg.To, "_")+".eml")
+
+	return path, os.WriteFile(path, body, 0o644)
+}
@@ -0,0 +1,133 @@
+package notify
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testTemplate = `Hi {{.To}},
+{{range .Resources}}- {{.Kind}} {{.Name}} ({{.Project}}) created {{.Created.Format "2006-01-02"}}: {{.Action}} on {{.Date.Format "2006-01-02"}}
+{{end}}`
+
+// fakeSMTPServer accepts a single message and sends it over the returned channel
+func fakeSMTPServer(t *testing.T) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	msgs := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		reply := func(s string) { _, _ = conn.Write([]byte(s + "\r\n")) }
+		reply("220 fake ESMTP")
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					msgs <- data.String()
+					reply("250 OK")
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 fake")
+			case cmd == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), msgs
+}
+
+func newTestNotifier(t *testing.T, ext, smtpAddr string) *Notifier {
+	path := filepath.Join(t.TempDir(), "notify"+ext)
+	require.NoError(t, os.WriteFile(path, []byte(testTemplate), 0o600))
+
+	notifier, err := NewNotifier(path, smtpAddr, "cloud-admin@example.com", "Cleanup notice")
+	require.NoError(t, err)
+	return notifier
+}
+
+var testResources = []Resource{
+	{
+		Kind:    "server",
+		ID:      "1",
+		Name:    "vm<one>",
+		Project: "foo__bar.com_project",
+		Created: time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC),
+		Action:  "stop",
+		Date:    time.Date(2026, 10, 24, 0, 0, 0, 0, time.UTC),
+	},
+}
+
+func TestNotifier_Send(t *testing.T) {
+	addr, msgs := fakeSMTPServer(t)
+	notifier := newTestNotifier(t, ".txt", addr)
+
+	err := notifier.Send(notifier.NewMessage("foo@bar.com", testResources))
+	require.NoError(t, err)
+
+	msg := <-msgs
+	require.Contains(t, msg, "To: foo@bar.com\r\n")
+	require.Contains(t, msg, "Subject: Cleanup notice\r\n")
+	require.Contains(t, msg, "Content-Type: text/plain")
+	require.Contains(t, msg, "- server vm<one> (foo__bar.com_project) created 2026-07-01: stop on 2026-10-24")
+}
+
+func TestNotifier_WriteTo(t *testing.T) {
+	notifier := newTestNotifier(t, ".html", "")
+	dir := t.TempDir()
+
+	path, err := notifier.WriteTo(dir, notifier.NewMessage("foo@bar.com", testResources))
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "foo@bar.com.eml"), path)
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(content), "Content-Type: text/html")
+	// html/template escaping
+	require.Contains(t, string(content), "vm&lt;one&gt;")
+}
+
+func TestRecipients(t *testing.T) {
+	require.Equal(t, []string{"a@example.com", "b@example.com"}, Recipients("a@example.com, b@example.com,"))
+	require.Equal(t, []string{"a@example.com"}, Recipients("a@example.com"))
+	require.Empty(t, Recipients(""))
+}
+
+func TestNewNotifier_badTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.txt")
+	require.NoError(t, os.WriteFile(path, []byte("{{.To"), 0o600))
+
+	_, err := NewNotifier(path, "", "", "")
+	require.Error(t, err)
+}
@@ -0,0 +1,69 @@
+package openstack
+
+import (
+	"context"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// withContext returns a copy of osClient whose API requests are bound to
+// ctx, as gophercloud v1 only takes it from the ProviderClient. Resources
+// keep the original osClient, each call binding its own ctx
+func (osClient *OSClient) withContext(ctx context.Context) *OSClient {
+	bound := *osClient
+	if osClient.ProviderClient != nil {
+		bound.ProviderClient = providerWithContext(ctx, osClient.ProviderClient)
+	}
+	provider := bound.ProviderClient
+	bound.ComputeClient = serviceWithContext(ctx, provider, osClient.ComputeClient)
+	bound.IdentityClient = serviceWithContext(ctx, provider, osClient.IdentityClient)
+	bound.BlockStorageClient = serviceWithContext(ctx, provider, osClient.BlockStorageClient)
+	bound.NetworkClient = serviceWithContext(ctx, provider, osClient.NetworkClient)
+	bound.ImageClient = serviceWithContext(ctx, provider, osClient.ImageClient)
+	return &bound
+}
+
+// providerWithContext copies parent with ctx and its own token lock, a
+// reauthentication (e.g. token expired mid-run) is done by parent and its
+// new token copied back
+func providerWithContext(ctx context.Context, parent *gophercloud.ProviderClient) *gophercloud.ProviderClient {
+	provider := &gophercloud.ProviderClient{
+		IdentityBase:      parent.IdentityBase,
+		IdentityEndpoint:  parent.IdentityEndpoint,
+		EndpointLocator:   parent.EndpointLocator,
+		HTTPClient:        parent.HTTPClient,
+		UserAgent:         parent.UserAgent,
+		Context:           ctx,
+		RetryBackoffFunc:  parent.RetryBackoffFunc,
+		MaxBackoffRetries: parent.MaxBackoffRetries,
+		RetryFunc:         parent.RetryFunc,
+	}
+	provider.UseTokenLock()
+	provider.CopyTokenFrom(parent)
+	if parent.ReauthFunc != nil {
+		provider.ReauthFunc = func() error {
+			if err := parent.Reauthenticate(provider.Token()); err != nil {
+				return err
+			}
+			provider.CopyTokenFrom(parent)
+			return nil
+		}
+	}
+	return provider
+}
+
+// serviceWithContext copies client to use provider, or a copy of its own
+// bound to ctx if nil
+func serviceWithContext(
+	ctx context.Context, provider *gophercloud.ProviderClient, client *gophercloud.ServiceClient,
+) *gophercloud.ServiceClient {
+	if client == nil {
+		return nil
+	}
+	bound := *client
+	if provider == nil {
+		provider = providerWithContext(ctx, client.ProviderClient)
+	}
+	bound.ProviderClient = provider
+	return &bound
+}
@@ -0,0 +1,54 @@
+package openstack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Error kinds returned by this package, to be checked with errors.Is(), the
+// underlying gophercloud error remains available with errors.As()
+var (
+	// ErrAuth: missing or invalid credentials, or Keystone unreachable
+	ErrAuth = errors.New("authentication failed")
+	// ErrEndpoint: service not found in the catalog for the region/interface
+	ErrEndpoint = errors.New("endpoint not found")
+	// ErrList: the API failed to list resources
+	ErrList = errors.New("list failed")
+	// ErrExtract: the API replied with something we can't parse
+	ErrExtract = errors.New("extraction failed")
+)
+
+// Error wraps a failed OpenStack operation, e.g. `list servers`
+type Error struct {
+	Kind error
+	Op   string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Op, e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+func newError(kind error, op string, err error) error {
+	return &Error{Kind: kind, Op: op, Err: err}
+}
+
+// listError wraps err from listing `what`, as ErrExtract if the response
+// couldn't be parsed (gophercloud pagers extract pages while fetching them)
+func listError(what string, err error) error {
+	var already *Error
+	if errors.As(err, &already) {
+		return err
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return newError(ErrExtract, "list "+what, err)
+	}
+	return newError(ErrList, "list "+what, err)
+}
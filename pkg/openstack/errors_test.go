@@ -0,0 +1,107 @@
+package openstack
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jjo/openstack-ops/pkg/fakeopenstack"
+)
+
+// newFakeCloud starts a fakeopenstack.Cloud with a project and a tagged
+// server, as the "fake" cloud of OS_CLIENT_CONFIG_FILE
+func newFakeCloud(t *testing.T) *fakeopenstack.Cloud {
+	cloud := fakeopenstack.New()
+	t.Cleanup(cloud.Close)
+	cloud.AddProject(fakeopenstack.Project{ID: "p1", Name: "foo__bar.com_project"})
+	cloud.AddServer(fakeopenstack.Server{
+		ID: "s1", Name: "one", ProjectID: "p1", Created: time.Now().AddDate(0, 0, -90), Tags: []string{"tag1"},
+	})
+
+	cloudsYAML := filepath.Join(t.TempDir(), "clouds.yaml")
+	require.NoError(t, cloud.WriteCloudsYAML(cloudsYAML, "fake"))
+	t.Setenv("OS_CLIENT_CONFIG_FILE", cloudsYAML)
+	t.Setenv("OS_CLOUD", "")
+	return cloud
+}
+
+func TestErrors(t *testing.T) {
+	all := func(OSResourceInterface) bool { return true }
+	servers := fakeopenstack.ComputePath + "servers/detail"
+	tests := []struct {
+		name    string
+		setup   func(*fakeopenstack.Cloud)
+		config  CloudConfig
+		list    func(*OSClient) ([]OSResourceInterface, error)
+		wantErr error
+	}{
+		{"ok", nil, CloudConfig{Cloud: "fake"}, nil, nil},
+		{"missing cloud", nil, CloudConfig{Cloud: "missing"}, nil, ErrAuth},
+		{"bad password", func(cloud *fakeopenstack.Cloud) { cloud.Password = "other" }, CloudConfig{Cloud: "fake"}, nil, ErrAuth},
+		{
+			"missing compute endpoint", func(cloud *fakeopenstack.Cloud) { cloud.Catalog = []string{"identity"} },
+			CloudConfig{Cloud: "fake"}, nil, ErrEndpoint,
+		},
+		{"missing region", nil, CloudConfig{Cloud: "fake", Region: "r2"}, nil, ErrEndpoint},
+		{
+			"missing volume endpoint", func(cloud *fakeopenstack.Cloud) { cloud.Catalog = []string{"identity", "compute"} },
+			CloudConfig{Cloud: "fake"},
+			func(osClient *OSClient) ([]OSResourceInterface, error) {
+				return osClient.GetVolumes(context.Background(), all, false)
+			}, ErrEndpoint,
+		},
+		{
+			"projects failure", func(cloud *fakeopenstack.Cloud) {
+				cloud.Fail(fakeopenstack.Failure{Path: fakeopenstack.IdentityPath + "projects", Status: http.StatusForbidden})
+			}, CloudConfig{Cloud: "fake"}, nil, ErrList,
+		},
+		{
+			"servers failure", func(cloud *fakeopenstack.Cloud) {
+				cloud.Fail(fakeopenstack.Failure{Path: servers, Status: http.StatusInternalServerError})
+			}, CloudConfig{Cloud: "fake"}, nil, ErrList,
+		},
+		{
+			"servers garbage", func(cloud *fakeopenstack.Cloud) {
+				cloud.Fail(fakeopenstack.Failure{Path: servers, Status: http.StatusOK, Body: `{"servers": "garbage"}`})
+			}, CloudConfig{Cloud: "fake"}, nil, ErrExtract,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cloud := newFakeCloud(t)
+			if tt.setup != nil {
+				tt.setup(cloud)
+			}
+			list := tt.list
+			if list == nil {
+				list = func(osClient *OSClient) ([]OSResourceInterface, error) {
+					return osClient.GetInstances(context.Background(), all)
+				}
+			}
+
+			osClient, err := NewOSClient(tt.config)
+			if err == nil {
+				var resources []OSResourceInterface
+				resources, err = list(osClient)
+				if tt.wantErr == nil {
+					require.NoError(t, err)
+					require.Len(t, resources, 1)
+					instance := resources[0].(*Instance)
+					require.Equal(t, "foo__bar.com_project", instance.ProjectName)
+					require.Equal(t, "RegionOne", instance.Region)
+					require.Equal(t, []string{"tag1"}, instance.Tags)
+					return
+				}
+				require.Nil(t, resources)
+			}
+			require.ErrorIs(t, err, tt.wantErr)
+			var osErr *Error
+			require.ErrorAs(t, err, &osErr)
+		})
+	}
+}
@@ -1,10 +1,15 @@
 package openstack
 
 import (
+	"fmt"
 	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"golang.org/x/exp/slices"
+
+	"github.com/jjo/openstack-ops/pkg/where"
 )
 
 type Filter interface {
@@ -18,8 +23,11 @@ type OSResourceFilter struct {
 	createdBefore time.Time
 	incRe         *regexp.Regexp
 	excRe         *regexp.Regexp
+	incFields     []string
+	excFields     []string
 	tag           string
 	tagMatch      bool
+	where         *where.Expr
 }
 
 func (filter *OSResourceFilter) WithCreatedBefore(t time.Time) *OSResourceFilter {
@@ -57,6 +65,26 @@ func (filter *OSResourceFilter) WithTag(tag string) *OSResourceFilter {
 	return filter
 }
 
+// WithIncFields limits the include regex to the values of these
+// GetSearchFields(), instead of the whole StringAll()
+func (filter *OSResourceFilter) WithIncFields(fields []string) *OSResourceFilter {
+	filter.incFields = fields
+	return filter
+}
+
+// WithExcFields limits the exclude regex to the values of these
+// GetSearchFields(), instead of the whole StringAll()
+func (filter *OSResourceFilter) WithExcFields(fields []string) *OSResourceFilter {
+	filter.excFields = fields
+	return filter
+}
+
+// WithWhere only passes resources whose GetFields() match expr
+func (filter *OSResourceFilter) WithWhere(expr *where.Expr) *OSResourceFilter {
+	filter.where = expr
+	return filter
+}
+
 func NewOSResourceFilter(t time.Time, incStr, excStr, tag string, tagMatch bool) *OSResourceFilter {
 	filter := (&OSResourceFilter{}).
 		WithCreatedBefore(t).
@@ -68,12 +96,143 @@ func NewOSResourceFilter(t time.Time, incStr, excStr, tag string, tagMatch bool)
 	return filter
 }
 
+// String describes the filter, e.g. for audit logs
+func (filter *OSResourceFilter) String() string {
+	str := fmt.Sprintf("created<%s", filter.createdBefore.UTC().Format(time.RFC3339))
+	if filter.incRe != nil {
+		str += fmt.Sprintf(" include=%q", filter.incRe.String())
+		if len(filter.incFields) > 0 {
+			str += fmt.Sprintf(" include_fields=%s", strings.Join(filter.incFields, ","))
+		}
+	}
+	if filter.excRe != nil {
+		str += fmt.Sprintf(" exclude=%q", filter.excRe.String())
+		if len(filter.excFields) > 0 {
+			str += fmt.Sprintf(" exclude_fields=%s", strings.Join(filter.excFields, ","))
+		}
+	}
+	if filter.tagMatch {
+		str += fmt.Sprintf(" tag=%q", filter.tag)
+	}
+	if filter.where != nil {
+		str += fmt.Sprintf(" where=%q", filter.where.String())
+	}
+	return str
+}
+
 func (filter *OSResourceFilter) Run(r OSResourceInterface) bool {
-	strAll := r.StringAll()
 	ret := r.CreatedBefore(filter.createdBefore) &&
-		(filter.incRe == nil || filter.incRe.MatchString(strAll)) &&
-		(filter.excRe == nil || !filter.excRe.MatchString(strAll)) &&
-		(!filter.tagMatch || slices.Contains(r.GetTags(), filter.tag))
-	log.Debugf("filter.Run(): strAll -> %v, ret: %v", strAll, filter, ret)
+		(filter.incRe == nil || searchMatch(filter.incRe, filter.incFields, r)) &&
+		(filter.excRe == nil || !searchMatch(filter.excRe, filter.excFields, r)) &&
+		(!filter.tagMatch || slices.Contains(r.GetTags(), filter.tag)) &&
+		(filter.where == nil || filter.where.Eval(r.GetFields()))
+	log.Debugf("filter.Run(): %s -> %v", r.StringAll(), ret)
 	return ret
 }
+
+// SearchField is a named list of values include and exclude regexes match,
+// e.g. the tags of a resource, or its metadata as "key=value" strings
+type SearchField struct {
+	Name   string
+	Values []string
+}
+
+// SearchString is the searchable string of a resource: its GetSearchFields()
+// values as space separated "field=value", in order, a field repeated for
+// every value and left out if empty, e.g.:
+//
+//	name=vm1 id=42 project=jdoe__alumno_project email=jdoe@alumno tags=os-cleanup tags=course metadata=owner=jdoe image=cirros flavor=m1.small
+func SearchString(fields []SearchField) string {
+	pairs := make([]string, 0, len(fields))
+	for _, field := range fields {
+		for _, value := range field.Values {
+			if value != "" {
+				pairs = append(pairs, field.Name+"="+value)
+			}
+		}
+	}
+	return strings.Join(pairs, " ")
+}
+
+// searchMatch tells if re matches a value of the names search fields of r, or
+// its whole StringAll() if none given
+func searchMatch(re *regexp.Regexp, names []string, r Lister) bool {
+	if len(names) == 0 {
+		return re.MatchString(r.StringAll())
+	}
+	for _, field := range r.GetSearchFields() {
+		if !slices.Contains(names, field.Name) {
+			continue
+		}
+		for _, value := range field.Values {
+			if re.MatchString(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mapValues returns the string values of keys found in m, e.g. a server
+// image name and ID
+func mapValues(m map[string]interface{}, keys ...string) []string {
+	values := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if value, ok := m[key].(string); ok && value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// metadataValues returns metadata as "key=value" strings, sorted by key
+func metadataValues(metadata map[string]string) []string {
+	values := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		values = append(values, key+"="+value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// ServerListOpts narrow server listings at the API, servers found are still
+// filtered client side. Zero values don't narrow
+type ServerListOpts struct {
+	// CreatedBefore lists servers by creation date, stopping at the first one
+	// created after it (Nova changes-before is about updates instead)
+	CreatedBefore time.Time
+	// ProjectRe lists only the servers of projects whose name matches, if
+	// some and at most maxProjectQueries do
+	ProjectRe *regexp.Regexp
+	// Tags lists only servers with all of them, NotTags skips those with
+	// all of them
+	Tags    []string
+	NotTags []string
+	// Status e.g. ACTIVE or SHUTOFF
+	Status string
+}
+
+// maxProjectQueries above which listing all projects servers at once is
+// cheaper than one query per project
+const maxProjectQueries = 20
+
+// String describes opts, e.g. for debug logs
+func (opts ServerListOpts) String() string {
+	str := make([]string, 0)
+	if !opts.CreatedBefore.IsZero() {
+		str = append(str, "created<"+opts.CreatedBefore.UTC().Format(time.RFC3339))
+	}
+	if opts.ProjectRe != nil {
+		str = append(str, fmt.Sprintf("project=%q", opts.ProjectRe.String()))
+	}
+	if len(opts.Tags) > 0 {
+		str = append(str, "tags="+strings.Join(opts.Tags, ","))
+	}
+	if len(opts.NotTags) > 0 {
+		str = append(str, "not-tags="+strings.Join(opts.NotTags, ","))
+	}
+	if opts.Status != "" {
+		str = append(str, "status="+opts.Status)
+	}
+	return strings.Join(str, " ")
+}
@@ -0,0 +1,67 @@
+package openstack
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/stretchr/testify/require"
+)
+
+func newSearchInstance() *Instance {
+	return &Instance{
+		osClient: &OSClient{},
+		Server: &servers.Server{
+			Metadata: map[string]string{"owner": "jdoe", "note": "NOBORRAR"},
+			Image:    map[string]interface{}{"id": "img-1"},
+			Flavor:   map[string]interface{}{"original_name": "m1.small"},
+		},
+		InstanceName: "vm1",
+		InstanceID:   "42",
+		ProjectName:  "jdoe__alumno_project",
+		Email:        "jdoe@alumno",
+		Created:      time.Now().AddDate(0, 0, -10),
+		Tags:         []string{"os-cleanup", "course"},
+	}
+}
+
+func TestInstanceStringAll(t *testing.T) {
+	require.Equal(t,
+		"name=vm1 id=42 project=jdoe__alumno_project email=jdoe@alumno tags=os-cleanup tags=course "+
+			"metadata=note=NOBORRAR metadata=owner=jdoe image=img-1 flavor=m1.small",
+		newSearchInstance().StringAll())
+
+	// Nothing but the resource fields, e.g. no osClient pointer
+	require.Equal(t, "name=vm2 id=43", (&Instance{osClient: &OSClient{}, InstanceName: "vm2", InstanceID: "43"}).StringAll())
+	require.Equal(t,
+		[]string{"name", "id", "project", "email", "tags", "metadata", "image", "flavor"},
+		KindSearchFields("server"))
+	require.Equal(t, []string{"name", "id", "project", "email", "tags", "metadata", "image"}, KindSearchFields("volume"))
+	require.Nil(t, KindSearchFields("foo"))
+}
+
+func TestOSResourceFilterFields(t *testing.T) {
+	tests := []struct {
+		name      string
+		incRe     string
+		incFields []string
+		excRe     string
+		excFields []string
+		want      bool
+	}{
+		{"searchable string", "alumno", nil, "", nil, true},
+		{"exclude anywhere", "", nil, "NOBORRAR", nil, false},
+		{"exclude by name only", "", nil, "NOBORRAR", []string{"name"}, true},
+		{"exclude by metadata", "", nil, "^note=NOBORRAR$", []string{"metadata"}, false},
+		{"include anchored project", "^jdoe__", []string{"project"}, "", nil, true},
+		{"include anchored name", "^jdoe__", []string{"name"}, "", nil, false},
+		{"include by image or flavor", "^m1\\.", []string{"image", "flavor"}, "", nil, true},
+		{"include by tags", "^course$", []string{"tags"}, "", nil, true},
+	}
+	for _, tt := range tests {
+		filter := NewOSResourceFilter(time.Now(), tt.incRe, tt.excRe, "", false).
+			WithIncFields(tt.incFields).
+			WithExcFields(tt.excFields)
+		require.Equal(t, tt.want, filter.Run(newSearchInstance()), tt.name)
+	}
+}
@@ -0,0 +1,216 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/attributestags"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
+	"github.com/gophercloud/gophercloud/pagination"
+)
+
+type FloatingIP struct {
+	osClient    *OSClient
+	FloatingIP  *floatingips.FloatingIP
+	Address     string    `json:"address"`
+	FloatingID  string    `json:"id"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+	ProjectName string    `json:"project"`
+	ProjectID   string    `json:"project_id"`
+	Cloud       string    `json:"cloud"`
+	Region      string    `json:"region"`
+	Email       string    `json:"email"`
+	EmailSource string    `json:"email_source"`
+	PortID      string    `json:"port_id"`
+	FixedIP     string    `json:"fixed_ip"`
+	Status      string    `json:"status"`
+	Tags        []string  `json:"tags"`
+}
+
+func GetFloatingIPRowHeader() []interface{} {
+	return []interface{}{"Address", "FloatingIP_ID", "Created", "Status", "Port_ID", "Fixed_IP", "Cloud", "Region", "Project", "Email", "Email_Source", "Tags"}
+}
+
+func (osClient *OSClient) withNetworkClient() (*OSClient, error) {
+	if osClient.NetworkClient != nil {
+		return osClient, nil
+	}
+
+	networkClient, err := openstack.NewNetworkV2(osClient.ProviderClient, osClient.endpointOpts)
+	if err != nil {
+		return nil, newError(ErrEndpoint, "create Network service client", err)
+	}
+	osClient.NetworkClient = networkClient
+
+	return osClient, nil
+}
+
+// GetFloatingIPs returns filtered floating IPs from all projects, the ones
+// associated to a port are skipped unless inUse is set
+func (osClient *OSClient) GetFloatingIPs(
+	ctx context.Context, filter func(OSResourceInterface) bool, inUse bool,
+) ([]OSResourceInterface, error) {
+	osClient, err := osClient.withProjectsCache(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	osClient, err = osClient.withNetworkClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]OSResourceInterface, 0)
+	err = floatingips.List(osClient.withContext(ctx).NetworkClient, floatingips.ListOpts{}).EachPage(func(page pagination.Page) (bool, error) {
+		pageFloatingIPs, err := floatingips.ExtractFloatingIPs(page)
+		if err != nil {
+			return false, err
+		}
+		for i := range pageFloatingIPs {
+			floatingIP := newFloatingIP(ctx, osClient, &pageFloatingIPs[i])
+			if floatingIP.PortID != "" && !inUse {
+				continue
+			}
+			if filter(floatingIP) {
+				resources = append(resources, floatingIP)
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, listError("floating IPs", err)
+	}
+
+	return resources, nil
+}
+
+func newFloatingIP(ctx context.Context, osClient *OSClient, fip *floatingips.FloatingIP) *FloatingIP {
+	floatingIP := &FloatingIP{
+		osClient:    osClient,
+		Cloud:       osClient.cloud,
+		Region:      osClient.endpointOpts.Region,
+		FloatingIP:  fip,
+		Address:     fip.FloatingIP,
+		FloatingID:  fip.ID,
+		Created:     fip.CreatedAt,
+		Updated:     fip.UpdatedAt,
+		ProjectName: osClient.projectName(fip.ProjectID),
+		ProjectID:   fip.ProjectID,
+		PortID:      fip.PortID,
+		FixedIP:     fip.FixedIP,
+		Status:      fip.Status,
+		Tags:        fip.Tags,
+	}
+	if floatingIP.Tags == nil {
+		floatingIP.Tags = make([]string, 0)
+	}
+	floatingIP.Email, floatingIP.EmailSource = osClient.resolveOwner(ctx, floatingIP)
+
+	return floatingIP
+}
+
+func (floatingIP *FloatingIP) GetKind() string {
+	return "floatingip"
+}
+
+func (floatingIP *FloatingIP) GetData() (string, string, string) {
+	return floatingIP.FloatingID, floatingIP.Address, floatingIP.ProjectName
+}
+
+func (floatingIP *FloatingIP) GetRow() []interface{} {
+	return []interface{}{
+		floatingIP.Address,
+		floatingIP.FloatingID,
+		floatingIP.Created,
+		floatingIP.Status,
+		floatingIP.PortID,
+		floatingIP.FixedIP,
+		floatingIP.Cloud,
+		floatingIP.Region,
+		floatingIP.ProjectName,
+		floatingIP.Email,
+		floatingIP.EmailSource,
+		floatingIP.Tags,
+	}
+}
+
+func (floatingIP *FloatingIP) GetFields() map[string]interface{} {
+	fields := commonFields(floatingIP)
+	fields["email_source"] = floatingIP.EmailSource
+	fields["status"] = floatingIP.Status
+	fields["port_id"] = floatingIP.PortID
+	fields["fixed_ip"] = floatingIP.FixedIP
+	return fields
+}
+
+func (floatingIP *FloatingIP) Delete(ctx context.Context) error {
+	return floatingips.Delete(floatingIP.osClient.withContext(ctx).NetworkClient, floatingIP.FloatingID).ExtractErr()
+}
+
+func (floatingIP *FloatingIP) Tag(ctx context.Context, str string) error {
+	return attributestags.Add(floatingIP.osClient.withContext(ctx).NetworkClient, "floatingips", floatingIP.FloatingID, str).ExtractErr()
+}
+
+func (floatingIP *FloatingIP) Untag(ctx context.Context, str string) error {
+	err := attributestags.Delete(floatingIP.osClient.withContext(ctx).NetworkClient, "floatingips", floatingIP.FloatingID, str).ExtractErr()
+	if _, ok := err.(gophercloud.ErrDefault404); ok {
+		err = nil
+	}
+
+	return err
+}
+
+func (floatingIP *FloatingIP) CreatedBefore(t time.Time) bool {
+	return floatingIP.Created.Before(t)
+}
+
+func (floatingIP *FloatingIP) String() string {
+	return fmt.Sprintf("Kind: FloatingIP Address: %s ID: %s Project: %s",
+		floatingIP.Address, floatingIP.FloatingID, floatingIP.ProjectName)
+}
+
+// StringAll returns the searchable string include and exclude regexes match
+// by default, see SearchString()
+func (floatingIP *FloatingIP) StringAll() string {
+	return SearchString(floatingIP.GetSearchFields())
+}
+
+func (floatingIP *FloatingIP) GetSearchFields() []SearchField {
+	return commonSearchFields(floatingIP)
+}
+
+func (floatingIP *FloatingIP) GetTags() []string {
+	return floatingIP.Tags
+}
+
+func (floatingIP *FloatingIP) GetProjectName() string {
+	return floatingIP.ProjectName
+}
+
+func (floatingIP *FloatingIP) GetProjectID() string {
+	return floatingIP.ProjectID
+}
+
+func (floatingIP *FloatingIP) GetCloud() string {
+	return floatingIP.Cloud
+}
+
+func (floatingIP *FloatingIP) GetRegion() string {
+	return floatingIP.Region
+}
+
+func (floatingIP *FloatingIP) GetEmail() string {
+	return floatingIP.Email
+}
+
+func (floatingIP *FloatingIP) GetCreated() time.Time {
+	return floatingIP.Created
+}
+
+func (floatingIP *FloatingIP) GetUpdated() time.Time {
+	return floatingIP.Updated
+}
@@ -0,0 +1,78 @@
+package openstack
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jjo/openstack-ops/pkg/fakeopenstack"
+)
+
+func TestGetFloatingIPs(t *testing.T) {
+	cloud := newFakeCloud(t)
+	cloud.AddFloatingIP(fakeopenstack.FloatingIP{
+		ID: "f1", Address: "203.0.113.1", ProjectID: "p1", Created: time.Now().AddDate(0, 0, -90), Tags: []string{"os-cleanup"},
+	})
+	cloud.AddFloatingIP(fakeopenstack.FloatingIP{
+		ID: "f2", Address: "203.0.113.2", ProjectID: "p1", PortID: "port1", FixedIP: "10.0.0.2",
+	})
+	osClient, err := NewOSClient(CloudConfig{Cloud: "fake"})
+	require.NoError(t, err)
+	all := func(OSResourceInterface) bool { return true }
+
+	resources, err := osClient.GetFloatingIPs(context.Background(), all, false)
+	require.NoError(t, err)
+	require.Len(t, resources, 1)
+	floatingIP := resources[0].(*FloatingIP)
+	id, address, project := floatingIP.GetData()
+	require.Equal(t, []string{"f1", "203.0.113.1", "foo__bar.com_project"}, []string{id, address, project})
+	require.Equal(t, "DOWN", floatingIP.Status)
+	require.Equal(t, []string{"os-cleanup"}, floatingIP.Tags)
+	require.True(t, floatingIP.CreatedBefore(time.Now().AddDate(0, 0, -60)))
+
+	// Associated floating IPs only if asked so
+	resources, err = osClient.GetFloatingIPs(context.Background(), all, true)
+	require.NoError(t, err)
+	require.Len(t, resources, 2)
+	associated := resources[1].(*FloatingIP)
+	require.Equal(t, "port1", associated.PortID)
+	require.Equal(t, "10.0.0.2", associated.FixedIP)
+}
+
+func TestFloatingIPActions(t *testing.T) {
+	cloud := newFakeCloud(t)
+	cloud.AddFloatingIP(fakeopenstack.FloatingIP{ID: "f1", Address: "203.0.113.1", ProjectID: "p1", Tags: []string{"tag1"}})
+	osClient, err := NewOSClient(CloudConfig{Cloud: "fake"})
+	require.NoError(t, err)
+	resources, err := osClient.GetFloatingIPs(context.Background(), func(OSResourceInterface) bool { return true }, false)
+	require.NoError(t, err)
+	require.Len(t, resources, 1)
+	floatingIP := resources[0].(*FloatingIP)
+	ctx := context.Background()
+
+	tags := func() []string {
+		fip, ok := cloud.GetFloatingIP("f1")
+		require.True(t, ok)
+		return fip.Tags
+	}
+
+	require.NoError(t, floatingIP.Tag(ctx, "os-cleanup"))
+	require.Equal(t, []string{"tag1", "os-cleanup"}, tags())
+	require.NoError(t, floatingIP.Untag(ctx, "tag1"))
+	require.Equal(t, []string{"os-cleanup"}, tags())
+	// Already gone tags (404) are fine, other errors are not
+	require.NoError(t, floatingIP.Untag(ctx, "tag1"))
+	require.Equal(t, 2, cloud.Requests(http.MethodDelete, fakeopenstack.NetworkPath+"floatingips/f1/tags/tag1"))
+	cloud.Fail(fakeopenstack.Failure{
+		Method: http.MethodDelete, Path: fakeopenstack.NetworkPath + "floatingips/f1/tags/*", Status: http.StatusForbidden,
+	})
+	require.Error(t, floatingIP.Untag(ctx, "os-cleanup"))
+	require.Equal(t, []string{"os-cleanup"}, tags())
+
+	require.NoError(t, floatingIP.Delete(ctx))
+	_, ok := cloud.GetFloatingIP("f1")
+	require.False(t, ok)
+}
@@ -0,0 +1,97 @@
+package openstack
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	LifecycleTag    = "tag"
+	LifecycleStop   = "stop"
+	LifecycleDelete = "delete"
+	// LifecycleRestart is done instead of LifecycleDelete on servers running
+	// again since stopped, e.g. started by their owner during the grace period
+	LifecycleRestart = "restart"
+
+	StageTagged  = "tagged"
+	StageStopped = "stopped"
+
+	stageDateLayout = "2006-01-02"
+)
+
+// Lifecycle walks resources through tag -> stop -> delete, recording the
+// date each stage was reached as a `<Prefix>:<stage>=<YYYY-MM-DD>` tag,
+// e.g. `os-cleanup:tagged=2026-10-01`
+type Lifecycle struct {
+	Prefix      string
+	StopGrace   int
+	DeleteGrace int
+}
+
+func NewLifecycle(prefix string, stopGrace, deleteGrace int) *Lifecycle {
+	return &Lifecycle{
+		Prefix:      prefix,
+		StopGrace:   stopGrace,
+		DeleteGrace: deleteGrace,
+	}
+}
+
+// StageTag returns the tag recording `stage` was reached at `t`
+func (l *Lifecycle) StageTag(stage string, t time.Time) string {
+	return l.Prefix + ":" + stage + "=" + t.UTC().Format(stageDateLayout)
+}
+
+// StageDate returns when `stage` was reached, as recorded in `tags`
+func (l *Lifecycle) StageDate(tags []string, stage string) (time.Time, bool) {
+	prefix := l.Prefix + ":" + stage + "="
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, prefix) {
+			continue
+		}
+		t, err := time.Parse(stageDateLayout, strings.TrimPrefix(tag, prefix))
+		if err != nil {
+			log.Warningf("Ignoring malformed lifecycle tag: %s", tag)
+			continue
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// StageTags returns the stage tags found in `tags`, whatever their date
+func (l *Lifecycle) StageTags(tags []string) []string {
+	stageTags := make([]string, 0)
+	for _, tag := range tags {
+		for _, stage := range []string{StageTagged, StageStopped} {
+			if strings.HasPrefix(tag, l.Prefix+":"+stage+"=") {
+				stageTags = append(stageTags, tag)
+			}
+		}
+	}
+	return stageTags
+}
+
+// Next returns the next lifecycle step for a resource with `tags`, and the
+// date from which it's due (i.e. once its grace period expired)
+func (l *Lifecycle) Next(tags []string, now time.Time) (string, time.Time) {
+	if stopped, ok := l.StageDate(tags, StageStopped); ok {
+		return LifecycleDelete, stopped.AddDate(0, 0, l.DeleteGrace)
+	}
+	if tagged, ok := l.StageDate(tags, StageTagged); ok {
+		return LifecycleStop, tagged.AddDate(0, 0, l.StopGrace)
+	}
+	return LifecycleTag, now
+}
+
+// Planned returns the next owner-visible step (tagging is assumed to happen
+// right now) and the date it will happen at the earliest
+func (l *Lifecycle) Planned(tags []string, now time.Time) (string, time.Time) {
+	step, due := l.Next(tags, now)
+	if step == LifecycleTag {
+		step, due = LifecycleStop, now.AddDate(0, 0, l.StopGrace)
+	}
+	if due.Before(now) {
+		due = now
+	}
+	return step, due
+}
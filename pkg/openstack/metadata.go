@@ -0,0 +1,44 @@
+package openstack
+
+import (
+	"github.com/gophercloud/gophercloud"
+)
+
+// Cinder resources have no tags, we store them as metadata keys with this value
+const metadataTagValue = "true"
+
+func metadataTags(metadata map[string]string) []string {
+	tags := make([]string, 0)
+	for key, value := range metadata {
+		if value == metadataTagValue {
+			tags = append(tags, key)
+		}
+	}
+
+	return tags
+}
+
+// addMetadataTag sets `tag` metadata key, leaving the rest of the metadata untouched
+func addMetadataTag(client *gophercloud.ServiceClient, collection, id, tag string) error {
+	body := map[string]interface{}{
+		"metadata": map[string]string{tag: metadataTagValue},
+	}
+	_, err := client.Post(client.ServiceURL(collection, id, "metadata"), body, nil, &gophercloud.RequestOpts{
+		OkCodes: []int{200},
+	})
+
+	return err
+}
+
+// deleteMetadataTag removes `tag` metadata key, Cinder replies 200 (not the
+// 202/204 expected by default), already gone ones are fine
+func deleteMetadataTag(client *gophercloud.ServiceClient, collection, id, tag string) error {
+	_, err := client.Delete(client.ServiceURL(collection, id, "metadata", tag), &gophercloud.RequestOpts{
+		OkCodes: []int{200, 204},
+	})
+	if _, ok := err.(gophercloud.ErrDefault404); ok {
+		err = nil
+	}
+
+	return err
+}
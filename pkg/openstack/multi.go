@@ -0,0 +1,158 @@
+package openstack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// CloudConfigs returns one CloudConfig per cloud and region combination, an
+// empty clouds or regions meaning the default one (see CloudConfig)
+func CloudConfigs(clouds, regions []string, iface string) []CloudConfig {
+	if len(clouds) == 0 {
+		clouds = []string{""}
+	}
+	if len(regions) == 0 {
+		regions = []string{""}
+	}
+	configs := make([]CloudConfig, 0, len(clouds)*len(regions))
+	for _, cloud := range clouds {
+		for _, region := range regions {
+			configs = append(configs, CloudConfig{Cloud: cloud, Region: region, Interface: iface})
+		}
+	}
+	return configs
+}
+
+func (osClient *OSClient) String() string {
+	return fmt.Sprintf("cloud=%s region=%s", osClient.cloud, osClient.endpointOpts.Region)
+}
+
+// MultiClient lists resources from several clouds and/or regions as one,
+// each resource keeps the OSClient it came from, thus acting against it
+type MultiClient struct {
+	clients []OSClientInterface
+}
+
+func NewMultiClient(clients ...OSClientInterface) *MultiClient {
+	return &MultiClient{clients: clients}
+}
+
+// each calls get for every client concurrently, returning all the resources
+// found (in clients order) and every error, wrapped with the client they
+// came from
+func (multi *MultiClient) each(
+	get func(OSClientInterface) ([]OSResourceInterface, error),
+) ([]OSResourceInterface, error) {
+	results := make([][]OSResourceInterface, len(multi.clients))
+	errs := make([]error, len(multi.clients))
+
+	var wg sync.WaitGroup
+	for i, client := range multi.clients {
+		wg.Add(1)
+		go func(i int, client OSClientInterface) {
+			defer wg.Done()
+			results[i], errs[i] = get(client)
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("%v: %w", client, errs[i])
+			}
+		}(i, client)
+	}
+	wg.Wait()
+
+	resources := make([]OSResourceInterface, 0)
+	for _, result := range results {
+		resources = append(resources, result...)
+	}
+	return resources, errors.Join(errs...)
+}
+
+func (multi *MultiClient) GetInstances(ctx context.Context, filter func(OSResourceInterface) bool) ([]OSResourceInterface, error) {
+	return multi.each(func(client OSClientInterface) ([]OSResourceInterface, error) {
+		return client.GetInstances(ctx, filter)
+	})
+}
+
+func (multi *MultiClient) GetVolumes(ctx context.Context, filter func(OSResourceInterface) bool, inUse bool) ([]OSResourceInterface, error) {
+	return multi.each(func(client OSClientInterface) ([]OSResourceInterface, error) {
+		return client.GetVolumes(ctx, filter, inUse)
+	})
+}
+
+func (multi *MultiClient) GetSnapshots(ctx context.Context, filter func(OSResourceInterface) bool) ([]OSResourceInterface, error) {
+	return multi.each(func(client OSClientInterface) ([]OSResourceInterface, error) {
+		return client.GetSnapshots(ctx, filter)
+	})
+}
+
+func (multi *MultiClient) GetFloatingIPs(ctx context.Context, filter func(OSResourceInterface) bool, inUse bool) ([]OSResourceInterface, error) {
+	return multi.each(func(client OSClientInterface) ([]OSResourceInterface, error) {
+		return client.GetFloatingIPs(ctx, filter, inUse)
+	})
+}
+
+func (multi *MultiClient) GetSafetySnapshots(ctx context.Context, filter func(OSResourceInterface) bool) ([]OSResourceInterface, error) {
+	return multi.each(func(client OSClientInterface) ([]OSResourceInterface, error) {
+		return client.GetSafetySnapshots(ctx, filter)
+	})
+}
+
+// Operator returns the distinct operators of all clients, comma separated
+func (multi *MultiClient) Operator() string {
+	operators := make([]string, 0, len(multi.clients))
+	for _, client := range multi.clients {
+		if operator := client.Operator(); !slices.Contains(operators, operator) {
+			operators = append(operators, operator)
+		}
+	}
+	return strings.Join(operators, ",")
+}
+
+func (multi *MultiClient) WithWorkers(workers int) OSClientInterface {
+	for _, client := range multi.clients {
+		client.WithWorkers(workers)
+	}
+	return multi
+}
+
+// WithProjectToEmail sets the same resolver for all clients, keystone ones
+// should rather be set per client before NewMultiClient()
+func (multi *MultiClient) WithProjectToEmail(resolver OwnerResolver) OSClientInterface {
+	for _, client := range multi.clients {
+		client.WithProjectToEmail(resolver)
+	}
+	return multi
+}
+
+func (multi *MultiClient) WithSnapshotBeforeDelete(retentionDays int, timeout time.Duration) OSClientInterface {
+	for _, client := range multi.clients {
+		client.WithSnapshotBeforeDelete(retentionDays, timeout)
+	}
+	return multi
+}
+
+func (multi *MultiClient) WithWait(timeout time.Duration, resetState bool) OSClientInterface {
+	for _, client := range multi.clients {
+		client.WithWait(timeout, resetState)
+	}
+	return multi
+}
+
+func (multi *MultiClient) WithRetryPolicy(policy RetryPolicy) OSClientInterface {
+	for _, client := range multi.clients {
+		client.WithRetryPolicy(policy)
+	}
+	return multi
+}
+
+func (multi *MultiClient) WithServerListOpts(opts ServerListOpts) OSClientInterface {
+	for _, client := range multi.clients {
+		client.WithServerListOpts(opts)
+	}
+	return multi
+}
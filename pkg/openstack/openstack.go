@@ -1,7 +1,15 @@
 package openstack
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/alitto/pond"
 
@@ -13,70 +21,162 @@ import (
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 	"github.com/gophercloud/gophercloud/openstack/identity/v3/projects"
 	"github.com/gophercloud/gophercloud/pagination"
+	"github.com/gophercloud/utils/openstack/clientconfig"
 )
 
 type OSClientInterface interface {
-	GetInstances(filter func(OSResourceInterface) bool) ([]OSResourceInterface, error)
+	GetInstances(ctx context.Context, filter func(OSResourceInterface) bool) ([]OSResourceInterface, error)
+	GetVolumes(ctx context.Context, filter func(OSResourceInterface) bool, inUse bool) ([]OSResourceInterface, error)
+	GetSnapshots(ctx context.Context, filter func(OSResourceInterface) bool) ([]OSResourceInterface, error)
+	GetFloatingIPs(ctx context.Context, filter func(OSResourceInterface) bool, inUse bool) ([]OSResourceInterface, error)
+	GetSafetySnapshots(ctx context.Context, filter func(OSResourceInterface) bool) ([]OSResourceInterface, error)
 	WithWorkers(workers int) OSClientInterface
-	WithProjectToEmail(projectToEmail func(OSResourceInterface) string) OSClientInterface
+	WithProjectToEmail(resolver OwnerResolver) OSClientInterface
+	WithSnapshotBeforeDelete(retentionDays int, timeout time.Duration) OSClientInterface
+	WithWait(timeout time.Duration, resetState bool) OSClientInterface
+	WithRetryPolicy(policy RetryPolicy) OSClientInterface
+	WithServerListOpts(opts ServerListOpts) OSClientInterface
+	Operator() string
 }
 
 type OSClient struct {
-	ProviderClient *gophercloud.ProviderClient
-	ComputeClient  *gophercloud.ServiceClient
-	IdentityClient *gophercloud.ServiceClient
-	workers        int
-	projectToEmail func(OSResourceInterface) string
-	projectsCache  map[string]string
+	ProviderClient     *gophercloud.ProviderClient
+	ComputeClient      *gophercloud.ServiceClient
+	IdentityClient     *gophercloud.ServiceClient
+	BlockStorageClient *gophercloud.ServiceClient
+	NetworkClient      *gophercloud.ServiceClient
+	ImageClient        *gophercloud.ServiceClient
+	workers            int
+	snapshotBeforeDel  bool
+	snapshotRetention  int
+	snapshotTimeout    time.Duration
+	waitTimeout        time.Duration
+	resetState         bool
+	ownerResolver      OwnerResolver
+	projectsCache      map[string]projects.Project
+	endpointOpts       gophercloud.EndpointOpts
+	serverListOpts     ServerListOpts
+	// cloud is the clouds.yaml entry name, shown along resources
+	cloud string
+	// operator is who authenticated, see Operator()
+	operator string
+}
+
+// CloudConfig selects credentials and endpoints: the clouds.yaml (plus
+// secure.yaml) entry named Cloud, or OS_CLOUD, else the OS_* env vars
+type CloudConfig struct {
+	Cloud string
+	// Region and Interface (public, internal or admin) override the ones
+	// from clouds.yaml or OS_REGION_NAME, OS_INTERFACE env vars
+	Region    string
+	Interface string
+}
+
+// endpointOpts returns the region and interface to use for service clients
+func (config CloudConfig) endpointOpts() (gophercloud.EndpointOpts, error) {
+	region, iface := os.Getenv("OS_REGION_NAME"), os.Getenv("OS_INTERFACE")
+	if config.Cloud != "" || os.Getenv("OS_CLOUD") != "" {
+		cloud, err := clientconfig.GetCloudFromYAML(&clientconfig.ClientOpts{Cloud: config.Cloud})
+		if err != nil {
+			return gophercloud.EndpointOpts{}, err
+		}
+		region, iface = cloud.RegionName, cloud.Interface
+		if cloud.EndpointType != "" {
+			iface = cloud.EndpointType
+		}
+	}
+	if config.Region != "" {
+		region = config.Region
+	}
+	if config.Interface != "" {
+		iface = config.Interface
+	}
+	return gophercloud.EndpointOpts{Region: region, Availability: clientconfig.GetEndpointType(iface)}, nil
 }
 
 var log = logger.Log
 
-func NewOSClient() *OSClient {
-	// Load your OpenStack credentials from environment variables or configuration file
-	authOpts, err := openstack.AuthOptionsFromEnv()
+// NewOSClient authenticates as per config, returned errors are ErrAuth or
+// ErrEndpoint kinds of *Error
+func NewOSClient(config CloudConfig) (*OSClient, error) {
+	// Load OpenStack credentials from clouds.yaml (including application
+	// credentials), falling back to OS_* env vars
+	authOpts, err := clientconfig.AuthOptions(&clientconfig.ClientOpts{Cloud: config.Cloud})
+	if err != nil {
+		return nil, newError(ErrAuth, "get auth options (missing --cloud or OS_* env vars?)", err)
+	}
+	authOpts.AllowReauth = true
+	endpointOpts, err := config.endpointOpts()
 	if err != nil {
-		log.Fatalf("Failed to get OpenStack authentication options (missing OS_* env vars):", err)
+		return nil, newError(ErrAuth, "get region and interface", err)
 	}
 
 	// Create an authenticated OpenStack client
-	provider, err := openstack.AuthenticatedClient(authOpts)
+	provider, err := openstack.AuthenticatedClient(*authOpts)
 	if err != nil {
-		log.Fatal("Failed to create an authenticated client:", err)
+		return nil, newError(ErrAuth, "authenticate to "+authOpts.IdentityEndpoint, err)
 	}
 
 	// Initialize the Compute service client
-	computeClient, err := openstack.NewComputeV2(provider, gophercloud.EndpointOpts{})
-
+	computeClient, err := openstack.NewComputeV2(provider, endpointOpts)
+	if err != nil {
+		return nil, newError(ErrEndpoint, "create Compute service client", err)
+	}
 	// NB: v2.26 needed to enable Tags interface
 	computeClient.Microversion = "2.26"
 
-	if err != nil {
-		log.Fatal("Failed to create Compute service client:", err)
-	}
 	// Initialize the identity service client
-	identityClient, err := openstack.NewIdentityV3(provider, gophercloud.EndpointOpts{})
+	identityClient, err := openstack.NewIdentityV3(provider, endpointOpts)
 	if err != nil {
-		log.Fatal("Failed to create Identity service client:", err)
+		return nil, newError(ErrEndpoint, "create Identity service client", err)
 	}
 
-	log.Debugf("Successfully created clients for auth_url=%s domain=%s user=%s project=%s",
-		authOpts.IdentityEndpoint, authOpts.DomainName, authOpts.Username, authOpts.TenantName)
+	cloudName := config.Cloud
+	if cloudName == "" {
+		cloudName = os.Getenv("OS_CLOUD")
+	}
+	log.Debugf("Successfully created clients for cloud=%s region=%s auth_url=%s domain=%s user=%s project=%s",
+		cloudName, endpointOpts.Region, authOpts.IdentityEndpoint, authOpts.DomainName, authOpts.Username, authOpts.TenantName)
 	return &OSClient{
 		ProviderClient: provider,
 		ComputeClient:  computeClient,
 		IdentityClient: identityClient,
 		workers:        1,
+		endpointOpts:   endpointOpts,
+		cloud:          cloudName,
+		operator:       authOperator(authOpts),
+	}, nil
+}
+
+// authOperator returns who authOpts (as resolved from clouds.yaml or OS_*
+// env vars) authenticate as: the application credential, user name or ID
+func authOperator(authOpts *gophercloud.AuthOptions) string {
+	switch {
+	case authOpts.ApplicationCredentialID != "":
+		return "application_credential:" + authOpts.ApplicationCredentialID
+	case authOpts.ApplicationCredentialName != "":
+		return "application_credential:" + authOpts.Username + "/" + authOpts.ApplicationCredentialName
+	case authOpts.Username != "":
+		return authOpts.Username
+	case authOpts.UserID != "":
+		return "user_id:" + authOpts.UserID
 	}
+	return "unknown"
 }
 
-func (osClient *OSClient) withProjectsCache() (*OSClient, error) {
+// Operator is who's running the tool as known by OpenStack, for audit
+// records and plans
+func (osClient *OSClient) Operator() string {
+	return osClient.operator
+}
+
+func (osClient *OSClient) withProjectsCache(ctx context.Context) (*OSClient, error) {
 	if osClient.projectsCache != nil {
 		return osClient, nil
 	}
 
-	osClient.projectsCache = make(map[string]string)
-	projectPager := projects.List(osClient.IdentityClient, projects.ListOpts{})
+	osClient.projectsCache = make(map[string]projects.Project)
+	projectPager := projects.List(osClient.withContext(ctx).IdentityClient, projects.ListOpts{})
 	// Retrieve and store project information
 	err := projectPager.EachPage(func(page pagination.Page) (bool, error) {
 		projectList, err := projects.ExtractProjects(page)
@@ -84,13 +184,13 @@ func (osClient *OSClient) withProjectsCache() (*OSClient, error) {
 			return false, err
 		}
 		for _, project := range projectList {
-			osClient.projectsCache[project.ID] = project.Name
+			osClient.projectsCache[project.ID] = project
 		}
 		return true, nil
 	})
 	if err != nil {
-		log.Fatalf("Failed to paginate projects: %s", err)
-		return nil, err
+		osClient.projectsCache = nil
+		return nil, listError("projects", err)
 	}
 
 	log.Debugf("Created projectsCache: Loaded %d projects", len(osClient.projectsCache))
@@ -104,80 +204,162 @@ func (osClient *OSClient) WithWorkers(workers int) OSClientInterface {
 	return osClient
 }
 
-func (osClient *OSClient) WithProjectToEmail(projectToEmail func(OSResourceInterface) string) OSClientInterface {
-	log.Debugf("Setting projectToEmail to: %s", projectToEmail)
-	osClient.projectToEmail = projectToEmail
+func (osClient *OSClient) WithProjectToEmail(resolver OwnerResolver) OSClientInterface {
+	log.Debugf("Setting owner resolver to: %s", resolver.Name())
+	osClient.ownerResolver = resolver
 	return osClient
 }
 
+// WithServerListOpts narrows GetInstances() listings at the API
+func (osClient *OSClient) WithServerListOpts(opts ServerListOpts) OSClientInterface {
+	log.Debugf("Setting server list opts to: %s", opts)
+	osClient.serverListOpts = opts
+	return osClient
+}
+
+func (osClient *OSClient) projectName(projectID string) string {
+	return osClient.projectsCache[projectID].Name
+}
+
 func (osClient *OSClient) GetInstances(
-	filter func(OSResourceInterface) bool,
+	ctx context.Context, filter func(OSResourceInterface) bool,
 ) ([]OSResourceInterface, error) {
-	osClient, err := osClient.withProjectsCache()
+	osClient, err := osClient.withProjectsCache(ctx)
 	if err != nil {
 		return nil, err
 	}
+	bound := osClient.withContext(ctx)
+	createdBefore := osClient.serverListOpts.CreatedBefore
 
-	if err != nil {
-		log.Fatalf("Failed to paginate projects: %s", err)
-		return nil, err
+	instances := make([]OSResourceInterface, 0)
+	errs := make([]error, 0)
+	mutex := &sync.Mutex{}
+	pool := pond.New(osClient.workers, 0, pond.MinWorkers(osClient.workers))
+
+	addInstance := func(server *ServerWithExt) {
+		projectName := osClient.projectName(server.Server.TenantID)
+		serverTags, errTmp := getServerTags(bound.ComputeClient, &server.Server)
+		if errTmp != nil {
+			log.Errorf("Getting tags for %s: %s", server.Server.ID, errTmp)
+			mutex.Lock()
+			errs = append(errs, fmt.Errorf("getting tags for %s: %w", server.Server.ID, errTmp))
+			mutex.Unlock()
+		}
+		instance := Instance{
+			osClient:     osClient,
+			Cloud:        osClient.cloud,
+			Region:       osClient.endpointOpts.Region,
+			Server:       &server.Server,
+			InstanceName: server.Server.Name,
+			InstanceID:   server.Server.ID,
+			Created:      server.Server.Created,
+			Updated:      server.Server.Updated,
+			VMState:      server.VmState,
+			TaskState:    server.TaskState,
+			PowerState:   server.PowerState.String(),
+			ProjectName:  projectName,
+			ProjectID:    server.Server.TenantID,
+			Tags:         serverTags,
+		}
+		instance.Email, instance.EmailSource = osClient.resolveOwner(ctx, &instance)
+		if filter(&instance) {
+			mutex.Lock()
+			instances = append(instances, &instance)
+			mutex.Unlock()
+		}
 	}
 
-	var allServers []ServerWithExt
+	// Stream page by page into the pool (blocking while its workers are
+	// busy) rather than getting all pages first
+	eachPage := func(page pagination.Page) (bool, error) {
+		var pageServers []ServerWithExt
+		if err := servers.ExtractServersInto(page, &pageServers); err != nil {
+			return false, newError(ErrExtract, "extract servers", err)
+		}
+		for _, serverIterator := range pageServers {
+			// Sorted by creation, all the next ones are newer
+			if !createdBefore.IsZero() && !serverIterator.Created.Before(createdBefore) {
+				return false, nil
+			}
+			server := new(ServerWithExt)
+			*server = serverIterator
+			pool.Submit(func() { addInstance(server) })
+		}
+		return true, nil
+	}
+	for _, query := range osClient.serverQueries() {
+		if err := servers.List(bound.ComputeClient, query).EachPage(eachPage); err != nil {
+			pool.StopAndWait()
+			return nil, listError("servers", err)
+		}
+	}
+	pool.StopAndWait()
+	return instances, errors.Join(errs...)
+}
 
-	allPages, err := servers.List(osClient.ComputeClient, servers.ListOpts{
-		AllTenants: true,
-	}).AllPages()
-	if err != nil {
-		log.Fatalf("Failed to list servers: %s", err)
-		return nil, err
+// getServerTags returns server tags from its listing (microversion >= 2.26),
+// else fetches them, a missing tags API (404) meaning none
+func getServerTags(compute *gophercloud.ServiceClient, server *servers.Server) ([]string, error) {
+	if server.Tags != nil {
+		return *server.Tags, nil
+	}
+	serverTags, err := tags.List(compute, server.ID).Extract()
+	if _, notFound := err.(gophercloud.ErrDefault404); notFound {
+		return nil, nil
 	}
+	return serverTags, err
+}
 
-	err = servers.ExtractServersInto(allPages, &allServers)
-	if err != nil {
-		log.Fatalf("Failed to extract servers: %s", err)
-		return nil, err
+// serverQuery adds to servers.ListOpts the sorting gophercloud v1 lacks
+type serverQuery struct {
+	servers.ListOpts
+	sortKey string
+	sortDir string
+}
+
+func (query serverQuery) ToServerListQuery() (string, error) {
+	str, err := query.ListOpts.ToServerListQuery()
+	if err != nil || query.sortKey == "" {
+		return str, err
 	}
+	sorting := url.Values{"sort_key": {query.sortKey}, "sort_dir": {query.sortDir}}
+	if str == "" {
+		return "?" + sorting.Encode(), nil
+	}
+	return str + "&" + sorting.Encode(), nil
+}
 
-	instances := make([]OSResourceInterface, 0)
-	// Iterate over the paginated results and filter instances older than one month
-	mutex := &sync.Mutex{}
-	pool := pond.New(osClient.workers, 0, pond.MinWorkers(osClient.workers))
+// serverQueries returns the listings to run as per serverListOpts: one per
+// project if ProjectRe narrows them, else a single one for all projects
+func (osClient *OSClient) serverQueries() []servers.ListOptsBuilder {
+	opts := osClient.serverListOpts
+	query := serverQuery{ListOpts: servers.ListOpts{
+		AllTenants: true,
+		Tags:       strings.Join(opts.Tags, ","),
+		NotTags:    strings.Join(opts.NotTags, ","),
+		Status:     opts.Status,
+	}}
+	if !opts.CreatedBefore.IsZero() {
+		query.sortKey, query.sortDir = "created_at", "asc"
+	}
 
-	for _, serverIterator := range allServers {
-		server := new(ServerWithExt)
-		*server = serverIterator
-
-		pool.Submit(func() {
-			projectName := osClient.projectsCache[server.Server.TenantID]
-			resp := tags.List(osClient.ComputeClient, server.Server.ID)
-			serverTags, errTmp := resp.Extract()
-			if errTmp != nil && resp.StatusCode != 404 {
-				log.Errorf("Getting tags for %s: %s", server.Server.ID, errTmp)
-				err = errTmp
-			}
-			instance := Instance{
-				osClient:     osClient,
-				Server:       &server.Server,
-				InstanceName: server.Server.Name,
-				InstanceID:   server.Server.ID,
-				Created:      server.Server.Created,
-				VMState:      server.VmState,
-				TaskState:    server.TaskState,
-				PowerState:   server.PowerState.String(),
-				ProjectName:  projectName,
-				Tags:         serverTags,
-			}
-			if osClient.projectToEmail != nil {
-				instance.Email = osClient.projectToEmail(&instance)
-			}
-			if filter(&instance) {
-				mutex.Lock()
-				instances = append(instances, &instance)
-				mutex.Unlock()
+	projectIDs := make([]string, 0)
+	if opts.ProjectRe != nil {
+		for id, project := range osClient.projectsCache {
+			if opts.ProjectRe.MatchString(project.Name) {
+				projectIDs = append(projectIDs, id)
 			}
-		})
+		}
 	}
-	pool.StopAndWait()
-	return instances, err
+	if len(projectIDs) == 0 || len(projectIDs) > maxProjectQueries {
+		return []servers.ListOptsBuilder{query}
+	}
+	sort.Strings(projectIDs)
+	queries := make([]servers.ListOptsBuilder, 0, len(projectIDs))
+	for _, id := range projectIDs {
+		query.TenantID = id
+		queries = append(queries, query)
+	}
+	log.Debugf("Listing servers of %d projects matching %q", len(projectIDs), opts.ProjectRe)
+	return queries
 }
@@ -0,0 +1,242 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jjo/openstack-ops/pkg/fakeopenstack"
+)
+
+func TestCloudConfigEndpointOpts(t *testing.T) {
+	cloudsYAML := filepath.Join(t.TempDir(), "clouds.yaml")
+	require.NoError(t, os.WriteFile(cloudsYAML, []byte(`
+clouds:
+  umcloud:
+    region_name: r1
+    interface: internal
+    auth:
+      auth_url: https://keystone.example.com:5000/v3
+      application_credential_id: app-id
+      application_credential_secret: secret
+    auth_type: v3applicationcredential
+`), 0o600))
+	t.Setenv("OS_CLIENT_CONFIG_FILE", cloudsYAML)
+	t.Setenv("OS_CLOUD", "")
+	t.Setenv("OS_REGION_NAME", "env-region")
+	t.Setenv("OS_INTERFACE", "")
+
+	tests := []struct {
+		name   string
+		config CloudConfig
+		want   gophercloud.EndpointOpts
+	}{
+		{"env vars", CloudConfig{}, gophercloud.EndpointOpts{Region: "env-region", Availability: gophercloud.AvailabilityPublic}},
+		{"clouds.yaml", CloudConfig{Cloud: "umcloud"}, gophercloud.EndpointOpts{Region: "r1", Availability: gophercloud.AvailabilityInternal}},
+		{
+			"clouds.yaml with overrides", CloudConfig{Cloud: "umcloud", Region: "r2", Interface: "admin"},
+			gophercloud.EndpointOpts{Region: "r2", Availability: gophercloud.AvailabilityAdmin},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.config.endpointOpts()
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := CloudConfig{Cloud: "missing"}.endpointOpts()
+	require.Error(t, err)
+}
+
+func TestCloudConfigs(t *testing.T) {
+	require.Equal(t, []CloudConfig{{}}, CloudConfigs(nil, nil, ""))
+	require.Equal(t, []CloudConfig{
+		{Cloud: "a", Region: "r1", Interface: "internal"},
+		{Cloud: "a", Region: "r2", Interface: "internal"},
+		{Cloud: "b", Region: "r1", Interface: "internal"},
+		{Cloud: "b", Region: "r2", Interface: "internal"},
+	}, CloudConfigs([]string{"a", "b"}, []string{"r1", "r2"}, "internal"))
+	require.Equal(t, []CloudConfig{{Region: "r1"}, {Region: "r2"}}, CloudConfigs(nil, []string{"r1", "r2"}, ""))
+}
+
+// newFakeServers adds servers s2..sN to cloud, s1 already there
+func newFakeServers(cloud *fakeopenstack.Cloud, n int) {
+	for i := 2; i <= n; i++ {
+		cloud.AddServer(fakeopenstack.Server{
+			ID: fmt.Sprintf("s%d", i), Name: fmt.Sprintf("server%d", i), ProjectID: "p1", Created: time.Now(),
+			Tags: []string{fmt.Sprintf("tag%d", i)},
+		})
+	}
+}
+
+func TestGetInstancesPaginated(t *testing.T) {
+	cloud := newFakeCloud(t)
+	cloud.PageSize = 2
+	newFakeServers(cloud, 5)
+
+	osClient, err := NewOSClient(CloudConfig{Cloud: "fake"})
+	require.NoError(t, err)
+	osClient.WithWorkers(3)
+	resources, err := osClient.GetInstances(context.Background(), func(OSResourceInterface) bool { return true })
+	require.NoError(t, err)
+
+	ids := make(map[string][]string)
+	for _, resource := range resources {
+		id, _, project := resource.GetData()
+		require.Equal(t, "foo__bar.com_project", project)
+		ids[id] = resource.GetTags()
+	}
+	require.Len(t, ids, 5)
+	require.Equal(t, []string{"tag1"}, ids["s1"])
+	require.Equal(t, []string{"tag5"}, ids["s5"])
+	// 5 servers in pages of 2: the last (empty) page tells there're no more
+	require.Equal(t, 3, cloud.Requests(http.MethodGet, fakeopenstack.ComputePath+"servers/detail"))
+	require.Equal(t, 1, cloud.Requests(http.MethodGet, fakeopenstack.IdentityPath+"projects"))
+	// Tags come along the servers (microversion 2.26)
+	require.Equal(t, 0, cloud.Requests(http.MethodGet, fakeopenstack.ComputePath+"servers/*/tags"))
+}
+
+func TestGetInstancesTagsFallback(t *testing.T) {
+	cloud := newFakeCloud(t)
+	cloud.OmitListTags = true
+	newFakeServers(cloud, 3)
+	// Servers with no tags support (404) are still listed
+	cloud.Fail(fakeopenstack.Failure{Path: fakeopenstack.ComputePath + "servers/s3/tags", Status: http.StatusNotFound})
+
+	osClient, err := NewOSClient(CloudConfig{Cloud: "fake"})
+	require.NoError(t, err)
+	resources, err := osClient.GetInstances(context.Background(), func(OSResourceInterface) bool { return true })
+	require.NoError(t, err)
+	ids := make(map[string][]string)
+	for _, resource := range resources {
+		id, _, _ := resource.GetData()
+		ids[id] = resource.GetTags()
+	}
+	require.Equal(t, map[string][]string{"s1": {"tag1"}, "s2": {"tag2"}, "s3": nil}, ids)
+	require.Equal(t, 3, cloud.Requests(http.MethodGet, fakeopenstack.ComputePath+"servers/*/tags"))
+
+	// Other errors getting tags are reported, along the servers listed
+	cloud.Fail(fakeopenstack.Failure{Path: fakeopenstack.ComputePath + "servers/s2/tags", Status: http.StatusForbidden})
+	resources, err = osClient.GetInstances(context.Background(), func(OSResourceInterface) bool { return true })
+	require.ErrorContains(t, err, "getting tags for s2")
+	require.Len(t, resources, 3)
+}
+
+// BenchmarkGetInstancesTags reports API requests per listing of 500
+// servers, with tags in the servers listing vs fetched per server
+func BenchmarkGetInstancesTags(b *testing.B) {
+	for _, omitListTags := range []bool{false, true} {
+		name := map[bool]string{false: "inline", true: "per-server"}[omitListTags]
+		b.Run(name, func(b *testing.B) {
+			cloud := fakeopenstack.New()
+			defer cloud.Close()
+			cloud.PageSize = 100
+			cloud.OmitListTags = omitListTags
+			cloud.AddProject(fakeopenstack.Project{ID: "p1", Name: "foo__bar.com_project"})
+			cloud.AddServer(fakeopenstack.Server{ID: "s1", ProjectID: "p1", Tags: []string{"tag1"}})
+			newFakeServers(cloud, 500)
+			cloudsYAML := filepath.Join(b.TempDir(), "clouds.yaml")
+			require.NoError(b, cloud.WriteCloudsYAML(cloudsYAML, "fake"))
+			b.Setenv("OS_CLIENT_CONFIG_FILE", cloudsYAML)
+			b.Setenv("OS_CLOUD", "")
+
+			osClient, err := NewOSClient(CloudConfig{Cloud: "fake"})
+			require.NoError(b, err)
+			osClient.WithWorkers(8)
+			requests := func() int {
+				return cloud.Requests(http.MethodGet, fakeopenstack.ComputePath+"servers/detail") +
+					cloud.Requests(http.MethodGet, fakeopenstack.ComputePath+"servers/*/tags")
+			}
+			before := requests()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resources, err := osClient.GetInstances(context.Background(), func(OSResourceInterface) bool { return true })
+				require.NoError(b, err)
+				require.Len(b, resources, 500)
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(requests()-before)/float64(b.N), "requests/op")
+		})
+	}
+}
+
+func TestGetInstancesServerListOpts(t *testing.T) {
+	now := time.Now()
+	cloud := newFakeCloud(t)
+	cloud.PageSize = 1
+	cloud.AddProject(fakeopenstack.Project{ID: "p2", Name: "admin"})
+	cloud.AddServer(fakeopenstack.Server{ID: "new", ProjectID: "p1", Created: now})
+	cloud.AddServer(fakeopenstack.Server{ID: "stopped", ProjectID: "p1", Created: now.AddDate(0, 0, -80), VMState: "stopped"})
+	cloud.AddServer(fakeopenstack.Server{ID: "tagged", ProjectID: "p1", Created: now.AddDate(0, 0, -70), Tags: []string{"cleanup"}})
+	cloud.AddServer(fakeopenstack.Server{ID: "admin", ProjectID: "p2", Created: now.AddDate(0, 0, -100)})
+
+	all := func(OSResourceInterface) bool { return true }
+	tests := []struct {
+		name         string
+		opts         ServerListOpts
+		wantIDs      []string
+		wantRequests int
+	}{
+		{"none", ServerListOpts{}, []string{"s1", "new", "stopped", "tagged", "admin"}, 6},
+		// Sorted by creation: admin, s1 (90d), stopped, tagged, then stops at new
+		{"created", ServerListOpts{CreatedBefore: now.AddDate(0, 0, -60)}, []string{"admin", "s1", "stopped", "tagged"}, 5},
+		{"project", ServerListOpts{ProjectRe: regexp.MustCompile("^admin$")}, []string{"admin"}, 2},
+		{"tags", ServerListOpts{Tags: []string{"cleanup"}}, []string{"tagged"}, 2},
+		{"not-tags", ServerListOpts{NotTags: []string{"tag1"}, Status: "ACTIVE"}, []string{"new", "tagged", "admin"}, 4},
+		{"status", ServerListOpts{Status: "SHUTOFF", ProjectRe: regexp.MustCompile("foo")}, []string{"stopped"}, 2},
+		{"no project matches", ServerListOpts{ProjectRe: regexp.MustCompile("none"), Status: "SHUTOFF"}, []string{"stopped"}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			osClient, err := NewOSClient(CloudConfig{Cloud: "fake"})
+			require.NoError(t, err)
+			osClient.WithServerListOpts(tt.opts)
+			before := cloud.Requests(http.MethodGet, fakeopenstack.ComputePath+"servers/detail")
+
+			resources, err := osClient.GetInstances(context.Background(), all)
+			require.NoError(t, err)
+			ids := make([]string, 0, len(resources))
+			for _, resource := range resources {
+				id, _, _ := resource.GetData()
+				ids = append(ids, id)
+			}
+			require.ElementsMatch(t, tt.wantIDs, ids)
+			require.Equal(t, tt.wantRequests, cloud.Requests(http.MethodGet, fakeopenstack.ComputePath+"servers/detail")-before)
+		})
+	}
+}
+
+func TestAuthOperator(t *testing.T) {
+	tests := []struct {
+		authOpts gophercloud.AuthOptions
+		want     string
+	}{
+		{gophercloud.AuthOptions{Username: "admin", UserID: "u1"}, "admin"},
+		{gophercloud.AuthOptions{UserID: "u1"}, "user_id:u1"},
+		{gophercloud.AuthOptions{ApplicationCredentialID: "ac1", ApplicationCredentialSecret: "s"}, "application_credential:ac1"},
+		{gophercloud.AuthOptions{Username: "admin", ApplicationCredentialName: "cleanup"}, "application_credential:admin/cleanup"},
+		{gophercloud.AuthOptions{}, "unknown"},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, authOperator(&tt.authOpts))
+	}
+
+	// As resolved from clouds.yaml, regardless of OS_* env vars
+	newFakeCloud(t)
+	t.Setenv("OS_USERNAME", "other")
+	osClient, err := NewOSClient(CloudConfig{Cloud: "fake"})
+	require.NoError(t, err)
+	require.Equal(t, "admin", osClient.Operator())
+	require.Equal(t, "admin", NewMultiClient(osClient, osClient).Operator())
+}
@@ -1,28 +1,66 @@
 package openstack
 
 import (
+	"context"
 	"fmt"
 	"time"
 
+	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/extendedstatus"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/resetstate"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/startstop"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/tags"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 )
 
-type OSResourceInterface interface {
+// Lister is implemented by every resource kind, enough to filter and list it
+type Lister interface {
+	GetKind() string
 	GetData() (string, string, string)
-	Stop() error
-	Start() error
-	Delete() error
 	GetTags() []string
-	Tag(string) error
-	Untag(string) error
 	String() string
 	StringAll() string
 	GetProjectName() string
+	GetProjectID() string
+	GetCloud() string
+	GetRegion() string
+	GetEmail() string
+	GetCreated() time.Time
+	GetUpdated() time.Time
 	CreatedBefore(time.Time) bool
 	GetRow() []interface{}
+	GetFields() map[string]interface{}
+	GetSearchFields() []SearchField
+}
+
+type Deleter interface {
+	Delete(ctx context.Context) error
+}
+
+// PowerStateRunning as returned by PowerController.GetPowerState()
+const PowerStateRunning = "RUNNING"
+
+type PowerController interface {
+	Stop(ctx context.Context) error
+	Start(ctx context.Context) error
+	GetPowerState() string
+}
+
+type Tagger interface {
+	Tag(ctx context.Context, tag string) error
+	Untag(ctx context.Context, tag string) error
+}
+
+// Expirer is implemented by resources created with a retention period
+type Expirer interface {
+	Expired(time.Time) bool
+}
+
+// OSResourceInterface is the common denominator for all resource kinds, other
+// actions are optional capabilities to be checked with a type assertion,
+// e.g. `resource.(PowerController)`
+type OSResourceInterface interface {
+	Lister
 }
 
 type Instance struct {
@@ -31,8 +69,13 @@ type Instance struct {
 	InstanceName string    `json:"name"`
 	InstanceID   string    `json:"id"`
 	Created      time.Time `json:"created"`
+	Updated      time.Time `json:"updated"`
 	ProjectName  string    `json:"project"`
+	ProjectID    string    `json:"project_id"`
+	Cloud        string    `json:"cloud"`
+	Region       string    `json:"region"`
 	Email        string    `json:"email"`
+	EmailSource  string    `json:"email_source"`
 	VMState      string    `json:"vmstate"`
 	TaskState    string    `json:"taskstate"`
 	PowerState   string    `json:"powerstate"`
@@ -44,8 +87,83 @@ type ServerWithExt struct {
 	extendedstatus.ServerExtendedStatusExt
 }
 
-func GetRowHeader([]OSResourceInterface) []interface{} {
-	return []interface{}{"Instance_Name", "Instance_ID", "Created", "VMState", "PowerState", "TaskState", "Project", "Email", "Tags"}
+func GetRowHeader(resources []OSResourceInterface) []interface{} {
+	if len(resources) > 0 {
+		switch resources[0].(type) {
+		case *Volume:
+			return GetVolumeRowHeader()
+		case *Snapshot:
+			return GetSnapshotRowHeader()
+		case *FloatingIP:
+			return GetFloatingIPRowHeader()
+		case *SafetySnapshot:
+			return GetSafetySnapshotRowHeader()
+		}
+	}
+	return []interface{}{"Instance_Name", "Instance_ID", "Created", "VMState", "PowerState", "TaskState", "Cloud", "Region", "Project", "Email", "Email_Source", "Tags"}
+}
+
+// KindFields returns the GetFields() of an empty resource of kind, e.g. to
+// check --where expressions, nil if kind is unknown
+func KindFields(kind string) map[string]interface{} {
+	resources := []OSResourceInterface{&Instance{}, &Volume{}, &Snapshot{}, &FloatingIP{}, &SafetySnapshot{}}
+	for _, resource := range resources {
+		if resource.GetKind() == kind {
+			return resource.GetFields()
+		}
+	}
+	return nil
+}
+
+// KindSearchFields returns the GetSearchFields() names of kind, e.g. to check
+// --include-field, nil if kind is unknown
+func KindSearchFields(kind string) []string {
+	resources := []OSResourceInterface{&Instance{}, &Volume{}, &Snapshot{}, &FloatingIP{}, &SafetySnapshot{}}
+	for _, resource := range resources {
+		if resource.GetKind() == kind {
+			fields := resource.GetSearchFields()
+			names := make([]string, 0, len(fields))
+			for _, field := range fields {
+				names = append(names, field.Name)
+			}
+			return names
+		}
+	}
+	return nil
+}
+
+// commonSearchFields to all kinds, first in their GetSearchFields()
+func commonSearchFields(resource Lister) []SearchField {
+	id, name, project := resource.GetData()
+	return []SearchField{
+		{"name", []string{name}},
+		{"id", []string{id}},
+		{"project", []string{project}},
+		{"email", []string{resource.GetEmail()}},
+		{"tags", resource.GetTags()},
+	}
+}
+
+// commonFields to all kinds, named as in their JSON output
+func commonFields(resource Lister) map[string]interface{} {
+	id, name, project := resource.GetData()
+	return map[string]interface{}{
+		"kind":       resource.GetKind(),
+		"id":         id,
+		"name":       name,
+		"project":    project,
+		"project_id": resource.GetProjectID(),
+		"cloud":      resource.GetCloud(),
+		"region":     resource.GetRegion(),
+		"email":      resource.GetEmail(),
+		"created":    resource.GetCreated(),
+		"updated":    resource.GetUpdated(),
+		"tags":       resource.GetTags(),
+	}
+}
+
+func (instance *Instance) GetKind() string {
+	return "server"
 }
 
 func (instance *Instance) GetData() (string, string, string) {
@@ -60,34 +178,62 @@ func (instance *Instance) GetRow() []interface{} {
 		instance.VMState,
 		instance.PowerState,
 		instance.TaskState,
+		instance.Cloud,
+		instance.Region,
 		instance.ProjectName,
 		instance.Email,
+		instance.EmailSource,
 		instance.Tags,
 	}
 }
 
-func (instance *Instance) Delete() error {
-	return servers.Delete(instance.osClient.ComputeClient, instance.InstanceID).ExtractErr()
+func (instance *Instance) GetFields() map[string]interface{} {
+	fields := commonFields(instance)
+	fields["email_source"] = instance.EmailSource
+	fields["vm_state"] = instance.VMState
+	fields["task_state"] = instance.TaskState
+	fields["power_state"] = instance.PowerState
+	return fields
+}
+
+// Delete the server, first taking safety snapshots if enabled by
+// WithSnapshotBeforeDelete(), in which case failing to do so aborts deletion
+func (instance *Instance) Delete(ctx context.Context) error {
+	if instance.osClient.snapshotBeforeDel {
+		if err := instance.snapshot(ctx); err != nil {
+			return fmt.Errorf("safety snapshot failed, not deleting: %w", err)
+		}
+	}
+	return instance.waitAction(ctx, func(compute *gophercloud.ServiceClient) error {
+		return servers.Delete(compute, instance.InstanceID).ExtractErr()
+	}, "", resetstate.StateError)
 }
 
-func (instance *Instance) Stop() error {
-	return startstop.Stop(instance.osClient.ComputeClient, instance.InstanceID).ExtractErr()
+func (instance *Instance) Stop(ctx context.Context) error {
+	return instance.waitAction(ctx, func(compute *gophercloud.ServiceClient) error {
+		return startstop.Stop(compute, instance.InstanceID).ExtractErr()
+	}, "stopped", resetstate.StateActive)
 }
 
-func (instance *Instance) Start() error {
-	return startstop.Start(instance.osClient.ComputeClient, instance.InstanceID).ExtractErr()
+func (instance *Instance) Start(ctx context.Context) error {
+	return instance.waitAction(ctx, func(compute *gophercloud.ServiceClient) error {
+		return startstop.Start(compute, instance.InstanceID).ExtractErr()
+	}, "active", "")
 }
 
-func (instance *Instance) Tag(str string) error {
-	return tags.Add(instance.osClient.ComputeClient, instance.InstanceID, str).ExtractErr()
+func (instance *Instance) GetPowerState() string {
+	return instance.PowerState
 }
 
-func (instance *Instance) Untag(str string) error {
-	resp := tags.Delete(instance.osClient.ComputeClient, instance.InstanceID, str)
+func (instance *Instance) Tag(ctx context.Context, str string) error {
+	return tags.Add(instance.osClient.withContext(ctx).ComputeClient, instance.InstanceID, str).ExtractErr()
+}
 
-	err := resp.ExtractErr()
-	if err != nil && resp.StatusCode == 404 {
-		err = nil
+func (instance *Instance) Untag(ctx context.Context, str string) error {
+	err := tags.Delete(instance.osClient.withContext(ctx).ComputeClient, instance.InstanceID, str).ExtractErr()
+	// Already untagged
+	if _, ok := err.(gophercloud.ErrDefault404); ok {
+		return nil
 	}
 	return err
 }
@@ -101,8 +247,26 @@ func (instance *Instance) String() string {
 		instance.InstanceName, instance.InstanceID, instance.ProjectName)
 }
 
+// StringAll returns the searchable string include and exclude regexes match
+// by default, see SearchString()
 func (instance *Instance) StringAll() string {
-	return fmt.Sprintf("%v", instance)
+	return SearchString(instance.GetSearchFields())
+}
+
+// GetSearchFields adds the server metadata, image (name, ID) and flavor
+// (name, ID), as listed by Nova
+func (instance *Instance) GetSearchFields() []SearchField {
+	var metadata, image, flavor []string
+	if instance.Server != nil {
+		metadata = metadataValues(instance.Server.Metadata)
+		image = mapValues(instance.Server.Image, "name", "id")
+		flavor = mapValues(instance.Server.Flavor, "original_name", "id")
+	}
+	return append(commonSearchFields(instance),
+		SearchField{"metadata", metadata},
+		SearchField{"image", image},
+		SearchField{"flavor", flavor},
+	)
 }
 
 func (instance *Instance) GetTags() []string {
@@ -112,3 +276,27 @@ func (instance *Instance) GetTags() []string {
 func (instance *Instance) GetProjectName() string {
 	return instance.ProjectName
 }
+
+func (instance *Instance) GetProjectID() string {
+	return instance.ProjectID
+}
+
+func (instance *Instance) GetCloud() string {
+	return instance.Cloud
+}
+
+func (instance *Instance) GetRegion() string {
+	return instance.Region
+}
+
+func (instance *Instance) GetEmail() string {
+	return instance.Email
+}
+
+func (instance *Instance) GetCreated() time.Time {
+	return instance.Created
+}
+
+func (instance *Instance) GetUpdated() time.Time {
+	return instance.Updated
+}
@@ -0,0 +1,78 @@
+package openstack
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jjo/openstack-ops/pkg/fakeopenstack"
+	"github.com/jjo/openstack-ops/pkg/where"
+)
+
+func TestInstanceActions(t *testing.T) {
+	cloud := newFakeCloud(t)
+	osClient, err := NewOSClient(CloudConfig{Cloud: "fake"})
+	require.NoError(t, err)
+	resources, err := osClient.GetInstances(context.Background(), func(OSResourceInterface) bool { return true })
+	require.NoError(t, err)
+	require.Len(t, resources, 1)
+	instance := resources[0].(*Instance)
+	require.Equal(t, PowerStateRunning, instance.GetPowerState())
+	ctx := context.Background()
+
+	server := func() fakeopenstack.Server {
+		server, ok := cloud.GetServer("s1")
+		require.True(t, ok)
+		return server
+	}
+
+	require.NoError(t, instance.Tag(ctx, "os-cleanup"))
+	require.Equal(t, []string{"tag1", "os-cleanup"}, server().Tags)
+	require.NoError(t, instance.Untag(ctx, "tag1"))
+	require.Equal(t, []string{"os-cleanup"}, server().Tags)
+	// Already gone tags are fine
+	require.NoError(t, instance.Untag(ctx, "tag1"))
+	require.Equal(t, 2, cloud.Requests(http.MethodDelete, fakeopenstack.ComputePath+"servers/s1/tags/tag1"))
+
+	require.NoError(t, instance.Stop(ctx))
+	require.Equal(t, "stopped", server().VMState)
+	require.NoError(t, instance.Start(ctx))
+	require.Equal(t, "active", server().VMState)
+
+	cloud.Fail(fakeopenstack.Failure{
+		Method: http.MethodPost, Path: fakeopenstack.ComputePath + "servers/s1/action", Status: http.StatusConflict,
+	})
+	require.Error(t, instance.Stop(ctx))
+	require.Equal(t, "active", server().VMState)
+
+	require.NoError(t, instance.Delete(ctx))
+	_, ok := cloud.GetServer("s1")
+	require.False(t, ok)
+}
+
+func TestKindFields(t *testing.T) {
+	for _, kind := range []string{"server", "volume", "snapshot", "floatingip", "safety-snapshot"} {
+		fields := KindFields(kind)
+		require.Equal(t, kind, fields["kind"], kind)
+		for _, name := range []string{"id", "name", "project", "created", "tags"} {
+			require.Contains(t, fields, name, kind)
+		}
+	}
+	require.Nil(t, KindFields("foo"))
+
+	expr, err := where.Parse(`project =~ "alumno" && power_state == "RUNNING" && created < now - 90d && !("keep" in tags)`,
+		KindFields("server"))
+	require.NoError(t, err)
+	instance := &Instance{
+		ProjectName: "jdoe__alumno.example.com_project",
+		PowerState:  PowerStateRunning,
+		Created:     time.Now().AddDate(0, 0, -100),
+		Tags:        []string{"os-cleanup"},
+	}
+	require.True(t, expr.Eval(instance.GetFields()))
+	instance.Tags = append(instance.Tags, "keep")
+	require.False(t, expr.Eval(instance.GetFields()))
+}
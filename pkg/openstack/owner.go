@@ -0,0 +1,246 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/roles"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/users"
+	"github.com/gophercloud/gophercloud/pagination"
+	"golang.org/x/exp/slices"
+)
+
+// OwnerResolver finds the email address of a resource owner, an empty
+// string means it couldn't, so that the next resolver can be tried
+type OwnerResolver interface {
+	Name() string
+	ResolveOwner(context.Context, OSResourceInterface) (string, error)
+}
+
+type ownerResolverFunc struct {
+	name string
+	fn   func(OSResourceInterface) string
+}
+
+// NewOwnerResolverFunc adapts a plain function, e.g. a project name rewrite
+func NewOwnerResolverFunc(name string, fn func(OSResourceInterface) string) OwnerResolver {
+	return &ownerResolverFunc{name: name, fn: fn}
+}
+
+func (resolver *ownerResolverFunc) Name() string {
+	return resolver.name
+}
+
+func (resolver *ownerResolverFunc) ResolveOwner(_ context.Context, resource OSResourceInterface) (string, error) {
+	return resolver.fn(resource), nil
+}
+
+var emailRe = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]+`)
+
+// KeystoneExtraResolver reads the project `Key` extra property (e.g. set
+// with `openstack project set --property email=...`), falling back to the
+// first email address found in the project description
+type KeystoneExtraResolver struct {
+	osClient *OSClient
+	Key      string
+}
+
+func NewKeystoneExtraResolver(osClient *OSClient, key string) *KeystoneExtraResolver {
+	return &KeystoneExtraResolver{osClient: osClient, Key: key}
+}
+
+func (resolver *KeystoneExtraResolver) Name() string {
+	return "keystone-extra"
+}
+
+func (resolver *KeystoneExtraResolver) ResolveOwner(_ context.Context, resource OSResourceInterface) (string, error) {
+	project, ok := resolver.osClient.projectsCache[resource.GetProjectID()]
+	if !ok {
+		return "", nil
+	}
+	if email, ok := project.Extra[resolver.Key].(string); ok && email != "" {
+		return email, nil
+	}
+
+	return emailRe.FindString(project.Description), nil
+}
+
+// KeystoneRoleResolver returns the `email` of the users holding `Role` on
+// the resource project, comma separated if more than one
+type KeystoneRoleResolver struct {
+	osClient *OSClient
+	Role     string
+	// mutex guards the maps, not the keystone lookups: these are serialized
+	// per project by projectMutexes instead
+	mutex          sync.Mutex
+	projectMutexes map[string]*sync.Mutex
+	emailCache     map[string]string
+	userCache      map[string]string
+}
+
+func NewKeystoneRoleResolver(osClient *OSClient, role string) *KeystoneRoleResolver {
+	return &KeystoneRoleResolver{
+		osClient:       osClient,
+		Role:           role,
+		projectMutexes: make(map[string]*sync.Mutex),
+		emailCache:     make(map[string]string),
+		userCache:      make(map[string]string),
+	}
+}
+
+func (resolver *KeystoneRoleResolver) Name() string {
+	return "keystone-roles"
+}
+
+// projectMutex returns the mutex serializing the lookups of projectID, so
+// that the resources of a project wait for its first one to be resolved
+func (resolver *KeystoneRoleResolver) projectMutex(projectID string) *sync.Mutex {
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+
+	mutex, ok := resolver.projectMutexes[projectID]
+	if !ok {
+		mutex = &sync.Mutex{}
+		resolver.projectMutexes[projectID] = mutex
+	}
+	return mutex
+}
+
+func (resolver *KeystoneRoleResolver) cachedEmail(projectID string) (string, bool) {
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+
+	email, ok := resolver.emailCache[projectID]
+	return email, ok
+}
+
+func (resolver *KeystoneRoleResolver) ResolveOwner(ctx context.Context, resource OSResourceInterface) (string, error) {
+	projectID := resource.GetProjectID()
+	if projectID == "" {
+		return "", nil
+	}
+
+	projectMutex := resolver.projectMutex(projectID)
+	projectMutex.Lock()
+	defer projectMutex.Unlock()
+
+	if email, ok := resolver.cachedEmail(projectID); ok {
+		return email, nil
+	}
+
+	identity := resolver.osClient.withContext(ctx).IdentityClient
+	effective, includeNames := true, true
+	userIDs := make([]string, 0)
+	err := roles.ListAssignments(identity, roles.ListAssignmentsOpts{
+		ScopeProjectID: projectID,
+		Effective:      &effective,
+		IncludeNames:   &includeNames,
+	}).EachPage(func(page pagination.Page) (bool, error) {
+		assignments, err := roles.ExtractRoleAssignments(page)
+		if err != nil {
+			return false, err
+		}
+		for _, assignment := range assignments {
+			if assignment.Role.Name == resolver.Role && assignment.User.ID != "" {
+				userIDs = append(userIDs, assignment.User.ID)
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("listing role assignments for project %s: %s", projectID, err)
+	}
+
+	emails := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		email, err := resolver.userEmail(identity, userID)
+		if err != nil {
+			return "", err
+		}
+		if email != "" && !slices.Contains(emails, email) {
+			emails = append(emails, email)
+		}
+	}
+	sort.Strings(emails)
+	email := strings.Join(emails, ",")
+
+	resolver.mutex.Lock()
+	resolver.emailCache[projectID] = email
+	resolver.mutex.Unlock()
+
+	return email, nil
+}
+
+// userEmail returns the email of userID, cached as users may own several
+// projects (concurrent lookups of the same user may both hit keystone)
+func (resolver *KeystoneRoleResolver) userEmail(identity *gophercloud.ServiceClient, userID string) (string, error) {
+	resolver.mutex.Lock()
+	email, ok := resolver.userCache[userID]
+	resolver.mutex.Unlock()
+	if ok {
+		return email, nil
+	}
+
+	user, err := users.Get(identity, userID).Extract()
+	if err != nil {
+		return "", fmt.Errorf("getting user %s: %s", userID, err)
+	}
+	email, _ = user.Extra["email"].(string)
+
+	resolver.mutex.Lock()
+	resolver.userCache[userID] = email
+	resolver.mutex.Unlock()
+
+	return email, nil
+}
+
+// ChainResolver tries each resolver in order, first non-empty email wins
+type ChainResolver []OwnerResolver
+
+func (chain ChainResolver) Name() string {
+	names := make([]string, 0, len(chain))
+	for _, resolver := range chain {
+		names = append(names, resolver.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (chain ChainResolver) ResolveOwner(ctx context.Context, resource OSResourceInterface) (string, error) {
+	email, _ := chain.Resolve(ctx, resource)
+	return email, nil
+}
+
+// Resolve also returns the name of the resolver which found the email,
+// failing resolvers are logged and skipped
+func (chain ChainResolver) Resolve(ctx context.Context, resource OSResourceInterface) (string, string) {
+	for _, resolver := range chain {
+		email, err := resolver.ResolveOwner(ctx, resource)
+		if err != nil {
+			log.Errorf("Resolving owner of %s with %s: %s", resource.String(), resolver.Name(), err)
+			continue
+		}
+		if email != "" {
+			return email, resolver.Name()
+		}
+	}
+	return "", ""
+}
+
+// resolveOwner returns the owner email for resource, and the name of the
+// resolver which produced it
+func (osClient *OSClient) resolveOwner(ctx context.Context, resource OSResourceInterface) (string, string) {
+	if osClient.ownerResolver == nil {
+		return "", ""
+	}
+
+	chain, ok := osClient.ownerResolver.(ChainResolver)
+	if !ok {
+		chain = ChainResolver{osClient.ownerResolver}
+	}
+	return chain.Resolve(ctx, resource)
+}
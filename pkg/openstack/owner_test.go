@@ -0,0 +1,110 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/projects"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jjo/openstack-ops/pkg/fakeopenstack"
+)
+
+type failingResolver struct{}
+
+func (failingResolver) Name() string { return "failing" }
+
+func (failingResolver) ResolveOwner(context.Context, OSResourceInterface) (string, error) {
+	return "", fmt.Errorf("boom")
+}
+
+func TestChainResolver(t *testing.T) {
+	osClient := &OSClient{
+		projectsCache: map[string]projects.Project{
+			"p1": {ID: "p1", Name: "jdoe__example.com_project", Extra: map[string]interface{}{"email": "owner@example.com"}},
+			"p2": {ID: "p2", Name: "course", Description: "Owned by Prof. X <prof.x@example.edu>"},
+			"p3": {ID: "p3", Name: "misc"},
+		},
+	}
+	chain := ChainResolver{
+		failingResolver{},
+		NewKeystoneExtraResolver(osClient, "email"),
+		NewOwnerResolverFunc("regex", func(r OSResourceInterface) string {
+			if r.GetProjectName() == "misc" {
+				return "misc@example.com"
+			}
+			return ""
+		}),
+	}
+	osClient.ownerResolver = chain
+
+	tests := []struct {
+		projectID  string
+		wantEmail  string
+		wantSource string
+	}{
+		{"p1", "owner@example.com", "keystone-extra"},
+		{"p2", "prof.x@example.edu", "keystone-extra"},
+		{"p3", "misc@example.com", "regex"},
+		{"unknown", "", ""},
+	}
+
+	for _, tt := range tests {
+		instance := &Instance{
+			ProjectID:   tt.projectID,
+			ProjectName: osClient.projectName(tt.projectID),
+		}
+		email, source := osClient.resolveOwner(context.Background(), instance)
+		require.Equal(t, tt.wantEmail, email, tt.projectID)
+		require.Equal(t, tt.wantSource, source, tt.projectID)
+	}
+	require.Equal(t, "failing,keystone-extra,regex", chain.Name())
+}
+
+func TestKeystoneRoleResolver(t *testing.T) {
+	cloud := newFakeCloud(t)
+	cloud.AddProject(fakeopenstack.Project{ID: "p2", Name: "other"})
+	cloud.AddUser(fakeopenstack.User{ID: "u1", Name: "jdoe", Email: "jdoe@bar.com"})
+	cloud.AddUser(fakeopenstack.User{ID: "u2", Name: "admin", Email: "admin@bar.com"})
+	cloud.AddUser(fakeopenstack.User{ID: "u3", Name: "member", Email: "member@bar.com"})
+	cloud.AddRoleAssignment(fakeopenstack.RoleAssignment{ProjectID: "p1", UserID: "u2", Role: "admin"})
+	cloud.AddRoleAssignment(fakeopenstack.RoleAssignment{ProjectID: "p1", UserID: "u1", Role: "admin"})
+	cloud.AddRoleAssignment(fakeopenstack.RoleAssignment{ProjectID: "p1", UserID: "u3", Role: "member"})
+	cloud.AddRoleAssignment(fakeopenstack.RoleAssignment{ProjectID: "p2", UserID: "u3", Role: "member"})
+
+	osClient, err := NewOSClient(CloudConfig{Cloud: "fake"})
+	require.NoError(t, err)
+	resolver := NewKeystoneRoleResolver(osClient, "admin")
+
+	tests := []struct {
+		projectID string
+		wantEmail string
+	}{
+		{"p1", "admin@bar.com,jdoe@bar.com"},
+		{"p2", ""},
+		{"", ""},
+	}
+	// Resources of the same project resolved concurrently share its lookup
+	const perProject = 5
+	emails := make([]string, len(tests)*perProject)
+	errs := make([]error, len(tests)*perProject)
+	var wg sync.WaitGroup
+	for i := range emails {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			instance := &Instance{ProjectID: tests[i/perProject].projectID}
+			emails[i], errs[i] = resolver.ResolveOwner(context.Background(), instance)
+		}(i)
+	}
+	wg.Wait()
+	for i := range emails {
+		tt := tests[i/perProject]
+		require.NoError(t, errs[i], tt.projectID)
+		require.Equal(t, tt.wantEmail, emails[i], tt.projectID)
+	}
+	require.Equal(t, 2, cloud.Requests("GET", fakeopenstack.IdentityPath+"role_assignments"))
+	require.Equal(t, 2, cloud.Requests("GET", fakeopenstack.IdentityPath+"users/*"))
+}
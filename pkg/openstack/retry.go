@@ -0,0 +1,194 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy tells which API responses are transient, and how long to wait
+// before retrying them
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// RequestTimeout bounds each attempt (0: none), the overall time being
+	// bound by the request context
+	RequestTimeout time.Duration
+	// RetryStatus are retried for any method: 409 (e.g. task_state busy),
+	// 429 and 503 all mean the request wasn't processed
+	RetryStatus map[int]bool
+	// IdempotentStatus are retried only for GET, HEAD, PUT and DELETE, as
+	// well as transport errors (e.g. connection reset)
+	IdempotentStatus map[int]bool
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   time.Second,
+		MaxDelay:    30 * time.Second,
+		RetryStatus: map[int]bool{
+			http.StatusConflict:           true,
+			http.StatusTooManyRequests:    true,
+			http.StatusServiceUnavailable: true,
+		},
+		IdempotentStatus: map[int]bool{
+			http.StatusInternalServerError: true,
+			http.StatusBadGateway:          true,
+			http.StatusGatewayTimeout:      true,
+		},
+	}
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// ShouldRetry tells whether a request with method, which got either resp or
+// err, is worth retrying
+func (policy RetryPolicy) ShouldRetry(method string, resp *http.Response, err error) bool {
+	if err != nil {
+		return isIdempotent(method)
+	}
+	return policy.RetryStatus[resp.StatusCode] ||
+		(isIdempotent(method) && policy.IdempotentStatus[resp.StatusCode])
+}
+
+// Backoff returns the delay before retry number `attempt` (1 for the first
+// one): exponential from BaseDelay up to MaxDelay with jitter, or longer if
+// the response Retry-After asks so
+func (policy RetryPolicy) Backoff(attempt int, resp *http.Response) time.Duration {
+	delay := policy.BaseDelay << (attempt - 1)
+	if delay > policy.MaxDelay || delay <= 0 {
+		delay = policy.MaxDelay
+	}
+	// "Equal jitter": somewhere between half and all of it
+	if half := int64(delay / 2); half > 0 {
+		delay = time.Duration(half + rand.Int63n(half+1))
+	}
+
+	if retryAfter := parseRetryAfter(resp, time.Now()); retryAfter > delay {
+		delay = retryAfter
+	}
+	return delay
+}
+
+// parseRetryAfter accepts both delay-seconds and HTTP-date values
+func parseRetryAfter(resp *http.Response, now time.Time) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// Transport wraps next (http.DefaultTransport if nil) retrying as per policy
+func (policy RetryPolicy) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &retryTransport{policy: policy, next: next, sleep: sleepContext}
+}
+
+type retryTransport struct {
+	policy RetryPolicy
+	next   http.RoundTripper
+	sleep  func(context.Context, time.Duration) error
+}
+
+// cancelBody cancels the attempt context once the response body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (body *cancelBody) Close() error {
+	defer body.cancel()
+	return body.ReadCloser.Close()
+}
+
+// roundTrip is a single attempt, bound by policy.RequestTimeout
+func (transport *retryTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	if transport.policy.RequestTimeout <= 0 {
+		return transport.next.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), transport.policy.RequestTimeout)
+	resp, err := transport.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return resp, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+func (transport *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	policy := transport.policy
+	current := req
+	for attempt := 1; ; attempt++ {
+		resp, err := transport.roundTrip(current)
+		// Nothing to retry if the whole request was canceled or timed out
+		if attempt >= policy.MaxAttempts || req.Context().Err() != nil || !policy.ShouldRetry(req.Method, resp, err) {
+			return resp, err
+		}
+		// Can't retry if the body can't be sent again
+		if req.Body != nil && req.GetBody == nil {
+			return resp, err
+		}
+
+		var reason string
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+		}
+		delay := policy.Backoff(attempt, resp)
+		log.Warningf("Retrying %s %s in %s (attempt %d/%d): %s",
+			req.Method, req.URL.Redacted(), delay.Round(time.Millisecond), attempt+1, policy.MaxAttempts, reason)
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+		// RoundTrippers must not modify the request, retry with a copy
+		current = req.Clone(req.Context())
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("rewinding request body for retry: %w", err)
+			}
+			current.Body = body
+		}
+		if err := transport.sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// WithRetryPolicy makes all API requests (of every service client) retry
+// transient errors as per policy
+func (osClient *OSClient) WithRetryPolicy(policy RetryPolicy) OSClientInterface {
+	log.Debugf("Setting retry policy to: max attempts %d, base delay %s, max delay %s, request timeout %s",
+		policy.MaxAttempts, policy.BaseDelay, policy.MaxDelay, policy.RequestTimeout)
+	if policy.MaxAttempts > 1 || policy.RequestTimeout > 0 {
+		osClient.ProviderClient.HTTPClient.Transport = policy.Transport(osClient.ProviderClient.HTTPClient.Transport)
+	}
+	return osClient
+}
@@ -0,0 +1,154 @@
+package openstack
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// flakyServer replies each request with the next status from statuses (the
+// last one repeating), recording the request bodies it got
+type flakyServer struct {
+	mutex      sync.Mutex
+	statuses   []int
+	retryAfter string
+	bodies     []string
+}
+
+func (flaky *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flaky.mutex.Lock()
+	defer flaky.mutex.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	flaky.bodies = append(flaky.bodies, string(body))
+	status := flaky.statuses[0]
+	if len(flaky.statuses) > 1 {
+		flaky.statuses = flaky.statuses[1:]
+	}
+	if flaky.retryAfter != "" {
+		w.Header().Set("Retry-After", flaky.retryAfter)
+	}
+	w.WriteHeader(status)
+}
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		statuses   []int
+		retryAfter string
+		wantStatus int
+		wantCalls  int
+		wantDelay  time.Duration
+	}{
+		{"ok", http.MethodGet, []int{200}, "", 200, 1, 0},
+		{"409 then ok", http.MethodPost, []int{409, 202}, "", 202, 2, 0},
+		{"503 until max attempts", http.MethodDelete, []int{503}, "", 503, 3, 0},
+		{"404 is final", http.MethodGet, []int{404, 200}, "", 404, 1, 0},
+		{"500 retried for GET", http.MethodGet, []int{500, 200}, "", 200, 2, 0},
+		{"500 final for POST", http.MethodPost, []int{500, 200}, "", 500, 1, 0},
+		{"429 honors Retry-After", http.MethodGet, []int{429, 200}, "7", 200, 2, 7 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flaky := &flakyServer{statuses: tt.statuses, retryAfter: tt.retryAfter}
+			server := httptest.NewServer(flaky)
+			defer server.Close()
+
+			policy := DefaultRetryPolicy()
+			policy.MaxAttempts = 3
+			policy.BaseDelay = time.Millisecond
+			policy.MaxDelay = 10 * time.Millisecond
+			var delays []time.Duration
+			transport := policy.Transport(nil).(*retryTransport)
+			transport.sleep = func(_ context.Context, d time.Duration) error {
+				delays = append(delays, d)
+				return nil
+			}
+
+			req, err := http.NewRequest(tt.method, server.URL, strings.NewReader(`{"os-stop":null}`))
+			require.NoError(t, err)
+			resp, err := (&http.Client{Transport: transport}).Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			require.Equal(t, tt.wantStatus, resp.StatusCode)
+			require.Len(t, flaky.bodies, tt.wantCalls)
+			for _, body := range flaky.bodies {
+				require.Equal(t, `{"os-stop":null}`, body, "body resent on retries")
+			}
+			require.Len(t, delays, tt.wantCalls-1)
+			for _, delay := range delays {
+				require.LessOrEqual(t, delay, policy.MaxDelay+tt.wantDelay)
+				require.GreaterOrEqual(t, delay, tt.wantDelay)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 8 * time.Second}
+	for attempt, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second} {
+		delay := policy.Backoff(attempt+1, nil)
+		require.GreaterOrEqual(t, delay, max/2, "attempt %d", attempt+1)
+		require.LessOrEqual(t, delay, max, "attempt %d", attempt+1)
+	}
+
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set("Retry-After", now.Add(90*time.Second).Format(http.TimeFormat))
+	require.Equal(t, 90*time.Second, parseRetryAfter(resp, now))
+	resp.Header.Set("Retry-After", "garbage")
+	require.Equal(t, time.Duration(0), parseRetryAfter(resp, now))
+}
+
+func TestRetryTransportTimeouts(t *testing.T) {
+	var mutex sync.Mutex
+	calls := 0
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		calls++
+		slow := calls == 1
+		mutex.Unlock()
+		if slow {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	policy := DefaultRetryPolicy()
+	policy.MaxAttempts = 3
+	policy.BaseDelay = time.Millisecond
+	policy.MaxDelay = time.Millisecond
+	policy.RequestTimeout = 20 * time.Millisecond
+	client := &http.Client{Transport: policy.Transport(nil)}
+
+	// A hung attempt times out and is retried
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 2, calls)
+
+	// Nothing is retried once the whole request is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	_, err = client.Do(req)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 2, calls)
+}
@@ -0,0 +1,377 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/snapshots"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/gophercloud/gophercloud/pagination"
+)
+
+// Safety snapshots (Glance images and Cinder snapshots taken before deleting
+// a server) carry these metadata keys, SafetyExpiresKey marks them as such
+const (
+	SafetyExpiresKey   = "os-cleanup:expires"
+	SafetyOwnerKey     = "os-cleanup:owner"
+	SafetyProjectKey   = "os-cleanup:project"
+	SafetyProjectIDKey = "os-cleanup:project_id"
+	SafetyServerKey    = "os-cleanup:server"
+
+	SafetyTypeImage          = "image"
+	SafetyTypeVolumeSnapshot = "volume-snapshot"
+)
+
+// SafetySnapshot is either the Glance image or the Cinder snapshot of one of
+// the attached volumes, taken by --snapshot-before-delete
+type SafetySnapshot struct {
+	osClient     *OSClient
+	Type         string    `json:"type"`
+	SnapshotName string    `json:"name"`
+	SnapshotID   string    `json:"id"`
+	Created      time.Time `json:"created"`
+	Updated      time.Time `json:"updated"`
+	Expires      time.Time `json:"expires"`
+	ServerID     string    `json:"server_id"`
+	ProjectName  string    `json:"project"`
+	ProjectID    string    `json:"project_id"`
+	Cloud        string    `json:"cloud"`
+	Region       string    `json:"region"`
+	Email        string    `json:"email"`
+	Status       string    `json:"status"`
+}
+
+func GetSafetySnapshotRowHeader() []interface{} {
+	return []interface{}{"Snapshot_Name", "Snapshot_ID", "Type", "Created", "Expires", "Status", "Server_ID", "Cloud", "Region", "Project", "Email"}
+}
+
+func (osClient *OSClient) withImageClient() (*OSClient, error) {
+	if osClient.ImageClient != nil {
+		return osClient, nil
+	}
+
+	imageClient, err := openstack.NewImageServiceV2(osClient.ProviderClient, osClient.endpointOpts)
+	if err != nil {
+		return nil, newError(ErrEndpoint, "create Image service client", err)
+	}
+	osClient.ImageClient = imageClient
+
+	return osClient, nil
+}
+
+// WithSnapshotBeforeDelete makes Instance.Delete() first take safety
+// snapshots, to be kept for retentionDays, waiting up to timeout for them
+func (osClient *OSClient) WithSnapshotBeforeDelete(retentionDays int, timeout time.Duration) OSClientInterface {
+	log.Debugf("Setting snapshot before delete retention to: %d days", retentionDays)
+	osClient.snapshotBeforeDel = true
+	osClient.snapshotRetention = retentionDays
+	osClient.snapshotTimeout = timeout
+	return osClient
+}
+
+func (instance *Instance) safetyMetadata(expires time.Time) map[string]string {
+	return map[string]string{
+		SafetyExpiresKey:   expires.UTC().Format(time.DateOnly),
+		SafetyOwnerKey:     instance.Email,
+		SafetyProjectKey:   instance.ProjectName,
+		SafetyProjectIDKey: instance.ProjectID,
+		SafetyServerKey:    instance.InstanceID,
+	}
+}
+
+// snapshot creates a Glance image of the server and Cinder snapshots of its
+// attached volumes, waiting for all of them to be usable
+func (instance *Instance) snapshot(ctx context.Context) error {
+	osClient, err := instance.osClient.withImageClient()
+	if err != nil {
+		return err
+	}
+	osClient, err = osClient.withBlockStorageClient()
+	if err != nil {
+		return err
+	}
+	osClient = osClient.withContext(ctx)
+
+	now := time.Now()
+	metadata := instance.safetyMetadata(now.AddDate(0, 0, osClient.snapshotRetention))
+	name := fmt.Sprintf("os-cleanup-%s-%s", instance.InstanceName, now.UTC().Format(time.DateOnly))
+
+	imageID, err := servers.CreateImage(osClient.ComputeClient, instance.InstanceID, servers.CreateImageOpts{
+		Name:     name,
+		Metadata: metadata,
+	}).ExtractImageID()
+	if err != nil {
+		return fmt.Errorf("creating image of server %s: %s", instance.InstanceID, err)
+	}
+	log.Infof("Created safety image %s for %s", imageID, instance.String())
+
+	snapshotIDs := make([]string, 0)
+	if instance.Server != nil {
+		for _, volume := range instance.Server.AttachedVolumes {
+			snapshot, err := snapshots.Create(osClient.BlockStorageClient, snapshots.CreateOpts{
+				VolumeID: volume.ID,
+				Name:     name + "-" + volume.ID,
+				Force:    true,
+				Metadata: metadata,
+			}).Extract()
+			if err != nil {
+				return fmt.Errorf("creating snapshot of volume %s: %s", volume.ID, err)
+			}
+			log.Infof("Created safety snapshot %s of volume %s for %s", snapshot.ID, volume.ID, instance.String())
+			snapshotIDs = append(snapshotIDs, snapshot.ID)
+		}
+	}
+
+	err = waitFor(ctx, "image "+imageID, osClient.snapshotTimeout, func() (bool, error) {
+		image, err := images.Get(osClient.ImageClient, imageID).Extract()
+		if err != nil {
+			return false, err
+		}
+		switch image.Status {
+		case images.ImageStatusActive:
+			return true, nil
+		case images.ImageStatusKilled, images.ImageStatusDeleted:
+			return false, fmt.Errorf("image %s is %s", imageID, image.Status)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, snapshotID := range snapshotIDs {
+		snapshotID := snapshotID
+		err = waitFor(ctx, "snapshot "+snapshotID, osClient.snapshotTimeout, func() (bool, error) {
+			snapshot, err := snapshots.Get(osClient.BlockStorageClient, snapshotID).Extract()
+			if err != nil {
+				return false, err
+			}
+			switch snapshot.Status {
+			case "available":
+				return true, nil
+			case "error":
+				return false, fmt.Errorf("snapshot %s is in error", snapshotID)
+			}
+			return false, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func parseExpires(value string) (time.Time, bool) {
+	expires, err := time.Parse(time.DateOnly, value)
+	return expires, err == nil
+}
+
+// GetSafetySnapshots returns filtered safety images and volume snapshots,
+// expired or not, see Expired(). Their owner is the one recorded when taken,
+// else resolved as for other resources
+func (osClient *OSClient) GetSafetySnapshots(
+	ctx context.Context, filter func(OSResourceInterface) bool,
+) ([]OSResourceInterface, error) {
+	osClient, err := osClient.withProjectsCache(ctx)
+	if err != nil {
+		return nil, err
+	}
+	osClient, err = osClient.withImageClient()
+	if err != nil {
+		return nil, err
+	}
+	osClient, err = osClient.withBlockStorageClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]OSResourceInterface, 0)
+	add := func(safety *SafetySnapshot) {
+		if safety.ProjectName == "" {
+			safety.ProjectName = osClient.projectName(safety.ProjectID)
+		}
+		if safety.Email == "" {
+			safety.Email, _ = osClient.resolveOwner(ctx, safety)
+		}
+		if filter(safety) {
+			resources = append(resources, safety)
+		}
+	}
+
+	err = images.List(osClient.withContext(ctx).ImageClient, images.ListOpts{
+		Visibility: images.ImageVisibility("all"),
+	}).EachPage(func(page pagination.Page) (bool, error) {
+		imageList, err := images.ExtractImages(page)
+		if err != nil {
+			return false, err
+		}
+		for _, image := range imageList {
+			value, _ := image.Properties[SafetyExpiresKey].(string)
+			expires, ok := parseExpires(value)
+			if !ok {
+				continue
+			}
+			str := func(key string) string {
+				s, _ := image.Properties[key].(string)
+				return s
+			}
+			add(&SafetySnapshot{
+				osClient:     osClient,
+				Cloud:        osClient.cloud,
+				Region:       osClient.endpointOpts.Region,
+				Type:         SafetyTypeImage,
+				SnapshotName: image.Name,
+				SnapshotID:   image.ID,
+				Created:      image.CreatedAt,
+				Updated:      image.UpdatedAt,
+				Expires:      expires,
+				ServerID:     str(SafetyServerKey),
+				ProjectName:  str(SafetyProjectKey),
+				ProjectID:    str(SafetyProjectIDKey),
+				Email:        str(SafetyOwnerKey),
+				Status:       string(image.Status),
+			})
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, listError("images", err)
+	}
+
+	err = snapshots.List(osClient.withContext(ctx).BlockStorageClient, snapshots.ListOpts{
+		AllTenants: true,
+	}).EachPage(func(page pagination.Page) (bool, error) {
+		snapshotList, err := snapshots.ExtractSnapshots(page)
+		if err != nil {
+			return false, err
+		}
+		for _, snapshot := range snapshotList {
+			expires, ok := parseExpires(snapshot.Metadata[SafetyExpiresKey])
+			if !ok {
+				continue
+			}
+			add(&SafetySnapshot{
+				osClient:     osClient,
+				Cloud:        osClient.cloud,
+				Region:       osClient.endpointOpts.Region,
+				Type:         SafetyTypeVolumeSnapshot,
+				SnapshotName: snapshot.Name,
+				SnapshotID:   snapshot.ID,
+				Created:      snapshot.CreatedAt,
+				Updated:      snapshot.UpdatedAt,
+				Expires:      expires,
+				ServerID:     snapshot.Metadata[SafetyServerKey],
+				ProjectName:  snapshot.Metadata[SafetyProjectKey],
+				ProjectID:    snapshot.Metadata[SafetyProjectIDKey],
+				Email:        snapshot.Metadata[SafetyOwnerKey],
+				Status:       snapshot.Status,
+			})
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, listError("snapshots", err)
+	}
+
+	return resources, nil
+}
+
+func (safety *SafetySnapshot) GetKind() string {
+	return "safety-snapshot"
+}
+
+func (safety *SafetySnapshot) GetData() (string, string, string) {
+	return safety.SnapshotID, safety.SnapshotName, safety.ProjectName
+}
+
+func (safety *SafetySnapshot) GetRow() []interface{} {
+	return []interface{}{
+		safety.SnapshotName,
+		safety.SnapshotID,
+		safety.Type,
+		safety.Created,
+		safety.Expires,
+		safety.Status,
+		safety.ServerID,
+		safety.Cloud,
+		safety.Region,
+		safety.ProjectName,
+		safety.Email,
+	}
+}
+
+func (safety *SafetySnapshot) GetFields() map[string]interface{} {
+	fields := commonFields(safety)
+	fields["type"] = safety.Type
+	fields["status"] = safety.Status
+	fields["expires"] = safety.Expires
+	fields["server_id"] = safety.ServerID
+	return fields
+}
+
+func (safety *SafetySnapshot) Delete(ctx context.Context) error {
+	if safety.Type == SafetyTypeImage {
+		return images.Delete(safety.osClient.withContext(ctx).ImageClient, safety.SnapshotID).ExtractErr()
+	}
+	return snapshots.Delete(safety.osClient.withContext(ctx).BlockStorageClient, safety.SnapshotID).ExtractErr()
+}
+
+// Expired tells whether retention is over as of `t`
+func (safety *SafetySnapshot) Expired(t time.Time) bool {
+	return !t.Before(safety.Expires)
+}
+
+func (safety *SafetySnapshot) CreatedBefore(t time.Time) bool {
+	return safety.Created.Before(t)
+}
+
+func (safety *SafetySnapshot) String() string {
+	return fmt.Sprintf("Kind: SafetySnapshot Type: %s Name: %s ID: %s Project: %s",
+		safety.Type, safety.SnapshotName, safety.SnapshotID, safety.ProjectName)
+}
+
+// StringAll returns the searchable string include and exclude regexes match
+// by default, see SearchString()
+func (safety *SafetySnapshot) StringAll() string {
+	return SearchString(safety.GetSearchFields())
+}
+
+func (safety *SafetySnapshot) GetSearchFields() []SearchField {
+	return commonSearchFields(safety)
+}
+
+func (safety *SafetySnapshot) GetTags() []string {
+	return []string{}
+}
+
+func (safety *SafetySnapshot) GetProjectName() string {
+	return safety.ProjectName
+}
+
+func (safety *SafetySnapshot) GetProjectID() string {
+	return safety.ProjectID
+}
+
+func (safety *SafetySnapshot) GetCloud() string {
+	return safety.Cloud
+}
+
+func (safety *SafetySnapshot) GetRegion() string {
+	return safety.Region
+}
+
+func (safety *SafetySnapshot) GetEmail() string {
+	return safety.Email
+}
+
+func (safety *SafetySnapshot) GetCreated() time.Time {
+	return safety.Created
+}
+
+func (safety *SafetySnapshot) GetUpdated() time.Time {
+	return safety.Updated
+}
@@ -0,0 +1,158 @@
+package openstack
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jjo/openstack-ops/pkg/fakeopenstack"
+)
+
+func TestInstanceSnapshotBeforeDelete(t *testing.T) {
+	pollInterval = time.Millisecond
+
+	tests := []struct {
+		name             string
+		imageStatuses    []string
+		snapshotStatuses []string
+		failAction       bool
+		wantErr          string
+	}{
+		{"ok", []string{"queued", "saving", "active"}, []string{"creating", "available"}, false, ""},
+		{"image killed", []string{"saving", "killed"}, nil, false, "image image-1 is killed"},
+		{"snapshot error", nil, []string{"creating", "error"}, false, "snapshot snapshot-2 is in error"},
+		{"image timeout", []string{"saving"}, nil, false, "timeout after 20ms waiting for image image-1"},
+		{"snapshot timeout", nil, []string{"creating"}, false, "timeout after 20ms waiting for snapshot snapshot-2"},
+		{"create image failure", nil, nil, true, "creating image of server s1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cloud := newFakeCloud(t)
+			cloud.AddVolume(fakeopenstack.Volume{ID: "v1", ProjectID: "p1", Size: 10, AttachedTo: []string{"s1"}})
+			if tt.imageStatuses != nil {
+				cloud.CreatedImageStatuses = tt.imageStatuses
+			}
+			if tt.snapshotStatuses != nil {
+				cloud.CreatedSnapshotStatuses = tt.snapshotStatuses
+			}
+			if tt.failAction {
+				cloud.Fail(fakeopenstack.Failure{
+					Method: http.MethodPost, Path: fakeopenstack.ComputePath + "servers/s1/action", Status: http.StatusConflict,
+				})
+			}
+
+			osClient, err := NewOSClient(CloudConfig{Cloud: "fake"})
+			require.NoError(t, err)
+			osClient.WithSnapshotBeforeDelete(7, 20*time.Millisecond)
+			resources, err := osClient.GetInstances(context.Background(), func(OSResourceInterface) bool { return true })
+			require.NoError(t, err)
+			require.Len(t, resources, 1)
+			instance := resources[0].(*Instance)
+			instance.Email = "foo@bar.com"
+
+			err = instance.Delete(context.Background())
+			_, exists := cloud.GetServer("s1")
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, "safety snapshot failed, not deleting")
+				require.ErrorContains(t, err, tt.wantErr)
+				require.True(t, exists)
+				return
+			}
+			require.NoError(t, err)
+			require.False(t, exists)
+
+			expires := time.Now().AddDate(0, 0, 7).UTC().Format(time.DateOnly)
+			wantMetadata := map[string]string{
+				SafetyExpiresKey:   expires,
+				SafetyOwnerKey:     "foo@bar.com",
+				SafetyProjectKey:   "foo__bar.com_project",
+				SafetyProjectIDKey: "p1",
+				SafetyServerKey:    "s1",
+			}
+			images := cloud.Images()
+			require.Len(t, images, 1)
+			require.Equal(t, "active", images[0].Status)
+			require.Equal(t, "p1", images[0].ProjectID)
+			for key, value := range wantMetadata {
+				require.Equal(t, value, images[0].Properties[key], key)
+			}
+			snapshots := cloud.Snapshots()
+			require.Len(t, snapshots, 1)
+			require.Equal(t, "v1", snapshots[0].VolumeID)
+			require.Equal(t, "available", snapshots[0].Status)
+			require.Equal(t, wantMetadata, snapshots[0].Metadata)
+		})
+	}
+}
+
+func TestGetSafetySnapshots(t *testing.T) {
+	now := time.Now()
+	cloud := newFakeCloud(t)
+	safety := func(expires time.Time) map[string]string {
+		return map[string]string{
+			SafetyExpiresKey:   expires.UTC().Format(time.DateOnly),
+			SafetyProjectKey:   "foo__bar.com_project",
+			SafetyProjectIDKey: "p1",
+			SafetyServerKey:    "s0",
+		}
+	}
+	owned := safety(now.AddDate(0, 0, -1))
+	owned[SafetyOwnerKey] = "owner@bar.com"
+	cloud.AddImage(fakeopenstack.Image{ID: "i1", Name: "expired", ProjectID: "p1", Properties: owned})
+	cloud.AddImage(fakeopenstack.Image{ID: "i2", Name: "ubuntu", ProjectID: "p1"})
+	cloud.AddImage(fakeopenstack.Image{ID: "i3", Name: "kept", ProjectID: "p1", Properties: safety(now.AddDate(0, 0, 1))})
+	// Project name from keystone if not recorded
+	unnamed := safety(now)
+	delete(unnamed, SafetyProjectKey)
+	cloud.AddSnapshot(fakeopenstack.Snapshot{ID: "vs1", Name: "expired", ProjectID: "p1", Metadata: unnamed})
+	cloud.AddSnapshot(fakeopenstack.Snapshot{ID: "vs2", Name: "backup", ProjectID: "p1"})
+
+	osClient, err := NewOSClient(CloudConfig{Cloud: "fake"})
+	require.NoError(t, err)
+	// Only for those with no owner recorded
+	osClient.WithProjectToEmail(NewOwnerResolverFunc("regex", func(resource OSResourceInterface) string {
+		return "resolved@" + resource.GetProjectName()
+	}))
+	resources, err := osClient.GetSafetySnapshots(context.Background(), func(OSResourceInterface) bool { return true })
+	require.NoError(t, err)
+
+	// Only safety snapshots are listed, expired or not
+	expired := make(map[string]bool)
+	emails := make(map[string]string)
+	for _, resource := range resources {
+		safety := resource.(*SafetySnapshot)
+		require.Equal(t, "foo__bar.com_project", safety.ProjectName)
+		require.Equal(t, "s0", safety.ServerID)
+		expired[safety.Type+":"+safety.SnapshotID] = safety.Expired(now)
+		emails[safety.SnapshotID] = safety.Email
+	}
+	require.Equal(t, map[string]bool{
+		SafetyTypeImage + ":i1":           true,
+		SafetyTypeImage + ":i3":           false,
+		SafetyTypeVolumeSnapshot + ":vs1": true,
+	}, expired)
+	require.Equal(t, map[string]string{
+		"i1":  "owner@bar.com",
+		"i3":  "resolved@foo__bar.com_project",
+		"vs1": "resolved@foo__bar.com_project",
+	}, emails)
+
+	// Purged from Glance or Cinder as per type
+	for _, resource := range resources {
+		if resource.(*SafetySnapshot).Expired(now) {
+			require.NoError(t, resource.(Deleter).Delete(context.Background()))
+		}
+	}
+	imageIDs := make([]string, 0)
+	for _, image := range cloud.Images() {
+		imageIDs = append(imageIDs, image.ID)
+	}
+	require.Equal(t, []string{"i2", "i3"}, imageIDs)
+	snapshots := cloud.Snapshots()
+	require.Len(t, snapshots, 1)
+	require.Equal(t, "vs2", snapshots[0].ID)
+}
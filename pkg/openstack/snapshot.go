@@ -0,0 +1,366 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/snapshots"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/gophercloud/gophercloud/pagination"
+)
+
+// Snapshot types, Cinder volume snapshots or Glance images of servers
+// (image_type=snapshot)
+const (
+	SnapshotTypeVolume = "volume-snapshot"
+	SnapshotTypeImage  = "image"
+)
+
+type Snapshot struct {
+	osClient         *OSClient
+	Snapshot         *snapshots.Snapshot
+	Image            *images.Image
+	Type             string    `json:"type"`
+	SnapshotName     string    `json:"name"`
+	SnapshotID       string    `json:"id"`
+	Created          time.Time `json:"created"`
+	Updated          time.Time `json:"updated"`
+	ProjectName      string    `json:"project"`
+	ProjectID        string    `json:"project_id"`
+	Cloud            string    `json:"cloud"`
+	Region           string    `json:"region"`
+	Email            string    `json:"email"`
+	EmailSource      string    `json:"email_source"`
+	Status           string    `json:"status"`
+	Size             int       `json:"size"`
+	VolumeID         string    `json:"volume_id"`
+	DependentVolumes []string  `json:"dependent_volumes"`
+	Blocked          bool      `json:"blocked"`
+	Tags             []string  `json:"tags"`
+}
+
+type SnapshotProjectExt struct {
+	ProjectID string `json:"os-extended-snapshot-attributes:project_id"`
+}
+
+type SnapshotWithExt struct {
+	snapshots.Snapshot
+	SnapshotProjectExt
+}
+
+func GetSnapshotRowHeader() []interface{} {
+	return []interface{}{
+		"Snapshot_Name", "Snapshot_ID", "Type", "Created", "Status", "Size", "Volume_ID",
+		"Dependent_Volumes", "Blocked", "Cloud", "Region", "Project", "Email", "Email_Source", "Tags",
+	}
+}
+
+// getSnapshotDependents maps snapshot IDs to the volumes created from them,
+// Cinder refuses to delete a snapshot while any of these exist
+func (osClient *OSClient) getSnapshotDependents(ctx context.Context) (map[string][]string, error) {
+	dependents := make(map[string][]string)
+	err := volumes.List(osClient.withContext(ctx).BlockStorageClient, volumes.ListOpts{
+		AllTenants: true,
+	}).EachPage(func(page pagination.Page) (bool, error) {
+		volumeList, err := volumes.ExtractVolumes(page)
+		if err != nil {
+			return false, err
+		}
+		for _, volume := range volumeList {
+			if volume.SnapshotID != "" {
+				dependents[volume.SnapshotID] = append(dependents[volume.SnapshotID], volume.ID)
+			}
+		}
+		return true, nil
+	})
+
+	return dependents, err
+}
+
+// GetSnapshots returns filtered volume snapshots and server snapshot images
+// from all projects, but the safety ones (see GetSafetySnapshots)
+func (osClient *OSClient) GetSnapshots(
+	ctx context.Context, filter func(OSResourceInterface) bool,
+) ([]OSResourceInterface, error) {
+	osClient, err := osClient.withProjectsCache(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	osClient, err = osClient.withBlockStorageClient()
+	if err != nil {
+		return nil, err
+	}
+
+	dependents, err := osClient.getSnapshotDependents(ctx)
+	if err != nil {
+		return nil, listError("volumes", err)
+	}
+
+	resources := make([]OSResourceInterface, 0)
+	err = snapshots.List(osClient.withContext(ctx).BlockStorageClient, snapshots.ListOpts{
+		AllTenants: true,
+	}).EachPage(func(page pagination.Page) (bool, error) {
+		var pageSnapshots []SnapshotWithExt
+		// NB: no snapshots.ExtractSnapshotsInto(), needed to get the project_id extension
+		if err := page.(snapshots.SnapshotPage).ExtractIntoSlicePtr(&pageSnapshots, "snapshots"); err != nil {
+			return false, err
+		}
+		for i := range pageSnapshots {
+			// Safety snapshots are purged by their expiry instead
+			if _, ok := pageSnapshots[i].Metadata[SafetyExpiresKey]; ok {
+				continue
+			}
+			snapshot := newSnapshot(ctx, osClient, &pageSnapshots[i], dependents[pageSnapshots[i].ID])
+			if filter(snapshot) {
+				resources = append(resources, snapshot)
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, listError("snapshots", err)
+	}
+
+	osClient, err = osClient.withImageClient()
+	if err != nil {
+		return nil, err
+	}
+	err = images.List(osClient.withContext(ctx).ImageClient, images.ListOpts{
+		Visibility: images.ImageVisibility("all"),
+	}).EachPage(func(page pagination.Page) (bool, error) {
+		imageList, err := images.ExtractImages(page)
+		if err != nil {
+			return false, err
+		}
+		for i := range imageList {
+			// Not uploaded images, but the ones taken of servers
+			if imageType, _ := imageList[i].Properties["image_type"].(string); imageType != "snapshot" {
+				continue
+			}
+			if _, ok := imageList[i].Properties[SafetyExpiresKey]; ok {
+				continue
+			}
+			snapshot := newImageSnapshot(ctx, osClient, &imageList[i])
+			if filter(snapshot) {
+				resources = append(resources, snapshot)
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, listError("images", err)
+	}
+
+	return resources, nil
+}
+
+func newSnapshot(ctx context.Context, osClient *OSClient, s *SnapshotWithExt, dependentVolumes []string) *Snapshot {
+	if dependentVolumes == nil {
+		dependentVolumes = make([]string, 0)
+	}
+	snapshot := &Snapshot{
+		osClient:         osClient,
+		Cloud:            osClient.cloud,
+		Region:           osClient.endpointOpts.Region,
+		Snapshot:         &s.Snapshot,
+		Type:             SnapshotTypeVolume,
+		SnapshotName:     s.Name,
+		SnapshotID:       s.ID,
+		Created:          s.CreatedAt,
+		Updated:          s.UpdatedAt,
+		ProjectName:      osClient.projectName(s.ProjectID),
+		ProjectID:        s.ProjectID,
+		Status:           s.Status,
+		Size:             s.Size,
+		VolumeID:         s.VolumeID,
+		DependentVolumes: dependentVolumes,
+		Blocked:          len(dependentVolumes) > 0,
+		Tags:             metadataTags(s.Metadata),
+	}
+	snapshot.Email, snapshot.EmailSource = osClient.resolveOwner(ctx, snapshot)
+
+	return snapshot
+}
+
+// newImageSnapshot returns the snapshot image of a server, owned by its
+// project, blocked from deletion if protected
+func newImageSnapshot(ctx context.Context, osClient *OSClient, image *images.Image) *Snapshot {
+	snapshot := &Snapshot{
+		osClient:         osClient,
+		Cloud:            osClient.cloud,
+		Region:           osClient.endpointOpts.Region,
+		Image:            image,
+		Type:             SnapshotTypeImage,
+		SnapshotName:     image.Name,
+		SnapshotID:       image.ID,
+		Created:          image.CreatedAt,
+		Updated:          image.UpdatedAt,
+		ProjectName:      osClient.projectName(image.Owner),
+		ProjectID:        image.Owner,
+		Status:           string(image.Status),
+		Size:             int((image.SizeBytes + 1<<30 - 1) >> 30), // GiB as Cinder's
+		DependentVolumes: make([]string, 0),
+		Blocked:          image.Protected,
+		Tags:             image.Tags,
+	}
+	if snapshot.Tags == nil {
+		snapshot.Tags = make([]string, 0)
+	}
+	snapshot.Email, snapshot.EmailSource = osClient.resolveOwner(ctx, snapshot)
+
+	return snapshot
+}
+
+func (snapshot *Snapshot) GetKind() string {
+	return "snapshot"
+}
+
+func (snapshot *Snapshot) GetData() (string, string, string) {
+	return snapshot.SnapshotID, snapshot.SnapshotName, snapshot.ProjectName
+}
+
+func (snapshot *Snapshot) GetRow() []interface{} {
+	return []interface{}{
+		snapshot.SnapshotName,
+		snapshot.SnapshotID,
+		snapshot.Type,
+		snapshot.Created,
+		snapshot.Status,
+		snapshot.Size,
+		snapshot.VolumeID,
+		snapshot.DependentVolumes,
+		snapshot.Blocked,
+		snapshot.Cloud,
+		snapshot.Region,
+		snapshot.ProjectName,
+		snapshot.Email,
+		snapshot.EmailSource,
+		snapshot.Tags,
+	}
+}
+
+func (snapshot *Snapshot) GetFields() map[string]interface{} {
+	fields := commonFields(snapshot)
+	fields["email_source"] = snapshot.EmailSource
+	fields["type"] = snapshot.Type
+	fields["status"] = snapshot.Status
+	fields["size"] = snapshot.Size
+	fields["volume_id"] = snapshot.VolumeID
+	fields["dependent_volumes"] = snapshot.DependentVolumes
+	fields["blocked"] = snapshot.Blocked
+	return fields
+}
+
+func (snapshot *Snapshot) Delete(ctx context.Context) error {
+	if snapshot.Type == SnapshotTypeImage {
+		if snapshot.Blocked {
+			return fmt.Errorf("snapshot image %s is protected", snapshot.SnapshotID)
+		}
+		return images.Delete(snapshot.osClient.withContext(ctx).ImageClient, snapshot.SnapshotID).ExtractErr()
+	}
+	if snapshot.Blocked {
+		return fmt.Errorf("snapshot %s is blocked by dependent volumes: %v", snapshot.SnapshotID, snapshot.DependentVolumes)
+	}
+	return snapshots.Delete(snapshot.osClient.withContext(ctx).BlockStorageClient, snapshot.SnapshotID).ExtractErr()
+}
+
+func (snapshot *Snapshot) Tag(ctx context.Context, str string) error {
+	if snapshot.Type == SnapshotTypeImage {
+		return addImageTag(snapshot.osClient.withContext(ctx).ImageClient, snapshot.SnapshotID, str)
+	}
+	return addMetadataTag(snapshot.osClient.withContext(ctx).BlockStorageClient, "snapshots", snapshot.SnapshotID, str)
+}
+
+func (snapshot *Snapshot) Untag(ctx context.Context, str string) error {
+	if snapshot.Type == SnapshotTypeImage {
+		return deleteImageTag(snapshot.osClient.withContext(ctx).ImageClient, snapshot.SnapshotID, str)
+	}
+	return deleteMetadataTag(snapshot.osClient.withContext(ctx).BlockStorageClient, "snapshots", snapshot.SnapshotID, str)
+}
+
+func (snapshot *Snapshot) CreatedBefore(t time.Time) bool {
+	return snapshot.Created.Before(t)
+}
+
+func (snapshot *Snapshot) String() string {
+	return fmt.Sprintf("Kind: Snapshot Type: %s Name: %s ID: %s Project: %s",
+		snapshot.Type, snapshot.SnapshotName, snapshot.SnapshotID, snapshot.ProjectName)
+}
+
+// StringAll returns the searchable string include and exclude regexes match
+// by default, see SearchString()
+func (snapshot *Snapshot) StringAll() string {
+	return SearchString(snapshot.GetSearchFields())
+}
+
+// GetSearchFields adds the snapshot metadata, or the image properties
+func (snapshot *Snapshot) GetSearchFields() []SearchField {
+	var metadata []string
+	switch {
+	case snapshot.Snapshot != nil:
+		metadata = metadataValues(snapshot.Snapshot.Metadata)
+	case snapshot.Image != nil:
+		properties := make(map[string]string, len(snapshot.Image.Properties))
+		for key, value := range snapshot.Image.Properties {
+			if s, ok := value.(string); ok {
+				properties[key] = s
+			}
+		}
+		metadata = metadataValues(properties)
+	}
+	return append(commonSearchFields(snapshot), SearchField{"metadata", metadata})
+}
+
+func (snapshot *Snapshot) GetTags() []string {
+	return snapshot.Tags
+}
+
+func (snapshot *Snapshot) GetProjectName() string {
+	return snapshot.ProjectName
+}
+
+func (snapshot *Snapshot) GetProjectID() string {
+	return snapshot.ProjectID
+}
+
+func (snapshot *Snapshot) GetCloud() string {
+	return snapshot.Cloud
+}
+
+func (snapshot *Snapshot) GetRegion() string {
+	return snapshot.Region
+}
+
+func (snapshot *Snapshot) GetEmail() string {
+	return snapshot.Email
+}
+
+func (snapshot *Snapshot) GetCreated() time.Time {
+	return snapshot.Created
+}
+
+func (snapshot *Snapshot) GetUpdated() time.Time {
+	return snapshot.Updated
+}
+
+// addImageTag adds Glance image tag, unlike Cinder ones these are native
+func addImageTag(client *gophercloud.ServiceClient, id, tag string) error {
+	_, err := client.Put(client.ServiceURL("images", id, "tags", tag), nil, nil, &gophercloud.RequestOpts{
+		OkCodes: []int{204},
+	})
+
+	return err
+}
+
+func deleteImageTag(client *gophercloud.ServiceClient, id, tag string) error {
+	_, err := client.Delete(client.ServiceURL("images", id, "tags", tag), nil)
+	if _, ok := err.(gophercloud.ErrDefault404); ok {
+		err = nil
+	}
+
+	return err
+}
@@ -0,0 +1,134 @@
+package openstack
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jjo/openstack-ops/pkg/fakeopenstack"
+)
+
+// newFakeSnapshots adds to cloud volume snapshots vs1 (blocked by volume v1
+// created from it) and vs2, and server snapshot images i1 and i3 (protected)
+func newFakeSnapshots(cloud *fakeopenstack.Cloud) {
+	created := time.Now().AddDate(0, 0, -90)
+	cloud.AddSnapshot(fakeopenstack.Snapshot{ID: "vs1", Name: "base", ProjectID: "p1", Created: created, VolumeID: "v0"})
+	cloud.AddSnapshot(fakeopenstack.Snapshot{
+		ID: "vs2", Name: "backup", ProjectID: "p1", Created: created, Metadata: map[string]string{"purpose": "thesis"},
+	})
+	cloud.AddVolume(fakeopenstack.Volume{ID: "v1", ProjectID: "p1", SnapshotID: "vs1"})
+	snapshot := map[string]string{"image_type": "snapshot", "instance_uuid": "s0"}
+	cloud.AddImage(fakeopenstack.Image{ID: "i1", Name: "one-snap", ProjectID: "p1", Created: created, Properties: snapshot})
+	cloud.AddImage(fakeopenstack.Image{ID: "i2", Name: "ubuntu", ProjectID: "p1", Created: created})
+	cloud.AddImage(fakeopenstack.Image{
+		ID: "i3", Name: "golden", ProjectID: "p1", Created: created, Protected: true, Tags: []string{"keep"}, Properties: snapshot,
+	})
+}
+
+func TestGetSnapshots(t *testing.T) {
+	cloud := newFakeCloud(t)
+	newFakeSnapshots(cloud)
+	// Safety ones, as taken by WithSnapshotBeforeDelete(), are left to
+	// GetSafetySnapshots() to purge once expired
+	safety := map[string]string{
+		"image_type": "snapshot", SafetyExpiresKey: time.Now().AddDate(0, 0, 30).UTC().Format(time.DateOnly),
+	}
+	created := time.Now().AddDate(0, 0, -90)
+	cloud.AddImage(fakeopenstack.Image{
+		ID: "si1", Name: "os-cleanup-one", ProjectID: "p1", Created: created, Properties: safety,
+	})
+	cloud.AddSnapshot(fakeopenstack.Snapshot{
+		ID: "svs1", Name: "os-cleanup-one-v0", ProjectID: "p1", Created: created, VolumeID: "v0", Metadata: safety,
+	})
+	osClient, err := NewOSClient(CloudConfig{Cloud: "fake"})
+	require.NoError(t, err)
+	resources, err := osClient.GetSnapshots(context.Background(), func(OSResourceInterface) bool { return true })
+	require.NoError(t, err)
+
+	type listed struct {
+		Type      string
+		Blocked   bool
+		Dependent []string
+		Tags      []string
+	}
+	found := make(map[string]listed)
+	for _, resource := range resources {
+		snapshot := resource.(*Snapshot)
+		require.Equal(t, "foo__bar.com_project", snapshot.ProjectName)
+		require.True(t, snapshot.CreatedBefore(time.Now().AddDate(0, 0, -60)))
+		found[snapshot.SnapshotID] = listed{snapshot.Type, snapshot.Blocked, snapshot.DependentVolumes, snapshot.Tags}
+	}
+	// Not uploaded images (i2), only the ones taken of servers, nor safety
+	// ones (si1, svs1)
+	require.Equal(t, map[string]listed{
+		"vs1": {SnapshotTypeVolume, true, []string{"v1"}, []string{}},
+		"vs2": {SnapshotTypeVolume, false, []string{}, []string{}},
+		"i1":  {SnapshotTypeImage, false, []string{}, []string{}},
+		"i3":  {SnapshotTypeImage, true, []string{}, []string{"keep"}},
+	}, found)
+}
+
+func TestSnapshotActions(t *testing.T) {
+	cloud := newFakeCloud(t)
+	newFakeSnapshots(cloud)
+	osClient, err := NewOSClient(CloudConfig{Cloud: "fake"})
+	require.NoError(t, err)
+	resources, err := osClient.GetSnapshots(context.Background(), func(OSResourceInterface) bool { return true })
+	require.NoError(t, err)
+	byID := make(map[string]*Snapshot)
+	for _, resource := range resources {
+		byID[resource.(*Snapshot).SnapshotID] = resource.(*Snapshot)
+	}
+	ctx := context.Background()
+
+	image := func(id string) (fakeopenstack.Image, bool) {
+		for _, image := range cloud.Images() {
+			if image.ID == id {
+				return image, true
+			}
+		}
+		return fakeopenstack.Image{}, false
+	}
+	snapshot := func(id string) (fakeopenstack.Snapshot, bool) {
+		for _, snapshot := range cloud.Snapshots() {
+			if snapshot.ID == id {
+				return snapshot, true
+			}
+		}
+		return fakeopenstack.Snapshot{}, false
+	}
+
+	// Volume snapshots are tagged as metadata, images natively
+	require.NoError(t, byID["vs2"].Tag(ctx, "os-cleanup"))
+	vs2, _ := snapshot("vs2")
+	require.Equal(t, map[string]string{"purpose": "thesis", "os-cleanup": "true"}, vs2.Metadata)
+	require.NoError(t, byID["vs2"].Untag(ctx, "os-cleanup"))
+	vs2, _ = snapshot("vs2")
+	require.Equal(t, map[string]string{"purpose": "thesis"}, vs2.Metadata)
+
+	require.NoError(t, byID["i1"].Tag(ctx, "os-cleanup"))
+	i1, _ := image("i1")
+	require.Equal(t, []string{"os-cleanup"}, i1.Tags)
+	require.NoError(t, byID["i1"].Untag(ctx, "os-cleanup"))
+	// Already gone tags are fine
+	require.NoError(t, byID["i1"].Untag(ctx, "os-cleanup"))
+	i1, _ = image("i1")
+	require.Equal(t, []string{}, i1.Tags)
+
+	// Blocked ones are refused before asking the API
+	require.ErrorContains(t, byID["vs1"].Delete(ctx), "blocked by dependent volumes")
+	require.ErrorContains(t, byID["i3"].Delete(ctx), "is protected")
+	_, ok := snapshot("vs1")
+	require.True(t, ok)
+	_, ok = image("i3")
+	require.True(t, ok)
+
+	require.NoError(t, byID["vs2"].Delete(ctx))
+	require.NoError(t, byID["i1"].Delete(ctx))
+	_, ok = snapshot("vs2")
+	require.False(t, ok)
+	_, ok = image("i1")
+	require.False(t, ok)
+}
@@ -0,0 +1,228 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/volumetenants"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/pagination"
+)
+
+type Volume struct {
+	osClient    *OSClient
+	Volume      *volumes.Volume
+	VolumeName  string    `json:"name"`
+	VolumeID    string    `json:"id"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+	ProjectName string    `json:"project"`
+	ProjectID   string    `json:"project_id"`
+	Cloud       string    `json:"cloud"`
+	Region      string    `json:"region"`
+	Email       string    `json:"email"`
+	EmailSource string    `json:"email_source"`
+	Status      string    `json:"status"`
+	Size        int       `json:"size"`
+	AttachedTo  []string  `json:"attached_to"`
+	Tags        []string  `json:"tags"`
+}
+
+type VolumeWithExt struct {
+	volumes.Volume
+	volumetenants.VolumeTenantExt
+}
+
+func GetVolumeRowHeader() []interface{} {
+	return []interface{}{"Volume_Name", "Volume_ID", "Created", "Status", "Size", "Attached_To", "Cloud", "Region", "Project", "Email", "Email_Source", "Tags"}
+}
+
+func (osClient *OSClient) withBlockStorageClient() (*OSClient, error) {
+	if osClient.BlockStorageClient != nil {
+		return osClient, nil
+	}
+
+	blockStorageClient, err := openstack.NewBlockStorageV3(osClient.ProviderClient, osClient.endpointOpts)
+	if err != nil {
+		return nil, newError(ErrEndpoint, "create BlockStorage service client", err)
+	}
+	osClient.BlockStorageClient = blockStorageClient
+
+	return osClient, nil
+}
+
+// GetVolumes returns filtered volumes from all projects, `in-use` (attached)
+// ones are skipped unless inUse is set
+func (osClient *OSClient) GetVolumes(
+	ctx context.Context, filter func(OSResourceInterface) bool, inUse bool,
+) ([]OSResourceInterface, error) {
+	osClient, err := osClient.withProjectsCache(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	osClient, err = osClient.withBlockStorageClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]OSResourceInterface, 0)
+	err = volumes.List(osClient.withContext(ctx).BlockStorageClient, volumes.ListOpts{
+		AllTenants: true,
+	}).EachPage(func(page pagination.Page) (bool, error) {
+		var pageVolumes []VolumeWithExt
+		if err := volumes.ExtractVolumesInto(page, &pageVolumes); err != nil {
+			return false, err
+		}
+		for i := range pageVolumes {
+			volume := newVolume(ctx, osClient, &pageVolumes[i])
+			if volume.Status == "in-use" && !inUse {
+				continue
+			}
+			if filter(volume) {
+				resources = append(resources, volume)
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, listError("volumes", err)
+	}
+
+	return resources, nil
+}
+
+func newVolume(ctx context.Context, osClient *OSClient, v *VolumeWithExt) *Volume {
+	volume := &Volume{
+		osClient:    osClient,
+		Cloud:       osClient.cloud,
+		Region:      osClient.endpointOpts.Region,
+		Volume:      &v.Volume,
+		VolumeName:  v.Name,
+		VolumeID:    v.ID,
+		Created:     v.CreatedAt,
+		Updated:     v.UpdatedAt,
+		ProjectName: osClient.projectName(v.TenantID),
+		ProjectID:   v.TenantID,
+		Status:      v.Status,
+		Size:        v.Size,
+		AttachedTo:  make([]string, 0, len(v.Attachments)),
+		Tags:        metadataTags(v.Metadata),
+	}
+	for _, attachment := range v.Attachments {
+		volume.AttachedTo = append(volume.AttachedTo, attachment.ServerID)
+	}
+	volume.Email, volume.EmailSource = osClient.resolveOwner(ctx, volume)
+
+	return volume
+}
+
+func (volume *Volume) GetKind() string {
+	return "volume"
+}
+
+func (volume *Volume) GetData() (string, string, string) {
+	return volume.VolumeID, volume.VolumeName, volume.ProjectName
+}
+
+func (volume *Volume) GetRow() []interface{} {
+	return []interface{}{
+		volume.VolumeName,
+		volume.VolumeID,
+		volume.Created,
+		volume.Status,
+		volume.Size,
+		volume.AttachedTo,
+		volume.Cloud,
+		volume.Region,
+		volume.ProjectName,
+		volume.Email,
+		volume.EmailSource,
+		volume.Tags,
+	}
+}
+
+func (volume *Volume) GetFields() map[string]interface{} {
+	fields := commonFields(volume)
+	fields["email_source"] = volume.EmailSource
+	fields["status"] = volume.Status
+	fields["size"] = volume.Size
+	fields["attached_to"] = volume.AttachedTo
+	return fields
+}
+
+func (volume *Volume) Delete(ctx context.Context) error {
+	return volumes.Delete(volume.osClient.withContext(ctx).BlockStorageClient, volume.VolumeID, volumes.DeleteOpts{}).ExtractErr()
+}
+
+func (volume *Volume) Tag(ctx context.Context, str string) error {
+	return addMetadataTag(volume.osClient.withContext(ctx).BlockStorageClient, "volumes", volume.VolumeID, str)
+}
+
+func (volume *Volume) Untag(ctx context.Context, str string) error {
+	return deleteMetadataTag(volume.osClient.withContext(ctx).BlockStorageClient, "volumes", volume.VolumeID, str)
+}
+
+func (volume *Volume) CreatedBefore(t time.Time) bool {
+	return volume.Created.Before(t)
+}
+
+func (volume *Volume) String() string {
+	return fmt.Sprintf("Kind: Volume Name: %s ID: %s Project: %s",
+		volume.VolumeName, volume.VolumeID, volume.ProjectName)
+}
+
+// StringAll returns the searchable string include and exclude regexes match
+// by default, see SearchString()
+func (volume *Volume) StringAll() string {
+	return SearchString(volume.GetSearchFields())
+}
+
+// GetSearchFields adds the volume metadata and the name of its source image
+func (volume *Volume) GetSearchFields() []SearchField {
+	var metadata, image []string
+	if volume.Volume != nil {
+		metadata = metadataValues(volume.Volume.Metadata)
+		if name := volume.Volume.VolumeImageMetadata["image_name"]; name != "" {
+			image = []string{name}
+		}
+	}
+	return append(commonSearchFields(volume),
+		SearchField{"metadata", metadata},
+		SearchField{"image", image},
+	)
+}
+
+func (volume *Volume) GetTags() []string {
+	return volume.Tags
+}
+
+func (volume *Volume) GetProjectName() string {
+	return volume.ProjectName
+}
+
+func (volume *Volume) GetProjectID() string {
+	return volume.ProjectID
+}
+
+func (volume *Volume) GetCloud() string {
+	return volume.Cloud
+}
+
+func (volume *Volume) GetRegion() string {
+	return volume.Region
+}
+
+func (volume *Volume) GetEmail() string {
+	return volume.Email
+}
+
+func (volume *Volume) GetCreated() time.Time {
+	return volume.Created
+}
+
+func (volume *Volume) GetUpdated() time.Time {
+	return volume.Updated
+}
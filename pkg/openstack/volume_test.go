@@ -0,0 +1,83 @@
+package openstack
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jjo/openstack-ops/pkg/fakeopenstack"
+)
+
+func TestGetVolumes(t *testing.T) {
+	cloud := newFakeCloud(t)
+	cloud.AddVolume(fakeopenstack.Volume{
+		ID: "v1", Name: "data", ProjectID: "p1", Created: time.Now().AddDate(0, 0, -90), Size: 10,
+		Metadata: map[string]string{"os-cleanup": "true", "purpose": "thesis"},
+	})
+	cloud.AddVolume(fakeopenstack.Volume{ID: "v2", Name: "root", ProjectID: "p1", AttachedTo: []string{"s1"}})
+	osClient, err := NewOSClient(CloudConfig{Cloud: "fake"})
+	require.NoError(t, err)
+	all := func(OSResourceInterface) bool { return true }
+
+	resources, err := osClient.GetVolumes(context.Background(), all, false)
+	require.NoError(t, err)
+	require.Len(t, resources, 1)
+	volume := resources[0].(*Volume)
+	require.Equal(t, "v1", volume.VolumeID)
+	require.Equal(t, "foo__bar.com_project", volume.ProjectName)
+	require.Equal(t, "available", volume.Status)
+	require.Equal(t, []string{"os-cleanup"}, volume.Tags)
+	require.Equal(t, "RegionOne", volume.Region)
+
+	// In-use (attached) volumes only if asked so
+	resources, err = osClient.GetVolumes(context.Background(), all, true)
+	require.NoError(t, err)
+	require.Len(t, resources, 2)
+	require.Equal(t, []string{"s1"}, resources[1].(*Volume).AttachedTo)
+}
+
+func TestVolumeActions(t *testing.T) {
+	cloud := newFakeCloud(t)
+	cloud.AddVolume(fakeopenstack.Volume{ID: "v1", ProjectID: "p1", Metadata: map[string]string{"purpose": "thesis"}})
+	cloud.AddVolume(fakeopenstack.Volume{ID: "v2", ProjectID: "p1", AttachedTo: []string{"s1"}})
+	osClient, err := NewOSClient(CloudConfig{Cloud: "fake"})
+	require.NoError(t, err)
+	resources, err := osClient.GetVolumes(context.Background(), func(OSResourceInterface) bool { return true }, true)
+	require.NoError(t, err)
+	require.Len(t, resources, 2)
+	volume, attached := resources[0].(*Volume), resources[1].(*Volume)
+	ctx := context.Background()
+
+	metadata := func(id string) map[string]string {
+		volume, ok := cloud.GetVolume(id)
+		require.True(t, ok)
+		return volume.Metadata
+	}
+
+	// Tags are metadata keys, the rest of the metadata left untouched
+	require.NoError(t, volume.Tag(ctx, "os-cleanup"))
+	require.NoError(t, volume.Tag(ctx, "os-cleanup-notified"))
+	require.Equal(t, map[string]string{"purpose": "thesis", "os-cleanup": "true", "os-cleanup-notified": "true"}, metadata("v1"))
+	require.NoError(t, volume.Untag(ctx, "os-cleanup"))
+	require.Equal(t, map[string]string{"purpose": "thesis", "os-cleanup-notified": "true"}, metadata("v1"))
+	// Already gone tags are fine
+	require.NoError(t, volume.Untag(ctx, "os-cleanup"))
+	require.Equal(t, 2, cloud.Requests(http.MethodDelete, fakeopenstack.BlockStoragePath+"volumes/v1/metadata/os-cleanup"))
+
+	cloud.Fail(fakeopenstack.Failure{
+		Method: http.MethodPost, Path: fakeopenstack.BlockStoragePath + "volumes/v1/metadata", Status: http.StatusForbidden,
+	})
+	require.Error(t, volume.Tag(ctx, "os-cleanup"))
+	require.NotContains(t, metadata("v1"), "os-cleanup")
+
+	// Cinder refuses to delete attached volumes
+	require.Error(t, attached.Delete(ctx))
+	_, ok := cloud.GetVolume("v2")
+	require.True(t, ok)
+	require.NoError(t, volume.Delete(ctx))
+	_, ok = cloud.GetVolume("v1")
+	require.False(t, ok)
+}
@@ -0,0 +1,115 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/resetstate"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+)
+
+// pollInterval between waitFor() checks, a var to be shortened by tests
+var pollInterval = 5 * time.Second
+
+// waitFor polls check() until it returns true, an error, timeout expires or
+// ctx is done
+func waitFor(ctx context.Context, what string, timeout time.Duration, check func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		done, err := check()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout after %s waiting for %s", timeout, what)
+		}
+		if err := sleepContext(ctx, pollInterval); err != nil {
+			return fmt.Errorf("waiting for %s: %w", what, err)
+		}
+	}
+}
+
+// sleepContext sleeps for d, unless ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// WithWait makes Instance Stop(), Start() and Delete() wait up to timeout for
+// the server to reach the expected state, or be gone. With resetState, a
+// failed stop or delete is retried once after an admin reset-state
+func (osClient *OSClient) WithWait(timeout time.Duration, resetState bool) OSClientInterface {
+	log.Debugf("Setting wait timeout to: %s, reset-state: %v", timeout, resetState)
+	osClient.waitTimeout = timeout
+	osClient.resetState = resetState
+	return osClient
+}
+
+func (instance *Instance) getServer(compute *gophercloud.ServiceClient) (*ServerWithExt, error) {
+	server := &ServerWithExt{}
+	err := servers.Get(compute, instance.InstanceID).ExtractInto(server)
+	return server, err
+}
+
+// waitState polls the server until its vm_state is vmState with no task
+// pending, or until it's gone if vmState is empty
+func (instance *Instance) waitState(ctx context.Context, vmState string) error {
+	what := fmt.Sprintf("server %s to be %s", instance.InstanceID, vmState)
+	if vmState == "" {
+		what = fmt.Sprintf("server %s to be deleted", instance.InstanceID)
+	}
+	compute := instance.osClient.withContext(ctx).ComputeClient
+	return waitFor(ctx, what, instance.osClient.waitTimeout, func() (bool, error) {
+		server, err := instance.getServer(compute)
+		if _, ok := err.(gophercloud.ErrDefault404); ok && vmState == "" {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		log.Debugf("Waiting for %s: vm_state=%s task_state=%s", what, server.VmState, server.TaskState)
+		if server.VmState == "error" {
+			return false, fmt.Errorf("server %s in ERROR state", instance.InstanceID)
+		}
+		return vmState != "" && server.VmState == vmState && server.TaskState == "", nil
+	})
+}
+
+// waitAction runs action with a ComputeClient bound to ctx, then if WithWait()
+// waits for the server to reach vmState. If that fails and resetState is set
+// and resetTo not empty, resets the server state to resetTo and retries
+// action once
+func (instance *Instance) waitAction(
+	ctx context.Context, action func(*gophercloud.ServiceClient) error, vmState string, resetTo resetstate.ServerState,
+) error {
+	osClient := instance.osClient.withContext(ctx)
+	err := action(osClient.ComputeClient)
+	if err != nil || osClient.waitTimeout <= 0 {
+		return err
+	}
+
+	err = instance.waitState(ctx, vmState)
+	if err == nil || !osClient.resetState || resetTo == "" {
+		return err
+	}
+
+	log.Warningf("Resetting state to %s and retrying %s: %s", resetTo, instance.String(), err)
+	if errReset := resetstate.ResetState(osClient.ComputeClient, instance.InstanceID, resetTo).ExtractErr(); errReset != nil {
+		return fmt.Errorf("%w, then reset-state failed: %s", err, errReset)
+	}
+	if err := action(osClient.ComputeClient); err != nil {
+		return err
+	}
+	return instance.waitState(ctx, vmState)
+}
@@ -0,0 +1,122 @@
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNova serves GET /servers/<id> from a sequence of vm_states (the last
+// one repeating, "gone" meaning 404), and counts POST /servers/<id>/action
+type fakeNova struct {
+	mutex   sync.Mutex
+	states  []string
+	actions []string
+}
+
+func (nova *fakeNova) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	nova.mutex.Lock()
+	defer nova.mutex.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/action"):
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		for action := range body {
+			nova.actions = append(nova.actions, action)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodDelete:
+		nova.actions = append(nova.actions, "delete")
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet:
+		state := nova.states[0]
+		if len(nova.states) > 1 {
+			nova.states = nova.states[1:]
+		}
+		if state == "gone" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"server": map[string]interface{}{"id": "s1", "OS-EXT-STS:vm_state": state},
+		})
+	}
+}
+
+func newFakeNovaInstance(t *testing.T, nova *fakeNova, resetState bool) *Instance {
+	server := httptest.NewServer(nova)
+	t.Cleanup(server.Close)
+
+	osClient := &OSClient{
+		ComputeClient: &gophercloud.ServiceClient{
+			ProviderClient: &gophercloud.ProviderClient{HTTPClient: *http.DefaultClient},
+			Endpoint:       server.URL + "/",
+		},
+	}
+	osClient.WithWait(time.Second, resetState)
+	return &Instance{osClient: osClient, InstanceID: "s1", InstanceName: "one"}
+}
+
+func TestInstanceWait(t *testing.T) {
+	pollInterval = time.Millisecond
+
+	tests := []struct {
+		name        string
+		states      []string
+		resetState  bool
+		run         func(*Instance, context.Context) error
+		wantErr     string
+		wantActions []string
+	}{
+		{"stop: stopped", []string{"active", "active", "stopped"}, false, (*Instance).Stop, "", []string{"os-stop"}},
+		{"stop: error", []string{"active", "error"}, false, (*Instance).Stop, "ERROR state", []string{"os-stop"}},
+		{"stop: timeout", []string{"active"}, false, (*Instance).Stop, "timeout", []string{"os-stop"}},
+		{
+			"stop: error, reset-state and retry", []string{"error", "stopped"}, true, (*Instance).Stop,
+			"", []string{"os-stop", "os-resetState", "os-stop"},
+		},
+		{"delete: gone", []string{"active", "deleted", "gone"}, false, (*Instance).Delete, "", []string{"delete"}},
+		{
+			"delete: error, reset-state and retry", []string{"error", "gone"}, true, (*Instance).Delete,
+			"", []string{"delete", "os-resetState", "delete"},
+		},
+		{"start: active", []string{"stopped", "active"}, false, (*Instance).Start, "", []string{"os-start"}},
+		{
+			"stop: canceled while waiting", []string{"active"}, false,
+			func(instance *Instance, ctx context.Context) error {
+				ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+				defer cancel()
+				return instance.Stop(ctx)
+			},
+			"deadline exceeded", []string{"os-stop"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nova := &fakeNova{states: tt.states}
+			instance := newFakeNovaInstance(t, nova, tt.resetState)
+			if strings.Contains(tt.name, "timeout") {
+				instance.osClient.waitTimeout = 20 * time.Millisecond
+			}
+
+			err := tt.run(instance, context.Background())
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.wantActions, nova.actions)
+		})
+	}
+}
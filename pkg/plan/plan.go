@@ -0,0 +1,135 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jjo/openstack-ops/pkg/openstack"
+)
+
+// Item is a single resource to be acted upon, with enough of its state to
+// detect it changed between planning and applying
+type Item struct {
+	Kind      string    `json:"kind"`
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Project   string    `json:"project"`
+	ProjectID string    `json:"project_id"`
+	Cloud     string    `json:"cloud,omitempty"`
+	Region    string    `json:"region,omitempty"`
+	Email     string    `json:"email"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+	Action    string    `json:"action"`
+}
+
+// Options change how the planned action is done, e.g. delete taking safety
+// snapshots first, so that apply does it as planned
+type Options struct {
+	SnapshotBeforeDelete bool          `json:"snapshot_before_delete,omitempty"`
+	SnapshotRetention    int           `json:"snapshot_retention,omitempty"`
+	SnapshotTimeout      time.Duration `json:"snapshot_timeout,omitempty"`
+	Wait                 bool          `json:"wait,omitempty"`
+	WaitTimeout          time.Duration `json:"wait_timeout,omitempty"`
+	ResetState           bool          `json:"reset_state,omitempty"`
+}
+
+// Plan is the reviewable list of actions written by --plan, to be run later
+// by `apply`
+type Plan struct {
+	Created  time.Time `json:"created"`
+	Operator string    `json:"operator"`
+	Kind     string    `json:"kind"`
+	Action   string    `json:"action"`
+	TagValue string    `json:"tag_value,omitempty"`
+	Filter   string    `json:"filter"`
+	Options  Options   `json:"options"`
+	Items    []Item    `json:"items"`
+}
+
+func New(
+	operator, kind, action, tagValue, filter string, options Options, resources []openstack.OSResourceInterface,
+) *Plan {
+	plan := &Plan{
+		Created:  time.Now().UTC(),
+		Operator: operator,
+		Kind:     kind,
+		Action:   action,
+		TagValue: tagValue,
+		Filter:   filter,
+		Options:  options,
+		Items:    make([]Item, 0, len(resources)),
+	}
+	for _, resource := range resources {
+		id, name, project := resource.GetData()
+		plan.Items = append(plan.Items, Item{
+			Kind:      resource.GetKind(),
+			ID:        id,
+			Name:      name,
+			Project:   project,
+			ProjectID: resource.GetProjectID(),
+			Cloud:     resource.GetCloud(),
+			Region:    resource.GetRegion(),
+			Email:     resource.GetEmail(),
+			Created:   resource.GetCreated(),
+			Updated:   resource.GetUpdated(),
+			Action:    action,
+		})
+	}
+	return plan
+}
+
+func (plan *Plan) Write(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("Failed to create plan dir: %w", err)
+	}
+	jsonData, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(jsonData, '\n'), 0o640)
+}
+
+func Load(path string) (*Plan, error) {
+	jsonData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read plan: %w", err)
+	}
+	plan := &Plan{}
+	if err := json.Unmarshal(jsonData, plan); err != nil {
+		return nil, fmt.Errorf("Invalid plan %s: %w", path, err)
+	}
+	return plan, nil
+}
+
+// IDs returns the set of planned resource IDs
+func (plan *Plan) IDs() map[string]bool {
+	ids := make(map[string]bool, len(plan.Items))
+	for _, item := range plan.Items {
+		ids[item.ID] = true
+	}
+	return ids
+}
+
+// Verify checks resource (as found at apply time) still is what was planned
+func (item *Item) Verify(resource openstack.OSResourceInterface) error {
+	id, name, project := resource.GetData()
+	switch {
+	case id != item.ID:
+		return fmt.Errorf("ID changed: %s -> %s", item.ID, id)
+	case name != item.Name:
+		return fmt.Errorf("name changed: %q -> %q", item.Name, name)
+	case resource.GetCloud() != item.Cloud || resource.GetRegion() != item.Region:
+		return fmt.Errorf("location changed: cloud=%s region=%s -> cloud=%s region=%s",
+			item.Cloud, item.Region, resource.GetCloud(), resource.GetRegion())
+	case resource.GetProjectID() != item.ProjectID:
+		return fmt.Errorf("project changed: %s -> %s (%s)", item.ProjectID, resource.GetProjectID(), project)
+	case !resource.GetUpdated().Equal(item.Updated):
+		return fmt.Errorf("modified since planned: updated %s -> %s",
+			item.Updated.Format(time.RFC3339), resource.GetUpdated().Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package plan
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jjo/openstack-ops/pkg/openstack"
+)
+
+func newVolume(name, projectID string, updated time.Time) *openstack.Volume {
+	return &openstack.Volume{
+		VolumeName:  name,
+		VolumeID:    "v1",
+		ProjectName: "foo",
+		ProjectID:   projectID,
+		Updated:     updated,
+		Tags:        []string{},
+	}
+}
+
+func TestWriteLoadVerify(t *testing.T) {
+	updated := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	resources := []openstack.OSResourceInterface{newVolume("vol", "foo-id", updated)}
+
+	path := filepath.Join(t.TempDir(), "out", "plan.json")
+	options := Options{SnapshotBeforeDelete: true, SnapshotRetention: 30, SnapshotTimeout: 30 * time.Minute}
+	require.NoError(t, New("admin", "volume", "delete", "", "created<...", options, resources).Write(path))
+
+	plan, err := Load(path)
+	require.NoError(t, err)
+	require.Equal(t, "delete", plan.Action)
+	require.Equal(t, "admin", plan.Operator)
+	require.Equal(t, options, plan.Options)
+	require.Len(t, plan.Items, 1)
+	require.Equal(t, map[string]bool{"v1": true}, plan.IDs())
+
+	item := plan.Items[0]
+	require.NoError(t, item.Verify(newVolume("vol", "foo-id", updated)))
+	require.ErrorContains(t, item.Verify(newVolume("other", "foo-id", updated)), "name changed")
+	require.ErrorContains(t, item.Verify(newVolume("vol", "bar-id", updated)), "project changed")
+	require.ErrorContains(t, item.Verify(newVolume("vol", "foo-id", updated.Add(time.Second))), "modified since planned")
+
+	_, err = Load(filepath.Join(t.TempDir(), "missing.json"))
+	require.ErrorContains(t, err, "Failed to read plan")
+}
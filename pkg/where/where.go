@@ -0,0 +1,604 @@
+// Package where parses and evaluates filter expressions over resource fields,
+// e.g.:
+//
+//	project =~ "alumno" && power_state == "RUNNING" && created < now - 90d && !("keep" in tags)
+//
+// Operands are fields (see openstack.KindFields), `now`, and literals:
+// "strings", numbers, durations (90d, 1w, 12h, 30m, 45s), true, false and
+// ["lists", "of strings"]. Operators, from lowest to highest precedence:
+//
+//	||
+//	&&
+//	!
+//	== != < <= > >= =~ !~ in
+//	+ -
+//
+// `=~` and `!~` match a regex, `x in list` tells membership and `x in str`
+// a substring. `+` and `-` add or subtract durations to times (or durations),
+// and subtract times. Expressions are type checked when parsed
+package where
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Error is a syntax or type error at Column (1-based) of the expression
+type Error struct {
+	Column int
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("at column %d: %s", e.Column, e.Msg)
+}
+
+type valueType int
+
+const (
+	typeString valueType = iota
+	typeNumber
+	typeBool
+	typeTime
+	typeDuration
+	typeList
+)
+
+func (t valueType) String() string {
+	return [...]string{"string", "number", "bool", "time", "duration", "list"}[t]
+}
+
+// typeOf returns the type of a field value, false if not supported
+func typeOf(value interface{}) (valueType, bool) {
+	switch value.(type) {
+	case string:
+		return typeString, true
+	case int, int64, float64:
+		return typeNumber, true
+	case bool:
+		return typeBool, true
+	case time.Time:
+		return typeTime, true
+	case time.Duration:
+		return typeDuration, true
+	case []string:
+		return typeList, true
+	}
+	return 0, false
+}
+
+// normalize value to the one Go type used for its valueType
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return value
+}
+
+var zeroValues = map[valueType]interface{}{
+	typeString:   "",
+	typeNumber:   float64(0),
+	typeBool:     false,
+	typeTime:     time.Time{},
+	typeDuration: time.Duration(0),
+	typeList:     []string(nil),
+}
+
+// Expr is a parsed expression, safe for concurrent use
+type Expr struct {
+	src  string
+	eval evalFunc
+}
+
+type evalFunc func(fields map[string]interface{}) interface{}
+
+// Parse src into an Expr over fields (names and values of their types, as
+// returned by openstack.KindFields), `now` being the current time
+func Parse(src string, fields map[string]interface{}) (*Expr, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, fields: fields, now: time.Now()}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %s", tok)
+	}
+	if root.typ != typeBool {
+		return nil, &Error{Column: 1, Msg: fmt.Sprintf("expression must be true or false, not a %s", root.typ)}
+	}
+	return &Expr{src: src, eval: root.eval}, nil
+}
+
+// Eval tells if fields (a resource GetFields()) match the expression
+func (expr *Expr) Eval(fields map[string]interface{}) bool {
+	return expr.eval(fields).(bool)
+}
+
+func (expr *Expr) String() string {
+	return expr.src
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokDuration
+	tokOp
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	pos   int
+	value interface{}
+}
+
+func (tok token) String() string {
+	if tok.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(tok.text)
+}
+
+var durationUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// lex splits src into tokens
+func lex(src string) ([]token, error) {
+	tokens := make([]token, 0)
+	for pos := 0; pos < len(src); {
+		c := rune(src[pos])
+		start := pos
+		switch {
+		case unicode.IsSpace(c):
+			pos++
+			continue
+		case c == '_' || unicode.IsLetter(c):
+			for pos < len(src) && (src[pos] == '_' || unicode.IsLetter(rune(src[pos])) || unicode.IsDigit(rune(src[pos]))) {
+				pos++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[start:pos], pos: start})
+		case unicode.IsDigit(c):
+			for pos < len(src) && (unicode.IsDigit(rune(src[pos])) || src[pos] == '.') {
+				pos++
+			}
+			number, err := strconv.ParseFloat(src[start:pos], 64)
+			if err != nil {
+				return nil, &Error{Column: start + 1, Msg: fmt.Sprintf("invalid number %q", src[start:pos])}
+			}
+			unitStart := pos
+			for pos < len(src) && unicode.IsLetter(rune(src[pos])) {
+				pos++
+			}
+			if unit := src[unitStart:pos]; unit != "" {
+				scale, ok := durationUnits[unit]
+				if !ok {
+					return nil, &Error{Column: unitStart + 1, Msg: fmt.Sprintf("invalid duration unit %q, use s, m, h, d or w", unit)}
+				}
+				tokens = append(tokens, token{kind: tokDuration, text: src[start:pos], pos: start, value: time.Duration(number * float64(scale))})
+				continue
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[start:pos], pos: start, value: number})
+		case c == '"':
+			for pos++; pos < len(src) && src[pos] != '"'; pos++ {
+				if src[pos] == '\\' {
+					pos++
+				}
+			}
+			if pos >= len(src) {
+				return nil, &Error{Column: start + 1, Msg: "unterminated string"}
+			}
+			pos++
+			str, err := strconv.Unquote(src[start:pos])
+			if err != nil {
+				return nil, &Error{Column: start + 1, Msg: fmt.Sprintf("invalid string %s", src[start:pos])}
+			}
+			tokens = append(tokens, token{kind: tokString, text: src[start:pos], pos: start, value: str})
+		default:
+			op := ""
+			for _, candidate := range []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "!", "<", ">", "+", "-", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(src[pos:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, &Error{Column: start + 1, Msg: fmt.Sprintf("unexpected character %q", c)}
+			}
+			pos += len(op)
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: start})
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+// operand is a parsed (sub)expression, literal set if constant
+type operand struct {
+	eval    evalFunc
+	typ     valueType
+	pos     int
+	literal interface{}
+}
+
+type parser struct {
+	tokens []token
+	i      int
+	fields map[string]interface{}
+	now    time.Time
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.i]
+	if tok.kind != tokEOF {
+		p.i++
+	}
+	return tok
+}
+
+// accept consumes the next token if it's one of ops
+func (p *parser) accept(ops ...string) (token, bool) {
+	tok := p.peek()
+	if tok.kind != tokOp {
+		return tok, false
+	}
+	for _, op := range ops {
+		if tok.text == op {
+			return p.next(), true
+		}
+	}
+	return tok, false
+}
+
+func (p *parser) errorf(tok token, format string, args ...interface{}) error {
+	return &Error{Column: tok.pos + 1, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) parseOr() (operand, error) {
+	return p.parseLogical("||", p.parseAnd)
+}
+
+func (p *parser) parseAnd() (operand, error) {
+	return p.parseLogical("&&", p.parseNot)
+}
+
+// parseLogical parses `operand (op operand)*`, short-circuiting at eval
+func (p *parser) parseLogical(op string, parseOperand func() (operand, error)) (operand, error) {
+	left, err := parseOperand()
+	if err != nil {
+		return left, err
+	}
+	for {
+		tok, ok := p.accept(op)
+		if !ok {
+			return left, nil
+		}
+		right, err := parseOperand()
+		if err != nil {
+			return right, err
+		}
+		for _, side := range []operand{left, right} {
+			if side.typ != typeBool {
+				return side, &Error{Column: side.pos + 1, Msg: fmt.Sprintf("%s needs true or false operands, not a %s", op, side.typ)}
+			}
+		}
+		l, r := left.eval, right.eval
+		eval := func(fields map[string]interface{}) interface{} {
+			return l(fields).(bool) && r(fields).(bool)
+		}
+		if op == "||" {
+			eval = func(fields map[string]interface{}) interface{} {
+				return l(fields).(bool) || r(fields).(bool)
+			}
+		}
+		left = operand{eval: eval, typ: typeBool, pos: tok.pos}
+	}
+}
+
+func (p *parser) parseNot() (operand, error) {
+	tok, ok := p.accept("!")
+	if !ok {
+		return p.parseCompare()
+	}
+	negated, err := p.parseNot()
+	if err != nil {
+		return negated, err
+	}
+	if negated.typ != typeBool {
+		return negated, p.errorf(tok, "! needs a true or false operand, not a %s", negated.typ)
+	}
+	eval := negated.eval
+	return operand{eval: func(fields map[string]interface{}) interface{} {
+		return !eval(fields).(bool)
+	}, typ: typeBool, pos: tok.pos}, nil
+}
+
+func (p *parser) parseCompare() (operand, error) {
+	left, err := p.parseSum()
+	if err != nil {
+		return left, err
+	}
+	tok := p.peek()
+	isIn := tok.kind == tokIdent && tok.text == "in"
+	if _, ok := p.accept("==", "!=", "<", "<=", ">", ">=", "=~", "!~"); !ok && !isIn {
+		return left, nil
+	}
+	if isIn {
+		p.next()
+	}
+	right, err := p.parseSum()
+	if err != nil {
+		return right, err
+	}
+	eval, err := p.compare(tok, left, right)
+	return operand{eval: eval, typ: typeBool, pos: tok.pos}, err
+}
+
+// compare returns the eval for `left op right`, op being tok
+func (p *parser) compare(tok token, left, right operand) (evalFunc, error) {
+	l, r := left.eval, right.eval
+	mismatch := func() error {
+		return p.errorf(tok, "can't compare a %s %s a %s", left.typ, tok.text, right.typ)
+	}
+	switch tok.text {
+	case "=~", "!~":
+		str, ok := right.literal.(string)
+		if left.typ != typeString || !ok {
+			return nil, p.errorf(tok, "%s needs a string on the left and a \"regex\" on the right", tok.text)
+		}
+		re, err := regexp.Compile(str)
+		if err != nil {
+			return nil, &Error{Column: right.pos + 1, Msg: fmt.Sprintf("invalid regex: %s", err)}
+		}
+		want := tok.text == "=~"
+		return func(fields map[string]interface{}) interface{} {
+			return re.MatchString(l(fields).(string)) == want
+		}, nil
+	case "in":
+		switch {
+		case left.typ == typeString && right.typ == typeList:
+			return func(fields map[string]interface{}) interface{} {
+				str := l(fields).(string)
+				for _, item := range r(fields).([]string) {
+					if item == str {
+						return true
+					}
+				}
+				return false
+			}, nil
+		case left.typ == typeString && right.typ == typeString:
+			return func(fields map[string]interface{}) interface{} {
+				return strings.Contains(r(fields).(string), l(fields).(string))
+			}, nil
+		}
+		return nil, p.errorf(tok, "in needs a string on the left and a list or string on the right, not %s in %s", left.typ, right.typ)
+	case "==", "!=":
+		if left.typ != right.typ || left.typ == typeList {
+			return nil, mismatch()
+		}
+		want := tok.text == "=="
+		return func(fields map[string]interface{}) interface{} {
+			return (compareValues(l(fields), r(fields)) == 0) == want
+		}, nil
+	}
+	if left.typ != right.typ || left.typ == typeList || left.typ == typeBool {
+		return nil, mismatch()
+	}
+	check := map[string]func(int) bool{
+		"<":  func(c int) bool { return c < 0 },
+		"<=": func(c int) bool { return c <= 0 },
+		">":  func(c int) bool { return c > 0 },
+		">=": func(c int) bool { return c >= 0 },
+	}[tok.text]
+	return func(fields map[string]interface{}) interface{} {
+		return check(compareValues(l(fields), r(fields)))
+	}, nil
+}
+
+// compareValues of the same type, -1, 0 or 1 as in strings.Compare()
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case float64:
+		return sign(a - b.(float64))
+	case time.Duration:
+		return sign(float64(a - b.(time.Duration)))
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	case bool:
+		if a == b.(bool) {
+			return 0
+		}
+		return 1
+	}
+	return 0
+}
+
+func sign(f float64) int {
+	switch {
+	case f < 0:
+		return -1
+	case f > 0:
+		return 1
+	}
+	return 0
+}
+
+func (p *parser) parseSum() (operand, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return left, err
+	}
+	for {
+		tok, ok := p.accept("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parsePrimary()
+		if err != nil {
+			return right, err
+		}
+		left, err = p.arithmetic(tok, left, right)
+		if err != nil {
+			return left, err
+		}
+	}
+}
+
+// arithmetic returns `left op right` for times, durations and numbers
+func (p *parser) arithmetic(tok token, left, right operand) (operand, error) {
+	l, r := left.eval, right.eval
+	minus := tok.text == "-"
+	result := operand{typ: left.typ, pos: left.pos}
+	switch {
+	case left.typ == typeTime && right.typ == typeDuration:
+		result.eval = func(fields map[string]interface{}) interface{} {
+			d := r(fields).(time.Duration)
+			if minus {
+				d = -d
+			}
+			return l(fields).(time.Time).Add(d)
+		}
+	case left.typ == typeTime && right.typ == typeTime && minus:
+		result.typ = typeDuration
+		result.eval = func(fields map[string]interface{}) interface{} {
+			return l(fields).(time.Time).Sub(r(fields).(time.Time))
+		}
+	case left.typ == typeDuration && right.typ == typeDuration:
+		result.eval = func(fields map[string]interface{}) interface{} {
+			if minus {
+				return l(fields).(time.Duration) - r(fields).(time.Duration)
+			}
+			return l(fields).(time.Duration) + r(fields).(time.Duration)
+		}
+	case left.typ == typeNumber && right.typ == typeNumber:
+		result.eval = func(fields map[string]interface{}) interface{} {
+			if minus {
+				return l(fields).(float64) - r(fields).(float64)
+			}
+			return l(fields).(float64) + r(fields).(float64)
+		}
+	default:
+		return result, p.errorf(tok, "can't %s a %s and a %s", map[bool]string{false: "add", true: "subtract"}[minus], left.typ, right.typ)
+	}
+	return result, nil
+}
+
+func (p *parser) parsePrimary() (operand, error) {
+	tok := p.next()
+	constant := func(typ valueType, value interface{}) (operand, error) {
+		return operand{
+			eval:    func(map[string]interface{}) interface{} { return value },
+			typ:     typ,
+			pos:     tok.pos,
+			literal: value,
+		}, nil
+	}
+	switch tok.kind {
+	case tokString:
+		return constant(typeString, tok.value)
+	case tokNumber:
+		return constant(typeNumber, tok.value)
+	case tokDuration:
+		return constant(typeDuration, tok.value)
+	case tokIdent:
+		switch tok.text {
+		case "true", "false":
+			return constant(typeBool, tok.text == "true")
+		case "now":
+			return constant(typeTime, p.now)
+		}
+		return p.field(tok)
+	case tokOp:
+		switch tok.text {
+		case "(":
+			inner, err := p.parseOr()
+			if err != nil {
+				return inner, err
+			}
+			if closing, ok := p.accept(")"); !ok {
+				return inner, p.errorf(closing, "expected \")\", got %s", closing)
+			}
+			return inner, nil
+		case "[":
+			return p.parseList(tok)
+		}
+	}
+	return operand{}, p.errorf(tok, "expected a field, value or \"(\", got %s", tok)
+}
+
+// parseList parses a `["literal", "strings"]` list, after its "["
+func (p *parser) parseList(open token) (operand, error) {
+	list := make([]string, 0)
+	for {
+		if _, ok := p.accept("]"); ok {
+			break
+		}
+		if len(list) > 0 {
+			if tok, ok := p.accept(","); !ok {
+				return operand{}, p.errorf(tok, "expected \",\" or \"]\", got %s", tok)
+			}
+		}
+		tok := p.next()
+		if tok.kind != tokString {
+			return operand{}, p.errorf(tok, "lists can only hold \"strings\", got %s", tok)
+		}
+		list = append(list, tok.value.(string))
+	}
+	return operand{
+		eval:    func(map[string]interface{}) interface{} { return list },
+		typ:     typeList,
+		pos:     open.pos,
+		literal: list,
+	}, nil
+}
+
+// field returns the operand for field named tok, its zero value if missing
+// when evaluated
+func (p *parser) field(tok token) (operand, error) {
+	value, ok := p.fields[tok.text]
+	if !ok {
+		names := make([]string, 0, len(p.fields))
+		for name := range p.fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return operand{}, p.errorf(tok, "unknown field %q, valid ones: %s", tok.text, strings.Join(names, ", "))
+	}
+	typ, ok := typeOf(value)
+	if !ok {
+		return operand{}, p.errorf(tok, "field %q of unsupported type %T", tok.text, value)
+	}
+	name, zero := tok.text, zeroValues[typ]
+	return operand{eval: func(fields map[string]interface{}) interface{} {
+		value, ok := fields[name]
+		if !ok || value == nil {
+			return zero
+		}
+		return normalize(value)
+	}, typ: typ, pos: tok.pos}, nil
+}
@@ -0,0 +1,86 @@
+package where
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testFields() map[string]interface{} {
+	return map[string]interface{}{
+		"name":        "vm1",
+		"project":     "jdoe__alumno.example.com_project",
+		"power_state": "RUNNING",
+		"created":     time.Now().AddDate(0, 0, -100),
+		"size":        10,
+		"blocked":     false,
+		"tags":        []string{"os-cleanup", "course"},
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`project =~ "alumno" && power_state == "RUNNING" && created < now - 90d && !("keep" in tags)`, true},
+		{`created < now - 90d`, true},
+		{`created < now - 15w`, false},
+		{`created > now - 2400h - 1m`, true},
+		{`now - created > 99d`, true},
+		{`"course" in tags`, true},
+		{`!("course" in tags)`, false},
+		{`power_state in ["SHUTDOWN", "RUNNING"]`, true},
+		{`"alumno" in project`, true},
+		{`project !~ "^jdoe__"`, false},
+		{`name == "vm2" || size >= 10`, true},
+		{`name == "vm2" || size > 10`, false},
+		{`size + 1 == 11 && !blocked`, true},
+		{`blocked == false && (name < "vm2")`, true},
+		{`missing_value == ""`, true},
+	}
+	// Fields missing from a resource evaluate to their zero value
+	kindFields := testFields()
+	kindFields["missing_value"] = ""
+	fields := testFields()
+	for _, tt := range tests {
+		expr, err := Parse(tt.expr, kindFields)
+		require.NoError(t, err, tt.expr)
+		require.Equal(t, tt.want, expr.Eval(fields), tt.expr)
+		require.Equal(t, tt.expr, expr.String())
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{``, `at column 1: expected a field, value or "(", got end of expression`},
+		{`name`, `at column 1: expression must be true or false, not a string`},
+		{`nmae == "vm1"`, `at column 1: unknown field "nmae", valid ones: blocked, created, name, power_state, project, size, tags`},
+		{`name == "vm1`, `at column 9: unterminated string`},
+		{`name == "vm1" &&`, `at column 17: expected a field, value or "(", got end of expression`},
+		{`(name == "vm1"`, `at column 15: expected ")", got end of expression`},
+		{`name == "vm1")`, `at column 14: unexpected ")"`},
+		{`name = "vm1"`, `at column 6: unexpected character '='`},
+		{`created < now - 90y`, `at column 19: invalid duration unit "y", use s, m, h, d or w`},
+		{`created < 90d`, `at column 9: can't compare a time < a duration`},
+		{`size == "10"`, `at column 6: can't compare a number == a string`},
+		{`tags == ["a"]`, `at column 6: can't compare a list == a list`},
+		{`name =~ project`, `at column 6: =~ needs a string on the left and a "regex" on the right`},
+		{`name =~ "("`, "at column 9: invalid regex: error parsing regexp: missing closing ): `(`"},
+		{`size in tags`, `at column 6: in needs a string on the left and a list or string on the right, not number in list`},
+		{`!name`, `at column 1: ! needs a true or false operand, not a string`},
+		{`blocked && size`, `at column 12: && needs true or false operands, not a number`},
+		{`created + now < now`, `at column 9: can't add a time and a time`},
+		{`tags == [1]`, `at column 10: lists can only hold "strings", got "1"`},
+	}
+	fields := testFields()
+	for _, tt := range tests {
+		_, err := Parse(tt.expr, fields)
+		require.EqualError(t, err, tt.wantErr, tt.expr)
+		require.IsType(t, &Error{}, err)
+	}
+}